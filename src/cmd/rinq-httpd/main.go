@@ -1,116 +1,230 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/alecthomas/units"
-	"github.com/gorilla/websocket"
-	"github.com/rinq/httpd/src/internal/statuspage"
+	"github.com/rinq/httpd/src/httpd"
+	"github.com/rinq/httpd/src/internal/admin"
+	"github.com/rinq/httpd/src/internal/certstore"
+	"github.com/rinq/httpd/src/internal/config"
 	"github.com/rinq/httpd/src/websock"
 	"github.com/rinq/httpd/src/websock/native"
-	"github.com/rinq/httpd/src/websock/native/message"
 	"github.com/rinq/rinq-go/src/rinq"
 	"github.com/rinq/rinq-go/src/rinqamqp"
 )
 
+const usage = `usage: rinq-httpd [-config <file>] [serve | check-config | print-config]
+
+Commands:
+  serve         run the server (default)
+  check-config  validate the configuration and exit
+  print-config  print the effective configuration as YAML, with secrets redacted
+
+The configuration file may also be specified by RINQ_HTTPD_CONFIG. Environment
+variables override the values in the file.
+`
+
 func main() {
+	flags := flag.NewFlagSet("rinq-httpd", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	path := flags.String("config", os.Getenv("RINQ_HTTPD_CONFIG"), "")
+	_ = flags.Parse(os.Args[1:])
+
+	c, err := config.Load(*path, os.LookupEnv)
+
+	switch cmd := flags.Arg(0); cmd {
+	case "", "serve":
+		if err != nil {
+			log.Fatalf("invalid configuration:\n%s", err)
+		}
+
+		run(*path, &c)
+
+	case "check-config":
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("configuration is valid")
+
+	case "print-config":
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+			os.Exit(1)
+		}
+
+		buf, err := c.Marshal()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, _ = os.Stdout.Write(buf)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+// run runs the server until it is drained by a signal. path is the path of
+// the configuration file, which is read again when the configuration is
+// reloaded.
+func run(path string, c *config.Config) {
 	rand.Seed(time.Now().UnixNano())
 
 	logger := log.New(os.Stdout, "", log.LstdFlags)
-	var ws http.Handler
+	health := &admin.Health{}
+	breakers := circuitBreakers(c)
 
-	server := &http.Server{
-		Addr: os.Getenv("RINQ_HTTPD_BIND"),
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if websocket.IsWebSocketUpgrade(r) {
-				ws.ServeHTTP(w, r)
-			} else {
-				statuspage.Write(w, r, http.StatusUpgradeRequired)
-			}
-		}),
+	if breakers != nil {
+		health.SetBreakers(breakers.States)
 	}
 
-	for {
-		peer := connect()
-		ws = websocketHandler(peer, logger)
+	r := &reloader{
+		path:     path,
+		logger:   logger,
+		policies: native.NewCallPolicies(),
+		config:   c,
+	}
 
-		done := make(chan error, 1)
-		go serve(server, done)
+	r.policies.Replace(c.CallPolicies())
+	r.shared = sharedOptions(c, auditor(c), breakers, r.policies)
 
-		select {
-		case <-peer.Done():
-			if err := peer.Err(); err != nil {
-				// TODO: log
-				fmt.Println(err)
-			}
-			if err := server.Close(); err != nil {
-				// TODO: log
-				fmt.Println(err)
-			}
+	if access := accessLog(c); access != nil {
+		r.global = append(r.global, access)
+	}
 
-		case err := <-done:
+	serverTLS, store := tlsConfig(c, logger)
+
+	options := []httpd.Option{
+		httpd.Dialer(func(context.Context) (rinq.Peer, error) {
+			// TODO: this env var will be handled by rinq-go
+			// https://github.com/rinq/rinq-go/issues/94
+			return rinqamqp.DialEnv()
+		}),
+		httpd.Listener(listen(c)),
+		httpd.Encodings(c.EncodingList()...),
+		httpd.Logger(logger),
+		httpd.OnConnect(func(rinq.Peer) {
+			health.SetConnected(true)
+		}),
+		httpd.OnDisconnect(func(err error) {
+			health.SetConnected(false)
 			if err != nil {
-				// TODO: log
-				fmt.Println(err)
+				logger.Printf("lost connection to Rinq: %s", err)
 			}
-			time.Sleep(3 * time.Second)
-		}
+		}),
 	}
-}
 
-func connect() rinq.Peer {
-	for {
-		// TODO: this env var will be handled by rinq-go
-		// https://github.com/rinq/rinq-go/issues/94
-		peer, err := rinqamqp.DialEnv()
-		if err == nil {
-			return peer
-		}
+	if serverTLS != nil {
+		options = append(options, httpd.TLS(serverTLS))
+	}
 
-		fmt.Println(err) // TODO: log
-		time.Sleep(3 * time.Second)
+	if len(c.FanOutNamespaces) != 0 {
+		options = append(options, httpd.SharedFanOut(c.FanOutNamespaces...))
 	}
-}
 
-func serve(server *http.Server, c chan<- error) {
-	if err := server.ListenAndServe(); err != nil {
-		c <- err
+	options = append(options, handlerOptions(c, r.shared, r.global)...)
+	r.server = httpd.NewServer(options...)
+
+	if c.Admin.Bind != "" {
+		r.admin = admin.NewHandler(
+			health,
+			websock.NewAdminHandler(r.server.Connections()),
+			r.reload,
+			adminCredentials(c),
+		)
+		go serveAdmin(c.Admin.Bind, r.admin, logger)
 	}
 
-	close(c)
-}
+	go reloadOnHangup(r, store, logger)
+	go drainOnSignal(r, health, logger)
 
-func websocketHandler(peer rinq.Peer, logger *log.Logger) http.Handler {
-	return websock.NewHTTPHandler(
-		os.Getenv("RINQ_HTTPD_ORIGIN"),
-		pingInterval(),
-		maxMsgSize(),
-		logger,
-		&native.Handler{Peer: peer, Encoding: message.CBOREncoding, Logger: logger},
-		&native.Handler{Peer: peer, Encoding: message.JSONEncoding, Logger: logger},
-	)
+	if err := r.server.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func pingInterval() time.Duration {
-	i, err := strconv.ParseUint(os.Getenv("RINQ_HTTPD_PING"), 10, 64)
-	if err != nil {
-		return 10 * time.Second
+// serveAdmin serves the admin endpoints on addr. It runs independently of the
+// public server, and continues to run while the public server is draining.
+func serveAdmin(addr string, handler http.Handler, logger *log.Logger) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
 	}
 
-	return time.Duration(i) * time.Second
+	logger.Fatalf("admin server stopped: %s", server.ListenAndServe())
 }
 
-func maxMsgSize() units.MetricBytes {
-	i, err := strconv.ParseUint(os.Getenv("RINQ_HTTPD_MAX_MSG_SIZE"), 10, 64)
-	if err != nil {
-		return units.Megabyte
+// adminCredentials returns the credentials required to access the admin
+// endpoints, either a username and password for basic authentication, or a
+// bearer token.
+func adminCredentials(c *config.Config) admin.Credentials {
+	return admin.Credentials{
+		Username: c.Admin.Username,
+		Password: c.Admin.Password,
+		Token:    c.Admin.Token,
 	}
+}
+
+// drainOnSignal shuts down the public server when the process receives SIGTERM
+// or SIGINT, which causes run() to return once connections have been drained.
+//
+// The server stops accepting connections immediately, and the health endpoint
+// reports that it is draining. Existing connections are given up to the drain
+// timeout to close, after which any that remain are closed by the server.
+func drainOnSignal(r *reloader, health *admin.Health, logger *log.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+
+	timeout := time.Duration(r.current().Timeouts.Drain)
+
+	logger.Printf(
+		"draining %d connection(s) for up to %s",
+		r.server.Connections().Len(),
+		timeout,
+	)
+	health.Drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := r.server.Shutdown(ctx); err != nil {
+		logger.Printf("unable to drain all connections: %s", err)
+	} else {
+		logger.Println("drained all connections")
+	}
+}
 
-	return units.MetricBytes(i)
+// reloadOnHangup reloads the configuration, and the TLS certificate if store
+// is non-nil, whenever the process receives a SIGHUP signal.
+func reloadOnHangup(r *reloader, store *certstore.Store, logger *log.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		// errors are logged by reload()
+		_, _, _ = r.reload()
+
+		if store == nil {
+			continue
+		}
+
+		if err := store.Reload(); err != nil {
+			logger.Printf("unable to reload TLS certificate: %s", err)
+		} else {
+			logger.Println("reloaded TLS certificate")
+		}
+	}
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rinq/httpd/src/httpd"
+	"github.com/rinq/httpd/src/internal/breaker"
+	"github.com/rinq/httpd/src/internal/certstore"
+	"github.com/rinq/httpd/src/internal/clientaddr"
+	"github.com/rinq/httpd/src/internal/config"
+	"github.com/rinq/httpd/src/internal/proxyproto"
+	"github.com/rinq/httpd/src/internal/rotate"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native"
+)
+
+// listen returns the listener for the public server.
+func listen(c *config.Config) net.Listener {
+	addr := c.Bind
+	if addr == "" {
+		addr = ":http"
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("unable to listen: %s", err)
+	}
+
+	if c.ProxyProtocol.Enabled {
+		listener = proxyproto.NewListener(
+			listener,
+			time.Duration(c.ProxyProtocol.Timeout),
+			c.ProxyProtocolTrustedNetworks()...,
+		)
+	}
+
+	return listener
+}
+
+// tlsConfig returns the TLS configuration for the server, and the store that
+// holds its certificate, or nil if TLS is not enabled.
+func tlsConfig(c *config.Config, logger *log.Logger) (*tls.Config, *certstore.Store) {
+	if c.TLS.Cert == "" {
+		return nil, nil
+	}
+
+	store, err := certstore.Load(c.TLS.Cert, c.TLS.Key)
+	if err != nil {
+		log.Fatalf("unable to load TLS certificate: %s", err)
+	}
+
+	go store.Watch(nil, 30*time.Second, func(err error) {
+		logger.Printf("unable to reload TLS certificate: %s", err)
+	})
+
+	config := &tls.Config{
+		GetCertificate: store.GetCertificate,
+	}
+
+	if c.TLS.ClientCA != "" {
+		buf, err := ioutil.ReadFile(c.TLS.ClientCA)
+		if err != nil {
+			log.Fatalf("unable to load TLS client CA bundle: %s", err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(buf) {
+			log.Fatalf("unable to load TLS client CA bundle: %s contains no certificates", c.TLS.ClientCA)
+		}
+
+		config.ClientAuth = c.TLSClientAuth()
+	}
+
+	return config, store
+}
+
+// handlerOptions returns the server options that can be changed by reloading
+// the configuration.
+func handlerOptions(
+	c *config.Config,
+	shared []native.Option,
+	global []websock.Option,
+) []httpd.Option {
+	options := []websock.Option{
+		websock.TrustedProxies(c.TrustedProxyNetworks()...),
+		websock.MaxConnections(c.Limits.MaxConnections),
+		websock.MaxConnectionsPerIP(c.Limits.MaxConnectionsPerIP),
+		websock.WriteTimeout(time.Duration(c.Timeouts.Write)),
+		websock.OutboundQueueSize(c.Limits.OutboundQueueSize),
+		websock.SlowClientTimeout(time.Duration(c.Timeouts.SlowClient)),
+	}
+
+	if c.ForwardingHeader() == clientaddr.Forwarded {
+		options = append(options, websock.ForwardedHeader())
+	}
+
+	if c.DenyNullOrigin {
+		options = append(options, websock.DenyNullOrigin())
+	}
+
+	options = append(options, global...)
+
+	return []httpd.Option{
+		httpd.Origins(c.OriginPatterns()...),
+		httpd.PingInterval(time.Duration(c.PingInterval)),
+		httpd.MaxMessageSize(c.MaxMessageSize),
+		httpd.WebSocketOptions(options...),
+		httpd.NativeOptions(nativeOptions(c, shared)...),
+	}
+}
+
+// sharedOptions returns the options that share state between the native
+// handlers. The state is retained when reconnecting to Rinq, and when the
+// configuration is reloaded.
+func sharedOptions(
+	c *config.Config,
+	audit *native.Auditor,
+	breakers *native.CircuitBreakers,
+	policies *native.CallPolicies,
+) []native.Option {
+	shared := []native.Option{
+		native.Idempotency(
+			native.NewIdempotencyStore(
+				c.Idempotency.StoreSize,
+				time.Duration(c.Idempotency.TTL),
+			),
+		),
+		native.Policies(policies),
+	}
+
+	if audit != nil {
+		shared = append(shared, native.Audit(audit))
+	}
+
+	if breakers != nil {
+		shared = append(shared, native.Breakers(breakers))
+	}
+
+	if rules := c.CacheRules(); len(rules) != 0 {
+		shared = append(shared, native.Cache(native.NewResponseCache(c.Cache.Size, rules...)))
+	}
+
+	return shared
+}
+
+// nativeOptions returns the options for the native handlers, followed by the
+// shared options.
+func nativeOptions(c *config.Config, shared []native.Option) []native.Option {
+	options := []native.Option{
+		native.MaxSessions(c.Limits.MaxSessions),
+		native.OnRateLimit(c.RateLimitPolicy()),
+		native.MaxInFlightCalls(c.Limits.MaxInFlightCalls),
+		native.MaxInFlightCallsPerSession(c.Limits.MaxInFlightCallsPerSession),
+		native.OnOverload(c.OverloadPolicy()),
+		native.MaxBatchWindow(
+			time.Duration(c.Batching.MaxDelay),
+			c.Batching.MaxCount,
+			c.Batching.MaxBytes,
+		),
+		native.ResumeGracePeriod(time.Duration(c.Resume.GracePeriod)),
+		native.ResumeBufferSize(c.Resume.BufferSize),
+	}
+
+	if c.Resume.BindClientIP {
+		options = append(options, native.ResumeBindClientIP())
+	}
+
+	if c.Limits.FrameRate > 0 {
+		options = append(options, native.FrameRateLimit(c.Limits.FrameRate, c.Limits.FrameBurst))
+	}
+
+	if c.Limits.CallRate > 0 {
+		options = append(options, native.CallRateLimit(c.Limits.CallRate, c.Limits.CallBurst))
+	}
+
+	for _, r := range c.NamespaceRates() {
+		options = append(options, native.NamespaceCallRateLimit(r.Namespace, r.Rate, r.Burst))
+	}
+
+	return append(options, shared...)
+}
+
+// circuitBreakers returns the circuit breakers for the configured namespaces,
+// or nil if they are disabled. The breakers are shared by all handlers, and
+// retain their state when reconnecting to Rinq.
+func circuitBreakers(c *config.Config) *native.CircuitBreakers {
+	if c.Breakers.FailureRatio <= 0 {
+		return nil
+	}
+
+	return native.NewCircuitBreakers(breaker.Config{
+		FailureRatio: c.Breakers.FailureRatio,
+		Window:       time.Duration(c.Breakers.Window),
+		MinRequests:  c.Breakers.MinRequests,
+		OpenTimeout:  time.Duration(c.Breakers.OpenTimeout),
+		Probes:       c.Breakers.Probes,
+	}, c.Breakers.Namespaces...)
+}
+
+// auditor returns the configured auditor, or nil if auditing is disabled.
+func auditor(c *config.Config) *native.Auditor {
+	if c.Audit.Log == "" {
+		return nil
+	}
+
+	return native.NewAuditor(
+		logFile(c.Audit.Log, c.Audit.MaxSize, c.Audit.MaxBackups),
+		native.AuditConfig{
+			Namespaces:      c.Audit.Namespaces,
+			CapturePayloads: c.Audit.Payloads,
+			Redact:          c.Audit.Redact,
+		},
+	)
+}
+
+// accessLog returns the configured access log option, or nil if the access
+// log is disabled.
+func accessLog(c *config.Config) websock.Option {
+	if c.AccessLog.Log == "" {
+		return nil
+	}
+
+	return websock.AccessLog(
+		logFile(c.AccessLog.Log, c.AccessLog.MaxSize, c.AccessLog.MaxBackups),
+		c.AccessLogFormat(),
+	)
+}
+
+// logFile opens the log file at path, or returns stdout if path is "-". The
+// file is rotated when it reaches maxSize bytes, keeping maxBackups old files.
+func logFile(path string, maxSize int64, maxBackups int) io.Writer {
+	if path == "-" {
+		return os.Stdout
+	}
+
+	f, err := rotate.Open(path, maxSize, maxBackups)
+	if err != nil {
+		log.Fatalf("unable to open log file: %s", err)
+	}
+
+	return f
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rinq/httpd/src/httpd"
+	"github.com/rinq/httpd/src/internal/admin"
+	"github.com/rinq/httpd/src/internal/config"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native"
+)
+
+// reloader reloads the configuration and applies it to the server.
+type reloader struct {
+	path     string
+	logger   *log.Logger
+	server   *httpd.Server
+	admin    *admin.Handler
+	policies *native.CallPolicies
+	shared   []native.Option
+	global   []websock.Option
+
+	mutex  sync.Mutex
+	config *config.Config
+}
+
+// current returns the configuration that is in effect.
+func (r *reloader) current() *config.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.config
+}
+
+// reload reads the configuration file and environment again, and applies the
+// settings that can be changed without a restart.
+//
+// New connections use the new settings. Existing connections retain the
+// settings they were established with, other than the call policies, which
+// apply to all subsequent calls. The admin credentials are replaced
+// immediately.
+func (r *reloader) reload() (applied, restart []string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	next, err := config.Load(r.path, os.LookupEnv)
+	if err != nil {
+		r.logger.Printf("unable to reload configuration:\n%s", err)
+		return nil, nil, err
+	}
+
+	next, changes, err := config.Reload(r.config, next)
+	if err != nil {
+		r.logger.Printf("unable to reload configuration:\n%s", err)
+		return nil, nil, err
+	}
+
+	for _, ch := range changes {
+		if ch.Restart {
+			restart = append(restart, ch.Path)
+		} else {
+			applied = append(applied, ch.Path)
+		}
+	}
+
+	c := &next
+	r.config = c
+	r.policies.Replace(c.CallPolicies())
+	r.server.Reconfigure(handlerOptions(c, r.shared, r.global)...)
+
+	if r.admin != nil {
+		r.admin.SetCredentials(adminCredentials(c))
+	}
+
+	r.logger.Printf(
+		"reloaded configuration, applied: [%s], restart required: [%s]",
+		strings.Join(applied, ", "),
+		strings.Join(restart, ", "),
+	)
+
+	return applied, restart, nil
+}
@@ -0,0 +1,248 @@
+package httpd
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"time"
+
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// Option modifies the behavior of a Server. Options are applied to the server
+// by passing them to NewServer().
+//
+// Options that configure the handlers, namely Origins, PingInterval,
+// MaxMessageSize, WebSocketOptions and NativeOptions, may also be changed
+// while the server is running by passing them to Server.Reconfigure().
+type Option interface {
+	modify(*settings)
+}
+
+// DialFunc returns a new Rinq peer.
+type DialFunc func(ctx context.Context) (rinq.Peer, error)
+
+// settings holds the values configured by options.
+type settings struct {
+	peer         rinq.Peer
+	dial         DialFunc
+	listeners    []net.Listener
+	addrs        []string
+	tls          *tls.Config
+	encodings    []message.Encoding
+	fanOut       []string
+	logger       *log.Logger
+	onConnect    []func(rinq.Peer)
+	onDisconnect []func(error)
+
+	// the remaining fields may be changed by Server.Reconfigure()
+	handler handlerSettings
+}
+
+// handlerSettings holds the values that configure the handlers.
+type handlerSettings struct {
+	origins        []websock.OriginPattern
+	pingInterval   time.Duration
+	maxMessageSize int64
+	websock        []websock.Option
+	native         []native.Option
+}
+
+// Peer sets the Rinq peer used to serve clients. The server stops when the
+// peer stops. Use Dialer instead to reconnect to Rinq when the peer stops.
+func Peer(p rinq.Peer) Option {
+	return &peer{p}
+}
+
+type peer struct {
+	peer rinq.Peer
+}
+
+func (o *peer) modify(s *settings) {
+	s.peer = o.peer
+	s.dial = nil
+}
+
+// Dialer sets the function used to connect to Rinq. If the peer stops, the
+// existing connections are closed, and the server dials again.
+func Dialer(fn DialFunc) Option {
+	return &dialer{fn}
+}
+
+type dialer struct {
+	dial DialFunc
+}
+
+func (o *dialer) modify(s *settings) {
+	s.dial = o.dial
+	s.peer = nil
+}
+
+// Listener adds a listener on which the server accepts HTTP connections. The
+// server takes ownership of the listener and closes it when it stops.
+func Listener(l net.Listener) Option {
+	return &listener{l}
+}
+
+type listener struct {
+	listener net.Listener
+}
+
+func (o *listener) modify(s *settings) {
+	s.listeners = append(s.listeners, o.listener)
+}
+
+// Bind adds a TCP address on which the server accepts HTTP connections.
+func Bind(addr string) Option {
+	return bind(addr)
+}
+
+type bind string
+
+func (o bind) modify(s *settings) {
+	s.addrs = append(s.addrs, string(o))
+}
+
+// TLS enables TLS on all of the server's listeners. The configuration must
+// provide a certificate, either directly or via GetCertificate.
+func TLS(c *tls.Config) Option {
+	return &tlsConfig{c}
+}
+
+type tlsConfig struct {
+	config *tls.Config
+}
+
+func (o *tlsConfig) modify(s *settings) {
+	s.tls = o.config
+}
+
+// Encodings sets the message encodings supported by the native protocol, in
+// order of preference. The default is CBOR, then JSON.
+func Encodings(e ...message.Encoding) Option {
+	return encodings(e)
+}
+
+type encodings []message.Encoding
+
+func (o encodings) modify(s *settings) {
+	s.encodings = o
+}
+
+// SharedFanOut enables the shared fan-out of multicast notifications in the
+// given namespaces, which are dispatched to sessions by a single relay per
+// peer, rather than by each session's own listener.
+func SharedFanOut(namespaces ...string) Option {
+	return sharedFanOut(namespaces)
+}
+
+type sharedFanOut []string
+
+func (o sharedFanOut) modify(s *settings) {
+	s.fanOut = o
+}
+
+// Logger sets the logger used by the server and its handlers.
+func Logger(l *log.Logger) Option {
+	return &logger{l}
+}
+
+type logger struct {
+	logger *log.Logger
+}
+
+func (o *logger) modify(s *settings) {
+	s.logger = o.logger
+}
+
+// OnConnect adds a function that is called each time the server connects to
+// Rinq, before it begins serving clients using the peer.
+func OnConnect(fn func(rinq.Peer)) Option {
+	return onConnect(fn)
+}
+
+type onConnect func(rinq.Peer)
+
+func (o onConnect) modify(s *settings) {
+	s.onConnect = append(s.onConnect, o)
+}
+
+// OnDisconnect adds a function that is called each time the server's peer
+// stops, with the error that caused it to stop, if any.
+func OnDisconnect(fn func(error)) Option {
+	return onDisconnect(fn)
+}
+
+type onDisconnect func(error)
+
+func (o onDisconnect) modify(s *settings) {
+	s.onDisconnect = append(s.onDisconnect, o)
+}
+
+// Origins sets the patterns that the Origin header of an upgrade request must
+// match. If no patterns are given, the origin must match the Host header of the
+// request.
+func Origins(p ...websock.OriginPattern) Option {
+	return origins(p)
+}
+
+type origins []websock.OriginPattern
+
+func (o origins) modify(s *settings) {
+	s.handler.origins = o
+}
+
+// PingInterval sets the interval at which clients are pinged. The default is
+// DefaultPingInterval.
+func PingInterval(d time.Duration) Option {
+	return pingInterval(d)
+}
+
+type pingInterval time.Duration
+
+func (o pingInterval) modify(s *settings) {
+	s.handler.pingInterval = time.Duration(o)
+}
+
+// MaxMessageSize sets the maximum size of an incoming message, in bytes. The
+// default is DefaultMaxMessageSize.
+func MaxMessageSize(n int64) Option {
+	return maxMessageSize(n)
+}
+
+type maxMessageSize int64
+
+func (o maxMessageSize) modify(s *settings) {
+	s.handler.maxMessageSize = int64(o)
+}
+
+// WebSocketOptions sets the options passed to the WebSocket HTTP handler, such
+// as websock.MaxConnections().
+//
+// The server always tracks connections in its own registry, as returned by
+// Server.Connections(), so the websock.Connections() option has no effect.
+func WebSocketOptions(options ...websock.Option) Option {
+	return websockOptions(options)
+}
+
+type websockOptions []websock.Option
+
+func (o websockOptions) modify(s *settings) {
+	s.handler.websock = o
+}
+
+// NativeOptions sets the options passed to the native protocol handlers, such
+// as native.MaxSessions().
+func NativeOptions(options ...native.Option) Option {
+	return nativeOptions(options)
+}
+
+type nativeOptions []native.Option
+
+func (o nativeOptions) modify(s *settings) {
+	s.handler.native = o
+}
@@ -0,0 +1,385 @@
+// Package httpd provides an embeddable server that allows WebSocket clients to
+// communicate with a Rinq network.
+//
+// The server accepts WebSocket connections that use Rinq's native protocol,
+// and forwards their requests to Rinq using a single peer. It is the library
+// equivalent of the rinq-httpd command:
+//
+//	s := httpd.NewServer(
+//		httpd.Dialer(func(context.Context) (rinq.Peer, error) {
+//			return rinqamqp.DialEnv()
+//		}),
+//		httpd.Bind(":8080"),
+//	)
+//
+//	go s.Run(ctx)
+package httpd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/gorilla/websocket"
+	"github.com/rinq/httpd/src/internal/statuspage"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+const (
+	// DefaultPingInterval is the default interval at which clients are pinged.
+	DefaultPingInterval = 10 * time.Second
+
+	// DefaultMaxMessageSize is the default maximum size of an incoming message,
+	// in bytes.
+	DefaultMaxMessageSize = int64(units.Megabyte)
+)
+
+// redialDelay is the delay between failed attempts to connect to Rinq.
+const redialDelay = 3 * time.Second
+
+// Server is a WebSocket server that forwards client requests to Rinq.
+type Server struct {
+	settings settings
+	registry *websock.Registry
+	http     *http.Server
+
+	mutex   sync.Mutex
+	shared  []native.Option
+	natives []*native.Handler
+	handler atomic.Value // of current
+
+	shutdown     chan struct{} // closed when Shutdown() is called
+	shutdownOnce sync.Once
+	drained      chan struct{} // closed when Shutdown() has finished draining
+	drainedOnce  sync.Once
+}
+
+// current is the value stored in Server.handler. It wraps the handler so that
+// a nil handler can be stored while the server is not connected to Rinq.
+type current struct {
+	http.Handler
+}
+
+// NewServer returns a new server. Either the Peer or Dialer option must be
+// provided, along with at least one Listener or Bind option.
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		settings: settings{
+			encodings: []message.Encoding{
+				message.CBOREncoding,
+				message.JSONEncoding,
+			},
+			logger: log.New(os.Stdout, "", log.LstdFlags),
+			handler: handlerSettings{
+				pingInterval:   DefaultPingInterval,
+				maxMessageSize: DefaultMaxMessageSize,
+			},
+		},
+		registry: websock.NewRegistry(),
+		shutdown: make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt.modify(&s.settings)
+	}
+
+	s.http = &http.Server{
+		Handler:   s,
+		TLSConfig: s.settings.tls,
+	}
+
+	s.handler.Store(current{})
+
+	return s
+}
+
+// Connections returns the registry of the server's live connections, which
+// may be served via the admin API using websock.NewAdminHandler().
+func (s *Server) Connections() *websock.Registry {
+	return s.registry
+}
+
+// Run connects to Rinq and serves clients until ctx is canceled, Shutdown() is
+// called, or a listener fails.
+//
+// If the server was configured with the Dialer option it reconnects whenever
+// the peer stops, otherwise it returns the peer's error.
+//
+// Run returns nil once Shutdown() has finished draining connections.
+func (s *Server) Run(ctx context.Context) error {
+	if s.settings.peer == nil && s.settings.dial == nil {
+		return errors.New("httpd: a peer or dialer is required")
+	}
+
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	served := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go s.serve(l, served)
+	}
+
+	for {
+		peer, err := s.connect(ctx)
+		if err != nil {
+			return s.stop(nil, err)
+		}
+
+		s.attach(peer)
+
+		select {
+		case <-peer.Done():
+			err := peer.Err()
+			s.detach(err)
+
+			if s.settings.dial == nil {
+				return s.stop(nil, err)
+			}
+
+		case err := <-served:
+			return s.stop(peer, err)
+
+		case <-ctx.Done():
+			return s.stop(peer, ctx.Err())
+
+		case <-s.shutdown:
+			return s.stop(peer, nil)
+		}
+	}
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting connections,
+// then waits for the existing connections to close. If ctx is canceled before
+// they have closed, the remaining connections are closed by the server and
+// Shutdown returns ctx's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+	defer s.drainedOnce.Do(func() { close(s.drained) })
+
+	// Shutdown() stops the listeners and closes idle connections, but does
+	// not track WebSocket connections, as they have been hijacked.
+	if err := s.http.Shutdown(ctx); err != nil {
+		s.registry.CloseAll("server is shutting down")
+		return err
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.registry.Len() != 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.settings.logger.Printf("closing %d remaining connection(s)", s.registry.Len())
+			s.registry.CloseAll("server is shutting down")
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Reconfigure replaces the options that configure the handlers. New
+// connections use the new options, while existing connections retain the
+// options they were established with.
+//
+// Only the Origins, PingInterval, MaxMessageSize, WebSocketOptions and
+// NativeOptions options can be changed. Any other options are ignored.
+func (s *Server) Reconfigure(options ...Option) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings := s.settings
+	for _, opt := range options {
+		opt.modify(&settings)
+	}
+
+	s.settings.handler = settings.handler
+
+	if s.natives != nil {
+		for _, h := range s.natives {
+			h.Reconfigure(s.nativeOptions()...)
+		}
+
+		s.handler.Store(current{s.build()})
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		statuspage.Write(w, r, http.StatusUpgradeRequired)
+		return
+	}
+
+	h := s.handler.Load().(current)
+	if h.Handler == nil {
+		statuspage.Write(w, r, http.StatusServiceUnavailable)
+		return
+	}
+
+	h.ServeHTTP(w, r)
+}
+
+// listen returns the listeners on which to serve clients.
+func (s *Server) listen() ([]net.Listener, error) {
+	listeners := s.settings.listeners
+
+	for _, addr := range s.settings.addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("httpd: at least one listener is required")
+	}
+
+	return listeners, nil
+}
+
+// serve serves clients on l, sending the result to done.
+func (s *Server) serve(l net.Listener, done chan<- error) {
+	if s.settings.tls != nil {
+		done <- s.http.ServeTLS(l, "", "")
+	} else {
+		done <- s.http.Serve(l)
+	}
+}
+
+// connect returns the peer to use to serve clients, dialing Rinq if
+// necessary.
+func (s *Server) connect(ctx context.Context) (rinq.Peer, error) {
+	if s.settings.peer != nil {
+		return s.settings.peer, nil
+	}
+
+	for {
+		peer, err := s.settings.dial(ctx)
+		if err == nil {
+			return peer, nil
+		}
+
+		s.settings.logger.Printf("unable to connect to Rinq: %s", err)
+
+		select {
+		case <-time.After(redialDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.shutdown:
+			return nil, http.ErrServerClosed
+		}
+	}
+}
+
+// attach builds new handlers that serve clients using peer.
+func (s *Server) attach(peer rinq.Peer) {
+	for _, fn := range s.settings.onConnect {
+		fn(peer)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.shared = nil
+	if len(s.settings.fanOut) != 0 {
+		s.shared = append(s.shared, native.SharedFanOut(native.NewFanOut(peer, s.settings.fanOut...)))
+	}
+
+	s.natives = nil
+	for _, e := range s.settings.encodings {
+		h := native.NewHandler(peer, e, s.nativeOptions()...)
+		h.Logger = s.settings.logger
+		s.natives = append(s.natives, h)
+	}
+
+	s.handler.Store(current{s.build()})
+}
+
+// detach stops serving clients after the peer has stopped. The existing
+// connections are closed, as their sessions belong to the stopped peer.
+func (s *Server) detach(err error) {
+	s.mutex.Lock()
+	s.shared = nil
+	s.natives = nil
+	s.handler.Store(current{})
+	s.mutex.Unlock()
+
+	s.registry.CloseAll("lost connection to Rinq")
+
+	for _, fn := range s.settings.onDisconnect {
+		fn(err)
+	}
+}
+
+// stop stops the server and returns err. If Shutdown() has been called it
+// waits for it to finish draining and returns nil. If peer is non-nil, it is
+// detached, and stopped if it was dialed by the server.
+func (s *Server) stop(peer rinq.Peer, err error) error {
+	select {
+	case <-s.shutdown:
+		<-s.drained
+		err = nil
+	default:
+		_ = s.http.Close()
+	}
+
+	if peer != nil {
+		s.detach(nil)
+
+		if s.settings.dial != nil {
+			peer.GracefulStop()
+		}
+	}
+
+	return err
+}
+
+// nativeOptions returns the options for the native handlers. s.mutex must be
+// held.
+func (s *Server) nativeOptions() []native.Option {
+	var options []native.Option
+	options = append(options, s.settings.handler.native...)
+	return append(options, s.shared...)
+}
+
+// build returns a new WebSocket handler that dispatches to the native
+// handlers. s.mutex must be held.
+func (s *Server) build() http.Handler {
+	handlers := make([]websock.Handler, len(s.natives))
+	for i, h := range s.natives {
+		handlers[i] = h
+	}
+
+	var options []websock.Option
+	options = append(options, s.settings.handler.websock...)
+	options = append(options, websock.Connections(s.registry))
+
+	return websock.NewHTTPHandlerWithOptions(
+		s.settings.handler.origins,
+		s.settings.handler.pingInterval,
+		units.MetricBytes(s.settings.handler.maxMessageSize),
+		s.settings.logger,
+		handlers,
+		options...,
+	)
+}
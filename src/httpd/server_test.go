@@ -0,0 +1,246 @@
+package httpd_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/httpd"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// peer is a fake rinq.Peer that only implements the methods used by the
+// server.
+type peer struct {
+	rinq.Peer
+
+	done    chan struct{}
+	err     error
+	stopped chan struct{}
+}
+
+func newPeer() *peer {
+	return &peer{
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}, 1),
+	}
+}
+
+func (p *peer) Done() <-chan struct{} { return p.done }
+func (p *peer) Err() error            { return p.err }
+func (p *peer) GracefulStop()         { p.stopped <- struct{}{} }
+
+func (p *peer) fail(err error) {
+	p.err = err
+	close(p.done)
+}
+
+var _ = Describe("Server", func() {
+	var (
+		listener net.Listener
+		logger   *log.Logger
+		subject  *Server
+		result   chan error
+		url      string
+		dialer   websocket.Dialer
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		logger = log.New(ioutil.Discard, "", 0)
+		url = "ws://" + listener.Addr().String()
+		dialer = websocket.Dialer{Subprotocols: []string{"rinq-1.0+json"}}
+		result = make(chan error, 1)
+	})
+
+	AfterEach(func() {
+		if subject != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_ = subject.Shutdown(ctx)
+		}
+	})
+
+	run := func(options ...Option) {
+		options = append(options, Listener(listener), Logger(logger))
+		s, r := NewServer(options...), result
+		subject = s
+
+		go func() {
+			r <- s.Run(context.Background())
+		}()
+	}
+
+	// dial connects to the server, retrying until it is connected to Rinq.
+	dial := func() *websocket.Conn {
+		var conn *websocket.Conn
+
+		Eventually(func() (err error) {
+			conn, _, err = dialer.Dial(url, nil)
+			return err
+		}).Should(Succeed())
+
+		return conn
+	}
+
+	It("returns an error if there is no peer or dialer", func() {
+		subject = NewServer(Listener(listener))
+
+		err := subject.Run(context.Background())
+
+		Expect(err).To(MatchError("httpd: a peer or dialer is required"))
+	})
+
+	It("returns an error if there are no listeners", func() {
+		subject = NewServer(Peer(newPeer()))
+
+		err := subject.Run(context.Background())
+
+		Expect(err).To(MatchError("httpd: at least one listener is required"))
+	})
+
+	It("accepts WebSocket connections", func() {
+		run(Peer(newPeer()))
+
+		conn := dial()
+		defer conn.Close()
+
+		Expect(conn.Subprotocol()).To(Equal("rinq-1.0+json"))
+		Eventually(subject.Connections().Len).Should(Equal(1))
+	})
+
+	It("rejects requests that are not WebSocket upgrades", func() {
+		run(Peer(newPeer()))
+
+		res, err := http.Get("http://" + listener.Addr().String())
+		Expect(err).ShouldNot(HaveOccurred())
+		res.Body.Close()
+
+		Expect(res.StatusCode).To(Equal(http.StatusUpgradeRequired))
+	})
+
+	It("rejects upgrades while it is not connected to Rinq", func() {
+		run(Dialer(func(context.Context) (rinq.Peer, error) {
+			return nil, errors.New("<error>")
+		}))
+
+		_, res, err := dialer.Dial(url, nil)
+		Expect(err).Should(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("calls the hooks and returns the peer's error when the peer stops", func() {
+		p := newPeer()
+		connected := make(chan rinq.Peer, 1)
+		disconnected := make(chan error, 1)
+
+		run(
+			Peer(p),
+			OnConnect(func(p rinq.Peer) { connected <- p }),
+			OnDisconnect(func(err error) { disconnected <- err }),
+		)
+
+		Eventually(connected).Should(Receive(Equal(p)))
+
+		p.fail(errors.New("<error>"))
+
+		Eventually(disconnected).Should(Receive(MatchError("<error>")))
+		Eventually(result).Should(Receive(MatchError("<error>")))
+	})
+
+	It("closes connections and dials again when a dialed peer stops", func() {
+		peers := make(chan rinq.Peer, 2)
+		first, second := newPeer(), newPeer()
+		peers <- first
+		peers <- second
+
+		run(Dialer(func(context.Context) (rinq.Peer, error) {
+			return <-peers, nil
+		}))
+
+		conn := dial()
+		defer conn.Close()
+
+		first.fail(nil)
+
+		_, _, err := conn.ReadMessage()
+		Expect(websocket.IsCloseError(err, websock.CloseGoingAway)).To(BeTrue())
+
+		Eventually(peers).Should(BeEmpty())
+
+		dial().Close()
+	})
+
+	Describe("Shutdown", func() {
+		It("causes Run to return nil", func() {
+			run(Peer(newPeer()))
+
+			err := subject.Shutdown(context.Background())
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(result).Should(Receive(BeNil()))
+		})
+
+		It("stops a dialed peer", func() {
+			p := newPeer()
+			run(Dialer(func(context.Context) (rinq.Peer, error) {
+				return p, nil
+			}))
+
+			dial().Close()
+
+			err := subject.Shutdown(context.Background())
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(p.stopped).Should(Receive())
+		})
+
+		It("closes the remaining connections when the context is canceled", func() {
+			run(Peer(newPeer()))
+
+			conn := dial()
+			defer conn.Close()
+
+			Eventually(subject.Connections().Len).Should(Equal(1))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := subject.Shutdown(ctx)
+			Expect(err).To(Equal(context.DeadlineExceeded))
+
+			_, _, err = conn.ReadMessage()
+			Expect(websocket.IsCloseError(err, websock.CloseGoingAway)).To(BeTrue())
+
+			Eventually(result).Should(Receive(BeNil()))
+		})
+	})
+
+	Describe("Reconfigure", func() {
+		It("applies the new options to new connections", func() {
+			run(Peer(newPeer()))
+
+			dial().Close()
+
+			origins, err := websock.ParseOriginPatterns("example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			subject.Reconfigure(Origins(origins...))
+
+			_, res, err := dialer.Dial(url, nil)
+			Expect(err).Should(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+		})
+	})
+})
@@ -0,0 +1,67 @@
+// Package admin provides the endpoints served on the private admin listener,
+// which must not be reachable from the public internet.
+package admin
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Handler is an http.Handler that serves the admin endpoints.
+type Handler struct {
+	mux       *http.ServeMux
+	protected *protected
+}
+
+// NewHandler returns an HTTP handler that serves the admin endpoints:
+//
+//	/health         the server's health, as reported by health
+//	/debug/vars     metrics published via expvar
+//	/debug/pprof/   Go runtime profiles
+//	/connections    the connections admin API, served by conns
+//	/config/reload  reloads the configuration using reload, if non-nil
+//
+// All endpoints other than /health require the given credentials, if any.
+// The health endpoint is unprotected so that it can be used by load
+// balancers.
+func NewHandler(
+	health *Health,
+	conns http.Handler,
+	reload ReloadFunc,
+	c Credentials,
+) *Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/connections", conns)
+	mux.Handle("/connections/", conns)
+
+	if reload != nil {
+		mux.Handle("/config/reload", reload)
+	}
+
+	h := &Handler{
+		mux:       http.NewServeMux(),
+		protected: newProtected(mux, c),
+	}
+
+	h.mux.Handle("/health", health)
+	h.mux.Handle("/", h.protected)
+
+	return h
+}
+
+// SetCredentials replaces the credentials required to access the protected
+// endpoints.
+func (h *Handler) SetCredentials(c Credentials) {
+	h.protected.credentials.Store(c)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,34 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/admin"
+)
+
+var _ = Describe("Handler", func() {
+	It("applies credentials set after it is created", func() {
+		subject := NewHandler(&Health{}, http.NotFoundHandler(), nil, Credentials{})
+
+		w := httptest.NewRecorder()
+		subject.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		subject.SetCredentials(Credentials{Token: "secret"})
+
+		w = httptest.NewRecorder()
+		subject.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("does not require credentials for the health endpoint", func() {
+		subject := NewHandler(&Health{}, http.NotFoundHandler(), nil, Credentials{Token: "secret"})
+
+		w := httptest.NewRecorder()
+		subject.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+})
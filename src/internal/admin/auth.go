@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rinq/httpd/src/internal/statuspage"
+)
+
+// Credentials are the credentials required to access the admin endpoints.
+//
+// If Username or Password is non-empty, clients may authenticate using HTTP
+// basic authentication. If Token is non-empty, clients may authenticate by
+// presenting it as a bearer token.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// IsZero returns true if no credentials are configured.
+func (c Credentials) IsZero() bool {
+	return c == Credentials{}
+}
+
+// Protect returns a handler that only forwards requests to h if they present
+// valid credentials. It returns h unchanged if c is zero.
+func Protect(h http.Handler, c Credentials) http.Handler {
+	if c.IsZero() {
+		return h
+	}
+
+	return newProtected(h, c)
+}
+
+// protected is an http.Handler that requires credentials. The credentials may
+// be replaced while the handler is in use.
+type protected struct {
+	next        http.Handler
+	credentials atomic.Value // of Credentials
+}
+
+func newProtected(h http.Handler, c Credentials) *protected {
+	p := &protected{next: h}
+	p.credentials.Store(c)
+	return p
+}
+
+func (p *protected) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := p.credentials.Load().(Credentials)
+
+	if c.IsZero() || authenticate(r, c) {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	if c.Token != "" {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="rinq-httpd"`)
+	}
+
+	if c.Username != "" || c.Password != "" {
+		w.Header().Add("WWW-Authenticate", `Basic realm="rinq-httpd"`)
+	}
+
+	statuspage.Write(w, r, http.StatusUnauthorized)
+}
+
+// authenticate returns true if r presents credentials that match c.
+func authenticate(r *http.Request, c Credentials) bool {
+	if c.Token != "" {
+		h := r.Header.Get("Authorization")
+		if strings.HasPrefix(h, "Bearer ") && equal(strings.TrimPrefix(h, "Bearer "), c.Token) {
+			return true
+		}
+	}
+
+	if c.Username != "" || c.Password != "" {
+		username, password, ok := r.BasicAuth()
+		if ok && equal(username, c.Username) && equal(password, c.Password) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// equal compares a and b in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
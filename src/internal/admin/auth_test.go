@@ -0,0 +1,64 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/admin"
+)
+
+var _ = Describe("Protect", func() {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	serve := func(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	It("does not require credentials if none are configured", func() {
+		subject := Protect(next, Credentials{})
+
+		w := serve(subject, httptest.NewRequest("GET", "/", nil))
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("accepts valid basic authentication", func() {
+		subject := Protect(next, Credentials{Username: "user", Password: "pass"})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth("user", "pass")
+		w := serve(subject, r)
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("accepts a valid bearer token", func() {
+		subject := Protect(next, Credentials{Token: "secret"})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := serve(subject, r)
+
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("rejects invalid credentials", func() {
+		subject := Protect(next, Credentials{Username: "user", Password: "pass", Token: "secret"})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth("user", "wrong")
+		w := serve(subject, r)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.HeaderMap["Www-Authenticate"]).To(ConsistOf(
+			`Bearer realm="rinq-httpd"`,
+			`Basic realm="rinq-httpd"`,
+		))
+	})
+})
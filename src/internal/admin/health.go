@@ -0,0 +1,104 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rinq/httpd/src/internal/breaker"
+)
+
+const (
+	// StatusOK is the status of a server that is connected to Rinq and
+	// accepting connections.
+	StatusOK = "ok"
+
+	// StatusUnavailable is the status of a server that is not connected to
+	// Rinq.
+	StatusUnavailable = "unavailable"
+
+	// StatusDraining is the status of a server that is no longer accepting
+	// connections because it is shutting down.
+	StatusDraining = "draining"
+)
+
+// Health is an http.Handler that reports the health of the server as JSON.
+// It responds with "503 Service Unavailable" unless the status is StatusOK, so
+// that it may be used by load balancers.
+//
+// The zero-value is a server that is not connected to Rinq.
+type Health struct {
+	draining  int32 // accessed atomically
+	connected int32 // accessed atomically
+
+	mutex    sync.RWMutex
+	breakers func() map[string]breaker.State
+}
+
+// healthReport is the JSON body of the health endpoint.
+type healthReport struct {
+	Status   string            `json:"status"`
+	Breakers map[string]string `json:"breakers,omitempty"`
+}
+
+// SetConnected sets whether the server is connected to Rinq.
+func (h *Health) SetConnected(connected bool) {
+	var v int32
+	if connected {
+		v = 1
+	}
+
+	atomic.StoreInt32(&h.connected, v)
+}
+
+// SetBreakers sets the function used to obtain the state of the circuit
+// breakers for each namespace. Open breakers are reported, but do not affect
+// the status.
+func (h *Health) SetBreakers(fn func() map[string]breaker.State) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.breakers = fn
+}
+
+// Drain marks the server as draining. It can not be undone.
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Status returns the current status.
+func (h *Health) Status() string {
+	if atomic.LoadInt32(&h.draining) != 0 {
+		return StatusDraining
+	}
+
+	if atomic.LoadInt32(&h.connected) == 0 {
+		return StatusUnavailable
+	}
+
+	return StatusOK
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{Status: h.Status()}
+
+	h.mutex.RLock()
+	fn := h.breakers
+	h.mutex.RUnlock()
+
+	if fn != nil {
+		report.Breakers = map[string]string{}
+		for ns, s := range fn() {
+			report.Breakers[ns] = s.String()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,63 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/admin"
+	"github.com/rinq/httpd/src/internal/breaker"
+)
+
+var _ = Describe("Health", func() {
+	var subject *Health
+
+	BeforeEach(func() {
+		subject = &Health{}
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		subject.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		return w
+	}
+
+	It("is unavailable until connected", func() {
+		w := serve()
+
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(w.Body.String()).To(MatchJSON(`{"status": "unavailable"}`))
+	})
+
+	It("is ok when connected", func() {
+		subject.SetConnected(true)
+
+		w := serve()
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(MatchJSON(`{"status": "ok"}`))
+	})
+
+	It("is draining once drained, even if connected", func() {
+		subject.SetConnected(true)
+		subject.Drain()
+
+		w := serve()
+
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(w.Body.String()).To(MatchJSON(`{"status": "draining"}`))
+	})
+
+	It("reports the state of the circuit breakers", func() {
+		subject.SetConnected(true)
+		subject.SetBreakers(func() map[string]breaker.State {
+			return map[string]breaker.State{"ns": breaker.Open}
+		})
+
+		w := serve()
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(MatchJSON(`{"status": "ok", "breakers": {"ns": "open"}}`))
+	})
+})
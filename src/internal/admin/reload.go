@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rinq/httpd/src/internal/statuspage"
+)
+
+// ReloadFunc is an http.Handler that reloads the server's configuration when
+// it receives a POST request.
+//
+// The function returns the settings that were changed and applied, and those
+// that were changed but require a restart to take effect. If the configuration
+// is invalid it returns an error, and the existing configuration remains in
+// effect.
+type ReloadFunc func() (applied, restart []string, err error)
+
+// ReloadResult is the response to a successful reload request.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+func (fn ReloadFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		statuspage.Write(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	applied, restart, err := fn()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_ = json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+
+	result := ReloadResult{
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+	result.Applied = append(result.Applied, applied...)
+	result.RestartRequired = append(result.RestartRequired, restart...)
+
+	_ = json.NewEncoder(w).Encode(result)
+}
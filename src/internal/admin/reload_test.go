@@ -0,0 +1,56 @@
+package admin_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/admin"
+)
+
+var _ = Describe("ReloadFunc", func() {
+	serve := func(fn ReloadFunc, method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		fn.ServeHTTP(w, httptest.NewRequest(method, "/config/reload", nil))
+		return w
+	}
+
+	It("reports the applied settings and those that require a restart", func() {
+		w := serve(func() ([]string, []string, error) {
+			return []string{"origins"}, []string{"bind"}, nil
+		}, "POST")
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(MatchJSON(`{"applied": ["origins"], "restart_required": ["bind"]}`))
+	})
+
+	It("reports empty lists when nothing has changed", func() {
+		w := serve(func() ([]string, []string, error) {
+			return nil, nil, nil
+		}, "POST")
+
+		Expect(w.Body.String()).To(MatchJSON(`{"applied": [], "restart_required": []}`))
+	})
+
+	It("reports an invalid configuration", func() {
+		w := serve(func() ([]string, []string, error) {
+			return nil, nil, errors.New("ping_interval: must be positive")
+		}, "POST")
+
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(w.Body.String()).To(MatchJSON(`{"error": "ping_interval: must be positive"}`))
+	})
+
+	It("only accepts POST requests", func() {
+		called := false
+		w := serve(func() ([]string, []string, error) {
+			called = true
+			return nil, nil, nil
+		}, "GET")
+
+		Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(called).To(BeFalse())
+	})
+})
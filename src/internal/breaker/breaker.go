@@ -0,0 +1,228 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed is the state of a breaker that allows all requests.
+	Closed State = iota
+
+	// Open is the state of a breaker that rejects all requests.
+	Open
+
+	// HalfOpen is the state of a breaker that allows a limited number of
+	// requests, to determine whether it should close again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// Outcome is the outcome of a request that was allowed by a breaker.
+type Outcome int
+
+const (
+	// Success is the outcome of a request that succeeded.
+	Success Outcome = iota
+
+	// Failure is the outcome of a request that failed, which counts towards
+	// tripping the breaker.
+	Failure
+
+	// Ignored is the outcome of a request whose result says nothing about the
+	// health of the protected resource, such as one canceled by the client. It
+	// is not counted, and frees the probe it used while half-open.
+	Ignored
+)
+
+// Config is the configuration of a circuit breaker.
+type Config struct {
+	// Window is the period over which failures are counted while the breaker
+	// is closed.
+	Window time.Duration
+
+	// MinRequests is the minimum number of requests within a window before the
+	// breaker may trip.
+	MinRequests int
+
+	// FailureRatio is the proportion of failed requests within a window, from
+	// 0 to 1, at which the breaker trips.
+	FailureRatio float64
+
+	// OpenTimeout is the time that the breaker remains open before allowing
+	// probe requests.
+	OpenTimeout time.Duration
+
+	// Probes is the number of requests allowed while half-open. The breaker
+	// closes once all of them succeed.
+	Probes int
+
+	// OnStateChange, if non-nil, is called whenever the state of the breaker
+	// changes.
+	OnStateChange func(from, to State)
+}
+
+// Breaker is a circuit breaker.
+//
+// While closed, requests are allowed and their outcome recorded. If the
+// proportion of failures within a window reaches the configured ratio, the
+// breaker opens and requests are rejected. Once the open timeout elapses, the
+// breaker becomes half-open and allows a limited number of probe requests.
+// A single failed probe opens the breaker again.
+type Breaker struct {
+	config Config
+
+	mutex       sync.Mutex
+	state       State
+	generation  uint64
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+// New returns a closed breaker.
+func New(c Config) *Breaker {
+	if c.Probes < 1 {
+		c.Probes = 1
+	}
+
+	return &Breaker{
+		config:      c,
+		windowStart: time.Now(),
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.update(time.Now())
+
+	return b.state
+}
+
+// Allow returns true if a request may proceed. If so, done must be called
+// with the outcome of the request.
+func (b *Breaker) Allow() (done func(Outcome), ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.update(now)
+
+	switch b.state {
+	case Open:
+		return nil, false
+
+	case HalfOpen:
+		if b.probes >= b.config.Probes {
+			return nil, false
+		}
+
+		b.probes++
+	}
+
+	generation := b.generation
+
+	return func(o Outcome) {
+		b.record(generation, o)
+	}, true
+}
+
+// record records the outcome of a request that was allowed during the given
+// generation. Outcomes from earlier generations are ignored.
+func (b *Breaker) record(generation uint64, o Outcome) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	if o == Ignored {
+		if b.state == HalfOpen {
+			b.probes--
+		}
+		return
+	}
+
+	success := o == Success
+	now := time.Now()
+
+	switch b.state {
+	case Closed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+
+		b.requests++
+		if !success {
+			b.failures++
+		}
+
+		if b.requests >= b.config.MinRequests &&
+			float64(b.failures) >= b.config.FailureRatio*float64(b.requests) &&
+			b.failures > 0 {
+			b.transition(Open, now)
+		}
+
+	case HalfOpen:
+		if !success {
+			b.transition(Open, now)
+			return
+		}
+
+		b.successes++
+		if b.successes >= b.config.Probes {
+			b.transition(Closed, now)
+		}
+	}
+}
+
+// update moves an open breaker to the half-open state once the open timeout
+// has elapsed. b.mutex must be held.
+func (b *Breaker) update(now time.Time) {
+	if b.state == Open && now.Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.transition(HalfOpen, now)
+	}
+}
+
+// transition changes the state of the breaker. b.mutex must be held.
+func (b *Breaker) transition(to State, now time.Time) {
+	from := b.state
+
+	b.state = to
+	b.generation++
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+
+	if to == Open {
+		b.openedAt = now
+	}
+
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
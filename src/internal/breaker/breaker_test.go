@@ -0,0 +1,157 @@
+package breaker_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/breaker"
+)
+
+var _ = Describe("Breaker", func() {
+	var (
+		transitions []State
+		subject     *Breaker
+	)
+
+	request := func(o Outcome) bool {
+		done, ok := subject.Allow()
+		if ok {
+			done(o)
+		}
+		return ok
+	}
+
+	BeforeEach(func() {
+		transitions = nil
+		subject = New(Config{
+			Window:       time.Minute,
+			MinRequests:  4,
+			FailureRatio: 0.5,
+			OpenTimeout:  20 * time.Millisecond,
+			Probes:       2,
+			OnStateChange: func(_, to State) {
+				transitions = append(transitions, to)
+			},
+		})
+	})
+
+	It("is initially closed", func() {
+		Expect(subject.State()).To(Equal(Closed))
+	})
+
+	It("does not trip before the minimum number of requests", func() {
+		request(Failure)
+		request(Failure)
+		request(Failure)
+
+		Expect(subject.State()).To(Equal(Closed))
+	})
+
+	It("does not trip while the failure ratio is below the threshold", func() {
+		request(Success)
+		request(Success)
+		request(Success)
+		request(Failure)
+
+		Expect(subject.State()).To(Equal(Closed))
+	})
+
+	It("trips once the failure ratio reaches the threshold", func() {
+		request(Success)
+		request(Success)
+		request(Failure)
+		request(Failure)
+
+		Expect(subject.State()).To(Equal(Open))
+		Expect(request(Success)).To(BeFalse())
+	})
+
+	Context("when open", func() {
+		BeforeEach(func() {
+			for i := 0; i < 4; i++ {
+				request(Failure)
+			}
+		})
+
+		It("becomes half-open after the open timeout", func() {
+			Eventually(subject.State).Should(Equal(HalfOpen))
+		})
+
+		It("allows a limited number of probes while half-open", func() {
+			Eventually(subject.State).Should(Equal(HalfOpen))
+
+			_, ok := subject.Allow()
+			Expect(ok).To(BeTrue())
+			_, ok = subject.Allow()
+			Expect(ok).To(BeTrue())
+			_, ok = subject.Allow()
+			Expect(ok).To(BeFalse())
+		})
+
+		It("closes once all probes succeed", func() {
+			Eventually(subject.State).Should(Equal(HalfOpen))
+
+			request(Success)
+			request(Success)
+
+			Expect(subject.State()).To(Equal(Closed))
+			Expect(transitions).To(Equal([]State{Open, HalfOpen, Closed}))
+		})
+
+		It("opens again if a probe fails", func() {
+			Eventually(subject.State).Should(Equal(HalfOpen))
+
+			request(Success)
+			request(Failure)
+
+			Expect(subject.State()).To(Equal(Open))
+		})
+	})
+
+	It("does not count ignored requests", func() {
+		request(Ignored)
+		request(Ignored)
+		request(Failure)
+		request(Failure)
+		request(Success)
+		request(Success)
+
+		Expect(subject.State()).To(Equal(Open))
+
+		subject = New(Config{MinRequests: 2, FailureRatio: 0.5, Window: time.Minute})
+		request(Failure)
+		request(Ignored)
+
+		Expect(subject.State()).To(Equal(Closed))
+	})
+
+	It("frees the probe used by an ignored request while half-open", func() {
+		for i := 0; i < 4; i++ {
+			request(Failure)
+		}
+
+		Eventually(subject.State).Should(Equal(HalfOpen))
+
+		request(Ignored)
+		request(Ignored)
+		request(Success)
+		request(Success)
+
+		Expect(subject.State()).To(Equal(Closed))
+	})
+
+	It("ignores outcomes of requests allowed before a state change", func() {
+		done, _ := subject.Allow()
+
+		for i := 0; i < 4; i++ {
+			request(Failure)
+		}
+
+		Eventually(subject.State).Should(Equal(HalfOpen))
+		done(Success)
+		done(Success)
+
+		Expect(subject.State()).To(Equal(HalfOpen))
+	})
+})
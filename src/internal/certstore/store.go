@@ -0,0 +1,121 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store holds a TLS certificate that is loaded from disk, and can be reloaded
+// without interrupting existing connections.
+//
+// The reloaded certificate is used for all TLS handshakes that occur after the
+// reload.
+type Store struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// Load returns a store containing the certificate and private key in the
+// given PEM encoded files.
+func Load(certFile, keyFile string) (*Store, error) {
+	s := &Store{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetCertificate returns the current certificate. It has the signature
+// required by tls.Config.GetCertificate.
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.cert, nil
+}
+
+// Reload loads the certificate from disk. If the certificate can not be
+// loaded, the previous certificate remains in use.
+func (s *Store) Reload() error {
+	modTime, err := s.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cert = &cert
+	s.modTime = modTime
+
+	return nil
+}
+
+// Watch polls the certificate and key files at the given interval and reloads
+// the certificate when either of them is modified, until done is closed.
+//
+// Errors that occur while reloading are passed to fn.
+func (s *Store) Watch(done <-chan struct{}, interval time.Duration, fn func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		modTime, err := s.lastModified()
+		if err == nil {
+			s.mutex.RLock()
+			changed := !modTime.Equal(s.modTime)
+			s.mutex.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			err = s.Reload()
+		}
+
+		if err != nil {
+			fn(err)
+		}
+	}
+}
+
+// lastModified returns the most recent modification time of the certificate
+// and key files.
+func (s *Store) lastModified() (time.Time, error) {
+	var t time.Time
+
+	for _, f := range []string{s.certFile, s.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+
+	return t, nil
+}
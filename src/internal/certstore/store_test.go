@@ -0,0 +1,136 @@
+package certstore_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/certstore"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		dir      string
+		certFile string
+		keyFile  string
+	)
+
+	// writeCert writes a new self-signed certificate with the given common
+	// name to certFile and keyFile.
+	writeCert := func(cn string, modTime time.Time) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(os.Chtimes(certFile, modTime, modTime)).To(Succeed())
+		Expect(os.Chtimes(keyFile, modTime, modTime)).To(Succeed())
+	}
+
+	commonName := func(s *Store) string {
+		cert, err := s.GetCertificate(nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		c, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return c.Subject.CommonName
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "certstore")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		certFile = filepath.Join(dir, "cert.pem")
+		keyFile = filepath.Join(dir, "key.pem")
+
+		writeCert("first", time.Now().Add(-time.Minute))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Load", func() {
+		It("loads the certificate", func() {
+			s, err := Load(certFile, keyFile)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(commonName(s)).To(Equal("first"))
+		})
+
+		It("returns an error if the files can not be loaded", func() {
+			_, err := Load(certFile, filepath.Join(dir, "missing.pem"))
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Reload", func() {
+		It("loads the new certificate", func() {
+			s, err := Load(certFile, keyFile)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			writeCert("second", time.Now())
+
+			Expect(s.Reload()).To(Succeed())
+			Expect(commonName(s)).To(Equal("second"))
+		})
+
+		It("retains the previous certificate if the files are invalid", func() {
+			s, err := Load(certFile, keyFile)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = ioutil.WriteFile(keyFile, []byte("<invalid>"), 0600)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(s.Reload()).ShouldNot(Succeed())
+			Expect(commonName(s)).To(Equal("first"))
+		})
+	})
+
+	Describe("Watch", func() {
+		It("reloads the certificate when the files are modified", func() {
+			s, err := Load(certFile, keyFile)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			done := make(chan struct{})
+			defer close(done)
+
+			// errors are expected if the files are read mid-write
+			go s.Watch(done, 5*time.Millisecond, func(error) {})
+
+			writeCert("second", time.Now())
+
+			Eventually(func() string {
+				return commonName(s)
+			}).Should(Equal("second"))
+		})
+	})
+})
@@ -0,0 +1,16 @@
+package clientaddr
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a new context that carries the client information i.
+func NewContext(ctx context.Context, i Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, i)
+}
+
+// FromContext returns the client information carried by ctx, if any.
+func FromContext(ctx context.Context) (Info, bool) {
+	i, ok := ctx.Value(contextKey{}).(Info)
+	return i, ok
+}
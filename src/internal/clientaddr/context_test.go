@@ -0,0 +1,25 @@
+package clientaddr_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/clientaddr"
+)
+
+var _ = Describe("FromContext", func() {
+	It("returns the information added by NewContext", func() {
+		info := Info{IP: "192.0.2.1"}
+		ctx := NewContext(context.Background(), info)
+
+		i, ok := FromContext(ctx)
+		Expect(ok).To(BeTrue())
+		Expect(i).To(Equal(info))
+	})
+
+	It("returns false if the context has no client information", func() {
+		_, ok := FromContext(context.Background())
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,170 @@
+package clientaddr
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/gddo/httputil/header"
+)
+
+// hop is a single forwarding step described by the Forwarded or
+// X-Forwarded-* headers.
+type hop struct {
+	For   string
+	Proto string
+	Host  string
+}
+
+// Header identifies the forwarding header that trusted proxies use to report
+// the client's address.
+type Header int
+
+const (
+	// XForwardedFor is the de-facto standard X-Forwarded-For header, along with
+	// X-Forwarded-Proto and X-Forwarded-Host.
+	XForwardedFor Header = iota
+
+	// Forwarded is the RFC 7239 Forwarded header.
+	Forwarded
+)
+
+// ParseHeader parses the name of a forwarding header, either
+// "x-forwarded-for" or "forwarded". An empty string is treated as
+// "x-forwarded-for".
+func ParseHeader(s string) (Header, error) {
+	switch strings.ToLower(s) {
+	case "", "x-forwarded-for":
+		return XForwardedFor, nil
+	case "forwarded":
+		return Forwarded, nil
+	default:
+		return 0, fmt.Errorf("unknown forwarding header: %s", s)
+	}
+}
+
+func (h Header) String() string {
+	if h == Forwarded {
+		return "forwarded"
+	}
+
+	return "x-forwarded-for"
+}
+
+// forwardedHops returns the hops described by the forwarding header f in h,
+// ordered from the client towards the server.
+//
+// Only the configured header is consulted. A client can send either header
+// through a proxy that only appends to the other, so the other header can not
+// be trusted even when it is present.
+func forwardedHops(h http.Header, f Header) []hop {
+	if f == Forwarded {
+		return parseForwarded(strings.Join(h["Forwarded"], ","))
+	}
+
+	fors := header.ParseList(h, "X-Forwarded-For")
+	protos := header.ParseList(h, "X-Forwarded-Proto")
+	hosts := header.ParseList(h, "X-Forwarded-Host")
+
+	hops := make([]hop, len(fors))
+	for i, f := range fors {
+		hops[i] = hop{
+			For:   nodeAddr(f),
+			Proto: strings.ToLower(listValue(protos, i, len(fors))),
+			Host:  listValue(hosts, i, len(fors)),
+		}
+	}
+
+	return hops
+}
+
+// listValue returns the value in values that corresponds to the i'th of n
+// hops. Values can only be attributed to hops when there is exactly one per
+// hop, otherwise the header is ignored, as any of its values may have been set
+// by the client.
+func listValue(values []string, i, n int) string {
+	if len(values) == n {
+		return values[i]
+	}
+
+	return ""
+}
+
+// parseForwarded parses the value of an RFC 7239 Forwarded header.
+func parseForwarded(v string) []hop {
+	var hops []hop
+
+	for _, element := range splitQuoted(v, ',') {
+		var h hop
+
+		for _, pair := range splitQuoted(element, ';') {
+			i := strings.IndexByte(pair, '=')
+			if i == -1 {
+				continue
+			}
+
+			key := strings.ToLower(strings.TrimSpace(pair[:i]))
+			value := unquote(strings.TrimSpace(pair[i+1:]))
+
+			switch key {
+			case "for":
+				h.For = nodeAddr(value)
+			case "proto":
+				h.Proto = strings.ToLower(value)
+			case "host":
+				h.Host = value
+			}
+		}
+
+		hops = append(hops, h)
+	}
+
+	return hops
+}
+
+// splitQuoted splits s on sep, ignoring separators that appear within quoted
+// strings.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts   []string
+		start   int
+		quoted  bool
+		escaped bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes and escape sequences from an HTTP quoted-string.
+// Values that are not quoted are returned unchanged.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	s = s[1 : len(s)-1]
+	buf := make([]byte, 0, len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		buf = append(buf, s[i])
+	}
+
+	return string(buf)
+}
@@ -0,0 +1,136 @@
+package clientaddr
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Info describes the client that originated an HTTP request.
+type Info struct {
+	// IP is the client's IP address. If a trusted proxy has obfuscated the
+	// client's address this is the identifier supplied by the proxy instead.
+	IP string
+
+	// Proto is the protocol used by the client to make the request, as reported
+	// by a trusted proxy, or empty if unknown.
+	Proto string
+
+	// Host is the host requested by the client, as reported by a trusted proxy,
+	// or empty if unknown.
+	Host string
+}
+
+// Resolver determines the client of an HTTP request.
+//
+// Forwarding headers are honoured only when they are added by trusted proxies.
+// The configured header, either Forwarded (RFC 7239) or X-Forwarded-For, is
+// walked from right to left, skipping trusted hops, such that the client is the
+// first untrusted address encountered. The other header is always ignored.
+type Resolver struct {
+	header  Header
+	trusted []*net.IPNet
+}
+
+// NewResolver returns a resolver that trusts the forwarding header h when it
+// is added by proxies within the given networks.
+func NewResolver(h Header, trusted ...*net.IPNet) *Resolver {
+	return &Resolver{h, trusted}
+}
+
+// Resolve returns information about the client of r.
+func (res *Resolver) Resolve(r *http.Request) Info {
+	info := Info{IP: nodeAddr(r.RemoteAddr)}
+
+	if !res.isTrusted(info.IP) {
+		return info
+	}
+
+	hops := forwardedHops(r.Header, res.header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if h.For == "" {
+			break
+		}
+
+		info = Info{IP: h.For, Proto: h.Proto, Host: h.Host}
+
+		if !res.isTrusted(h.For) {
+			break
+		}
+	}
+
+	return info
+}
+
+// isTrusted returns true if addr is an IP address within one of the trusted
+// networks.
+func (res *Resolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range res.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseNetworks parses a comma-separated list of networks in CIDR notation.
+// Bare IP addresses are treated as networks containing a single host.
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: v}
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// nodeAddr returns the host portion of addr, which may or may not include a
+// port. IP addresses are returned in their canonical form.
+func nodeAddr(addr string) string {
+	addr = strings.TrimSpace(addr)
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+
+	return addr
+}
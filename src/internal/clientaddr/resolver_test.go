@@ -0,0 +1,202 @@
+package clientaddr_test
+
+import (
+	"net"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/clientaddr"
+)
+
+var _ = Describe("Resolver", func() {
+	var (
+		networks []*net.IPNet
+		subject  *Resolver
+	)
+
+	BeforeEach(func() {
+		var err error
+		networks, err = ParseNetworks("10.0.0.0/8, 2001:db8::/32")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		subject = NewResolver(XForwardedFor, networks...)
+	})
+
+	Describe("Resolve", func() {
+		It("uses the remote address when it is not trusted", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:9981"
+			r.Header.Add("X-Forwarded-For", "192.0.2.100")
+
+			Expect(subject.Resolve(r)).To(Equal(Info{IP: "192.0.2.1"}))
+		})
+
+		It("supports remote addresses without ports", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.2"
+
+			Expect(subject.Resolve(r)).To(Equal(Info{IP: "192.0.2.2"}))
+		})
+
+		It("uses the remote address when a trusted proxy adds no headers", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.1:9981"
+
+			Expect(subject.Resolve(r)).To(Equal(Info{IP: "10.0.0.1"}))
+		})
+
+		DescribeTable(
+			"walks the X-Forwarded-For header from right to left",
+			func(xff string, expected string) {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.RemoteAddr = "10.0.0.1:9981"
+				r.Header.Add("X-Forwarded-For", xff)
+
+				Expect(subject.Resolve(r).IP).To(Equal(expected))
+			},
+			Entry("single hop", "192.0.2.1", "192.0.2.1"),
+			Entry("spoofed entry", "192.0.2.66, 192.0.2.1", "192.0.2.1"),
+			Entry("trusted hops", "192.0.2.1, 10.1.1.1, 10.2.2.2", "192.0.2.1"),
+			Entry("all trusted", "10.1.1.1, 10.2.2.2", "10.1.1.1"),
+			Entry("with port", "192.0.2.1:1234", "192.0.2.1"),
+			Entry("IPv6", "[2001:db9::1]:1234", "2001:db9::1"),
+		)
+
+		It("includes the X-Forwarded-Proto and X-Forwarded-Host values", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.1:9981"
+			r.Header.Add("X-Forwarded-For", "192.0.2.1")
+			r.Header.Add("X-Forwarded-Proto", "HTTPS")
+			r.Header.Add("X-Forwarded-Host", "app.example.com")
+
+			Expect(subject.Resolve(r)).To(Equal(Info{
+				IP:    "192.0.2.1",
+				Proto: "https",
+				Host:  "app.example.com",
+			}))
+		})
+
+		It("ignores X-Forwarded-Proto and X-Forwarded-Host values that do not match the hops", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.1:9981"
+			r.Header.Add("X-Forwarded-For", "192.0.2.1, 10.1.1.1")
+			r.Header.Add("X-Forwarded-Proto", "https")
+			r.Header.Add("X-Forwarded-Host", "spoofed.example.com")
+
+			Expect(subject.Resolve(r)).To(Equal(Info{IP: "192.0.2.1"}))
+		})
+
+		It("ignores the Forwarded header when X-Forwarded-For is trusted", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.1:9981"
+			r.Header.Add("Forwarded", "for=192.0.2.66")
+			r.Header.Add("X-Forwarded-For", "192.0.2.1")
+
+			Expect(subject.Resolve(r)).To(Equal(Info{IP: "192.0.2.1"}))
+		})
+
+		It("ignores the X-Forwarded-For header when Forwarded is trusted", func() {
+			subject = NewResolver(Forwarded, networks...)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.0.0.1:9981"
+			r.Header.Add("X-Forwarded-For", "192.0.2.66")
+
+			Expect(subject.Resolve(r)).To(Equal(Info{IP: "10.0.0.1"}))
+		})
+
+		DescribeTable(
+			"walks the Forwarded header from right to left",
+			func(fwd string, expected Info) {
+				subject = NewResolver(Forwarded, networks...)
+
+				r := httptest.NewRequest("GET", "/", nil)
+				r.RemoteAddr = "[2001:db8::1]:9981"
+				r.Header.Add("Forwarded", fwd)
+				r.Header.Add("X-Forwarded-For", "192.0.2.200")
+
+				Expect(subject.Resolve(r)).To(Equal(expected))
+			},
+			Entry(
+				"single hop",
+				`for=192.0.2.1;proto=https;host=app.example.com`,
+				Info{IP: "192.0.2.1", Proto: "https", Host: "app.example.com"},
+			),
+			Entry(
+				"spoofed entry",
+				`for=192.0.2.66;proto=http, for=192.0.2.1;proto=https`,
+				Info{IP: "192.0.2.1", Proto: "https"},
+			),
+			Entry(
+				"trusted hops",
+				`for=192.0.2.1;proto=https, for=10.1.1.1;proto=http`,
+				Info{IP: "192.0.2.1", Proto: "https"},
+			),
+			Entry(
+				"quoted IPv6 with port",
+				`For="[2001:db9::1]:4711";Host="app.example.com"`,
+				Info{IP: "2001:db9::1", Host: "app.example.com"},
+			),
+			Entry(
+				"obfuscated client",
+				`for=_hidden, for=10.1.1.1`,
+				Info{IP: "_hidden"},
+			),
+			Entry(
+				"quoted separators",
+				`for=192.0.2.1;host="a,b;c", for=10.1.1.1`,
+				Info{IP: "192.0.2.1", Host: "a,b;c"},
+			),
+			Entry(
+				"missing for parameter",
+				`proto=https, for=10.1.1.1`,
+				Info{IP: "10.1.1.1"},
+			),
+		)
+	})
+})
+
+var _ = Describe("ParseHeader", func() {
+	DescribeTable(
+		"parses forwarding header names",
+		func(s string, expected Header) {
+			h, err := ParseHeader(s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(h).To(Equal(expected))
+		},
+		Entry("empty", "", XForwardedFor),
+		Entry("x-forwarded-for", "X-Forwarded-For", XForwardedFor),
+		Entry("forwarded", "forwarded", Forwarded),
+	)
+
+	It("returns an error for unknown headers", func() {
+		_, err := ParseHeader("x-real-ip")
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("ParseNetworks", func() {
+	It("parses CIDR networks and bare IP addresses", func() {
+		networks, err := ParseNetworks("10.0.0.0/8,192.0.2.1, ::1")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(networks).To(HaveLen(3))
+
+		Expect(networks[0].Contains(net.ParseIP("10.1.2.3"))).To(BeTrue())
+		Expect(networks[1].Contains(net.ParseIP("192.0.2.1"))).To(BeTrue())
+		Expect(networks[1].Contains(net.ParseIP("192.0.2.2"))).To(BeFalse())
+		Expect(networks[2].Contains(net.ParseIP("::1"))).To(BeTrue())
+	})
+
+	It("returns an empty list for an empty string", func() {
+		networks, err := ParseNetworks("")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(networks).To(BeEmpty())
+	})
+
+	It("returns an error for invalid networks", func() {
+		_, err := ParseNetworks("10.0.0.0/8,not-an-ip")
+		Expect(err).Should(HaveOccurred())
+	})
+})
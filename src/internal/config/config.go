@@ -0,0 +1,243 @@
+// Package config loads and validates the configuration of rinq-httpd.
+//
+// The configuration is read from an optional YAML file. Environment variables
+// override the values from the file, and are named by the "env" tag of each
+// field. Fields tagged with "restart" can not be changed by reloading the
+// configuration, the server must be restarted for changes to take effect.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of rinq-httpd.
+type Config struct {
+	Bind             string   `yaml:"bind" env:"RINQ_HTTPD_BIND" restart:"true"`
+	Origins          []string `yaml:"origins" env:"RINQ_HTTPD_ORIGIN"`
+	DenyNullOrigin   bool     `yaml:"deny_null_origin" env:"RINQ_HTTPD_DENY_NULL_ORIGIN"`
+	TrustedProxies   []string `yaml:"trusted_proxies" env:"RINQ_HTTPD_TRUSTED_PROXIES"`
+	ForwardedHeader  string   `yaml:"forwarded_header" env:"RINQ_HTTPD_FORWARDED_HEADER"`
+	Encodings        []string `yaml:"encodings" env:"RINQ_HTTPD_ENCODINGS" restart:"true"`
+	PingInterval     Duration `yaml:"ping_interval" env:"RINQ_HTTPD_PING,seconds"`
+	MaxMessageSize   int64    `yaml:"max_message_size" env:"RINQ_HTTPD_MAX_MSG_SIZE"`
+	FanOutNamespaces []string `yaml:"fan_out_namespaces" env:"RINQ_HTTPD_FANOUT_NAMESPACES" restart:"true"`
+	CallPolicyFile   string   `yaml:"call_policy_file" env:"RINQ_HTTPD_CALL_POLICY_FILE"`
+
+	TLS           TLS           `yaml:"tls"`
+	ProxyProtocol ProxyProtocol `yaml:"proxy_protocol"`
+	Admin         Admin         `yaml:"admin"`
+	Limits        Limits        `yaml:"limits"`
+	Timeouts      Timeouts      `yaml:"timeouts"`
+	Batching      Batching      `yaml:"batching"`
+	Resume        Resume        `yaml:"resume"`
+	Idempotency   Idempotency   `yaml:"idempotency"`
+	Breakers      Breakers      `yaml:"breakers"`
+	Cache         Cache         `yaml:"cache"`
+	Audit         Audit         `yaml:"audit"`
+	AccessLog     AccessLog     `yaml:"access_log"`
+
+	// parsed holds the values parsed from the fields above by Validate().
+	parsed parsed
+}
+
+// TLS is the configuration of the public listener's TLS support.
+type TLS struct {
+	Cert       string `yaml:"cert" env:"RINQ_HTTPD_TLS_CERT" restart:"true"`
+	Key        string `yaml:"key" env:"RINQ_HTTPD_TLS_KEY" restart:"true"`
+	ClientCA   string `yaml:"client_ca" env:"RINQ_HTTPD_TLS_CLIENT_CA" restart:"true"`
+	ClientAuth string `yaml:"client_auth" env:"RINQ_HTTPD_TLS_CLIENT_AUTH" restart:"true"`
+}
+
+// ProxyProtocol is the configuration of PROXY protocol support on the public
+// listener.
+type ProxyProtocol struct {
+	Enabled bool     `yaml:"enabled" env:"RINQ_HTTPD_PROXY_PROTOCOL" restart:"true"`
+	Trusted []string `yaml:"trusted" env:"RINQ_HTTPD_PROXY_PROTOCOL_TRUSTED" restart:"true"`
+	Timeout Duration `yaml:"timeout" env:"RINQ_HTTPD_PROXY_PROTOCOL_TIMEOUT,seconds" restart:"true"`
+}
+
+// Admin is the configuration of the admin listener.
+type Admin struct {
+	Bind     string `yaml:"bind" env:"RINQ_HTTPD_ADMIN_BIND" restart:"true"`
+	Username string `yaml:"username" env:"RINQ_HTTPD_ADMIN_USERNAME"`
+	Password string `yaml:"password" env:"RINQ_HTTPD_ADMIN_PASSWORD" secret:"true"`
+	Token    string `yaml:"token" env:"RINQ_HTTPD_ADMIN_TOKEN" secret:"true"`
+}
+
+// Limits is the configuration of connection, session and call limits.
+type Limits struct {
+	MaxConnections             int      `yaml:"max_connections" env:"RINQ_HTTPD_MAX_CONNECTIONS"`
+	MaxConnectionsPerIP        int      `yaml:"max_connections_per_ip" env:"RINQ_HTTPD_MAX_CONNECTIONS_PER_IP"`
+	MaxSessions                int      `yaml:"max_sessions" env:"RINQ_HTTPD_MAX_SESSIONS"`
+	MaxInFlightCalls           int      `yaml:"max_inflight_calls" env:"RINQ_HTTPD_MAX_INFLIGHT_CALLS"`
+	MaxInFlightCallsPerSession int      `yaml:"max_inflight_calls_per_session" env:"RINQ_HTTPD_MAX_INFLIGHT_CALLS_PER_SESSION"`
+	OverloadPolicy             string   `yaml:"overload_policy" env:"RINQ_HTTPD_OVERLOAD_POLICY"`
+	OutboundQueueSize          int      `yaml:"outbound_queue_size" env:"RINQ_HTTPD_OUTBOUND_QUEUE_SIZE"`
+	FrameRate                  float64  `yaml:"frame_rate" env:"RINQ_HTTPD_FRAME_RATE"`
+	FrameBurst                 int      `yaml:"frame_burst" env:"RINQ_HTTPD_FRAME_BURST"`
+	CallRate                   float64  `yaml:"call_rate" env:"RINQ_HTTPD_CALL_RATE"`
+	CallBurst                  int      `yaml:"call_burst" env:"RINQ_HTTPD_CALL_BURST"`
+	NamespaceCallRates         []string `yaml:"namespace_call_rates" env:"RINQ_HTTPD_NAMESPACE_CALL_RATES"`
+	RateLimitPolicy            string   `yaml:"rate_limit_policy" env:"RINQ_HTTPD_RATE_LIMIT_POLICY"`
+}
+
+// Timeouts is the configuration of connection timeouts.
+type Timeouts struct {
+	Write      Duration `yaml:"write" env:"RINQ_HTTPD_WRITE_TIMEOUT,seconds"`
+	SlowClient Duration `yaml:"slow_client" env:"RINQ_HTTPD_SLOW_CLIENT_TIMEOUT,seconds"`
+	Drain      Duration `yaml:"drain" env:"RINQ_HTTPD_DRAIN_TIMEOUT,seconds"`
+}
+
+// Batching is the configuration of the upper bounds of the batching window
+// that clients may request.
+type Batching struct {
+	MaxDelay Duration `yaml:"max_delay" env:"RINQ_HTTPD_MAX_BATCH_DELAY,milliseconds"`
+	MaxCount int      `yaml:"max_count" env:"RINQ_HTTPD_MAX_BATCH_COUNT"`
+	MaxBytes int      `yaml:"max_bytes" env:"RINQ_HTTPD_MAX_BATCH_BYTES"`
+}
+
+// Resume is the configuration of session resumption.
+type Resume struct {
+	GracePeriod  Duration `yaml:"grace_period" env:"RINQ_HTTPD_RESUME_GRACE_PERIOD,seconds" restart:"true"`
+	BufferSize   int      `yaml:"buffer_size" env:"RINQ_HTTPD_RESUME_BUFFER_SIZE" restart:"true"`
+	BindClientIP bool     `yaml:"bind_client_ip" env:"RINQ_HTTPD_RESUME_BIND_CLIENT_IP" restart:"true"`
+}
+
+// Idempotency is the configuration of the idempotency store.
+type Idempotency struct {
+	StoreSize int      `yaml:"store_size" env:"RINQ_HTTPD_IDEMPOTENCY_STORE_SIZE" restart:"true"`
+	TTL       Duration `yaml:"ttl" env:"RINQ_HTTPD_IDEMPOTENCY_TTL,seconds" restart:"true"`
+}
+
+// Breakers is the configuration of the per-namespace circuit breakers.
+type Breakers struct {
+	FailureRatio float64  `yaml:"failure_ratio" env:"RINQ_HTTPD_BREAKER_FAILURE_RATIO" restart:"true"`
+	Window       Duration `yaml:"window" env:"RINQ_HTTPD_BREAKER_WINDOW,seconds" restart:"true"`
+	MinRequests  int      `yaml:"min_requests" env:"RINQ_HTTPD_BREAKER_MIN_REQUESTS" restart:"true"`
+	OpenTimeout  Duration `yaml:"open_timeout" env:"RINQ_HTTPD_BREAKER_OPEN_TIMEOUT,seconds" restart:"true"`
+	Probes       int      `yaml:"probes" env:"RINQ_HTTPD_BREAKER_PROBES" restart:"true"`
+	Namespaces   []string `yaml:"namespaces" env:"RINQ_HTTPD_BREAKER_NAMESPACES" restart:"true"`
+}
+
+// Cache is the configuration of the response cache.
+type Cache struct {
+	Rules []string `yaml:"rules" env:"RINQ_HTTPD_CACHE_RULES" restart:"true"`
+	Size  int      `yaml:"size" env:"RINQ_HTTPD_CACHE_SIZE" restart:"true"`
+}
+
+// Audit is the configuration of the audit log.
+type Audit struct {
+	Log        string   `yaml:"log" env:"RINQ_HTTPD_AUDIT_LOG" restart:"true"`
+	Namespaces []string `yaml:"namespaces" env:"RINQ_HTTPD_AUDIT_NAMESPACES" restart:"true"`
+	Payloads   bool     `yaml:"payloads" env:"RINQ_HTTPD_AUDIT_PAYLOADS" restart:"true"`
+	Redact     []string `yaml:"redact" env:"RINQ_HTTPD_AUDIT_REDACT" restart:"true"`
+	MaxSize    int64    `yaml:"max_size" env:"RINQ_HTTPD_AUDIT_MAX_SIZE" restart:"true"`
+	MaxBackups int      `yaml:"max_backups" env:"RINQ_HTTPD_AUDIT_MAX_BACKUPS" restart:"true"`
+}
+
+// AccessLog is the configuration of the access log.
+type AccessLog struct {
+	Log        string `yaml:"log" env:"RINQ_HTTPD_ACCESS_LOG" restart:"true"`
+	Format     string `yaml:"format" env:"RINQ_HTTPD_ACCESS_LOG_FORMAT" restart:"true"`
+	MaxSize    int64  `yaml:"max_size" env:"RINQ_HTTPD_ACCESS_MAX_SIZE" restart:"true"`
+	MaxBackups int    `yaml:"max_backups" env:"RINQ_HTTPD_ACCESS_MAX_BACKUPS" restart:"true"`
+}
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		ForwardedHeader: "x-forwarded-for",
+		Encodings:       []string{"cbor", "json"},
+		PingInterval:    Duration(10 * time.Second),
+		MaxMessageSize:  int64(units.Megabyte),
+		TLS: TLS{
+			ClientAuth: "optional",
+		},
+		ProxyProtocol: ProxyProtocol{
+			Timeout: Duration(5 * time.Second),
+		},
+		Limits: Limits{
+			OverloadPolicy:    "backpressure",
+			OutboundQueueSize: websock.DefaultOutboundQueueSize,
+			RateLimitPolicy:   "reject",
+		},
+		Timeouts: Timeouts{
+			Write:      Duration(websock.DefaultWriteTimeout),
+			SlowClient: Duration(websock.DefaultSlowClientTimeout),
+			Drain:      Duration(30 * time.Second),
+		},
+		Batching: Batching{
+			MaxDelay: Duration(native.DefaultMaxBatchDelay),
+			MaxCount: native.DefaultMaxBatchCount,
+			MaxBytes: native.DefaultMaxBatchBytes,
+		},
+		Resume: Resume{
+			BufferSize: native.DefaultResumeBufferSize,
+		},
+		Idempotency: Idempotency{
+			StoreSize: native.DefaultIdempotencyStoreSize,
+			TTL:       Duration(native.DefaultIdempotencyTTL),
+		},
+		Breakers: Breakers{
+			Window:      Duration(10 * time.Second),
+			MinRequests: 20,
+			OpenTimeout: Duration(30 * time.Second),
+		},
+		Cache: Cache{
+			Size: 64 * int(units.Megabyte),
+		},
+		Audit: Audit{
+			MaxSize:    100 * int64(units.Megabyte),
+			MaxBackups: 5,
+		},
+		AccessLog: AccessLog{
+			Format:     "combined",
+			MaxSize:    100 * int64(units.Megabyte),
+			MaxBackups: 5,
+		},
+	}
+}
+
+// Load returns the configuration read from the YAML file at path, if path is
+// non-empty, overridden by the environment variables returned by lookup. The
+// configuration is validated before it is returned.
+//
+// Unknown fields in the file are rejected, as are environment variables that
+// can not be parsed.
+func Load(path string, lookup func(string) (string, bool)) (Config, error) {
+	c := Default()
+
+	if path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		if err := yaml.UnmarshalStrict(buf, &c); err != nil {
+			return Config{}, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if err := applyEnv(&c, lookup); err != nil {
+		return Config{}, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
+
+// Marshal returns c as YAML, with secrets redacted.
+func (c Config) Marshal() ([]byte, error) {
+	redact(&c)
+	return yaml.Marshal(c)
+}
@@ -0,0 +1,208 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/internal/config"
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+var _ = Describe("Load", func() {
+	var (
+		dir string
+		env map[string]string
+	)
+
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	write := func(content string) string {
+		path := filepath.Join(dir, "rinq-httpd.yaml")
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		Expect(err).ShouldNot(HaveOccurred())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "rinq-httpd-config")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		env = map[string]string{}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("returns the default configuration when there is no file or environment", func() {
+		c, err := config.Load("", lookup)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.PingInterval).To(Equal(config.Duration(10 * time.Second)))
+		Expect(c.EncodingList()).To(Equal([]message.Encoding{
+			message.CBOREncoding,
+			message.JSONEncoding,
+		}))
+	})
+
+	It("reads values from the file", func() {
+		path := write(`
+bind: ":8080"
+origins: ["https://*.example.org"]
+encodings: [json]
+ping_interval: 1m30s
+limits:
+  max_sessions: 10
+  namespace_call_rates: ["ns=5:10"]
+`)
+
+		c, err := config.Load(path, lookup)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.Bind).To(Equal(":8080"))
+		Expect(c.OriginPatterns()).To(HaveLen(1))
+		Expect(c.EncodingList()).To(Equal([]message.Encoding{message.JSONEncoding}))
+		Expect(c.PingInterval).To(Equal(config.Duration(90 * time.Second)))
+		Expect(c.Limits.MaxSessions).To(Equal(10))
+		Expect(c.NamespaceRates()).To(Equal([]config.NamespaceRate{{Namespace: "ns", Rate: 5, Burst: 10}}))
+	})
+
+	It("overrides values from the file with environment variables", func() {
+		path := write(`
+bind: ":8080"
+ping_interval: 1m
+batching:
+  max_delay: 1s
+`)
+		env["RINQ_HTTPD_BIND"] = ":9090"
+		env["RINQ_HTTPD_PING"] = "5"
+		env["RINQ_HTTPD_MAX_BATCH_DELAY"] = "250"
+		env["RINQ_HTTPD_ORIGIN"] = "a.example.org, b.example.org"
+
+		c, err := config.Load(path, lookup)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.Bind).To(Equal(":9090"))
+		Expect(c.PingInterval).To(Equal(config.Duration(5 * time.Second)))
+		Expect(c.Batching.MaxDelay).To(Equal(config.Duration(250 * time.Millisecond)))
+		Expect(c.Origins).To(Equal([]string{"a.example.org", "b.example.org"}))
+	})
+
+	It("ignores empty environment variables", func() {
+		env["RINQ_HTTPD_PING"] = ""
+
+		c, err := config.Load("", lookup)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.PingInterval).To(Equal(config.Duration(10 * time.Second)))
+	})
+
+	It("returns an error if the file does not exist", func() {
+		_, err := config.Load(filepath.Join(dir, "missing.yaml"), lookup)
+
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("returns an error if the file contains unknown fields", func() {
+		path := write("ping_intervall: 10s\n")
+
+		_, err := config.Load(path, lookup)
+
+		Expect(err).To(MatchError(ContainSubstring("ping_intervall")))
+	})
+
+	It("returns an error if the file contains an invalid duration", func() {
+		path := write("ping_interval: 10\n")
+
+		_, err := config.Load(path, lookup)
+
+		Expect(err).To(MatchError(ContainSubstring(`invalid duration "10"`)))
+	})
+
+	DescribeTable(
+		"returns an error if an environment variable is invalid",
+		func(name, value, expected string) {
+			env[name] = value
+
+			_, err := config.Load("", lookup)
+
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("duration", "RINQ_HTTPD_PING", "10s", `invalid RINQ_HTTPD_PING: "10s", expected a non-negative integer`),
+		Entry("integer", "RINQ_HTTPD_MAX_MSG_SIZE", "1MB", `invalid RINQ_HTTPD_MAX_MSG_SIZE: "1MB", expected a non-negative integer`),
+		Entry("negative integer", "RINQ_HTTPD_MAX_SESSIONS", "-1", `invalid RINQ_HTTPD_MAX_SESSIONS: "-1", expected a non-negative integer`),
+		Entry("boolean", "RINQ_HTTPD_DENY_NULL_ORIGIN", "yes", `invalid RINQ_HTTPD_DENY_NULL_ORIGIN: "yes", expected true or false`),
+		Entry("number", "RINQ_HTTPD_CALL_RATE", "fast", `invalid RINQ_HTTPD_CALL_RATE: "fast", expected a non-negative number`),
+	)
+
+	It("reports every invalid environment variable", func() {
+		env["RINQ_HTTPD_PING"] = "x"
+		env["RINQ_HTTPD_MAX_SESSIONS"] = "y"
+
+		_, err := config.Load("", lookup)
+
+		Expect(err).Should(HaveOccurred())
+		Expect(err.(config.Errors)).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Config", func() {
+	Describe("Marshal", func() {
+		It("redacts secrets", func() {
+			c := config.Default()
+			c.Admin.Username = "admin"
+			c.Admin.Password = "hunter2"
+			c.Admin.Token = "<token>"
+
+			buf, err := c.Marshal()
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(buf)).To(ContainSubstring("username: admin"))
+			Expect(string(buf)).To(ContainSubstring("password: '[REDACTED]'"))
+			Expect(string(buf)).NotTo(ContainSubstring("hunter2"))
+			Expect(string(buf)).NotTo(ContainSubstring("<token>"))
+		})
+
+		It("does not modify the configuration", func() {
+			c := config.Default()
+			c.Admin.Password = "hunter2"
+
+			_, err := c.Marshal()
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(c.Admin.Password).To(Equal("hunter2"))
+		})
+
+		It("produces YAML that can be loaded", func() {
+			c := config.Default()
+			c.Bind = ":8080"
+			c.PingInterval = config.Duration(time.Minute)
+
+			buf, err := c.Marshal()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			dir, err := ioutil.TempDir("", "rinq-httpd-config")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "rinq-httpd.yaml")
+			err = ioutil.WriteFile(path, buf, 0600)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			loaded, err := config.Load(path, func(string) (string, bool) { return "", false })
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(loaded.Bind).To(Equal(":8080"))
+			Expect(loaded.PingInterval).To(Equal(config.Duration(time.Minute)))
+		})
+	})
+})
@@ -0,0 +1,72 @@
+package config
+
+import "reflect"
+
+// Change describes a setting that differs between two configurations.
+type Change struct {
+	// Path is the YAML path of the setting, such as "limits.max_sessions".
+	Path string
+
+	// Restart is true if the new value only takes effect once the server is
+	// restarted.
+	Restart bool
+}
+
+// Diff returns the settings that differ between a and b, in the order that
+// they appear in the configuration.
+func Diff(a, b *Config) []Change {
+	values := map[string]reflect.Value{}
+	walk(reflect.ValueOf(b).Elem(), "", func(v reflect.Value, _ reflect.StructField, path string) {
+		values[path] = v
+	})
+
+	var changes []Change
+	walk(reflect.ValueOf(a).Elem(), "", func(v reflect.Value, f reflect.StructField, path string) {
+		if !equal(v, values[path]) {
+			changes = append(changes, Change{
+				Path:    path,
+				Restart: f.Tag.Get("restart") != "",
+			})
+		}
+	})
+
+	return changes
+}
+
+// equal returns true if a and b are equal. Empty and nil slices are considered
+// equal.
+func equal(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// Reload returns the configuration that is in effect once the configuration
+// has been reloaded from c to next, along with the settings that differ
+// between them.
+//
+// Settings that require a restart retain their values from c, such that the
+// result describes the configuration that is actually in effect. The result is
+// validated before it is returned.
+func Reload(c *Config, next Config) (Config, []Change, error) {
+	changes := Diff(c, &next)
+
+	values := map[string]reflect.Value{}
+	walk(reflect.ValueOf(c).Elem(), "", func(v reflect.Value, _ reflect.StructField, path string) {
+		values[path] = v
+	})
+
+	walk(reflect.ValueOf(&next).Elem(), "", func(v reflect.Value, f reflect.StructField, path string) {
+		if f.Tag.Get("restart") != "" {
+			v.Set(values[path])
+		}
+	})
+
+	if err := next.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+
+	return next, changes, nil
+}
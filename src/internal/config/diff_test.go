@@ -0,0 +1,86 @@
+package config_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/internal/config"
+)
+
+var _ = Describe("Diff", func() {
+	It("returns nil when the configurations are equal", func() {
+		a := config.Default()
+		b := config.Default()
+
+		Expect(config.Diff(&a, &b)).To(BeEmpty())
+	})
+
+	It("treats empty and nil lists as equal", func() {
+		a := config.Default()
+		b := config.Default()
+		b.Origins = []string{}
+
+		Expect(config.Diff(&a, &b)).To(BeEmpty())
+	})
+
+	It("returns the changed settings, in order", func() {
+		a := config.Default()
+		b := config.Default()
+		b.Bind = ":8080"
+		b.Origins = []string{"example.org"}
+		b.Limits.MaxSessions = 10
+		b.Timeouts.Write = config.Duration(time.Second)
+		b.Audit.Log = "-"
+
+		Expect(config.Diff(&a, &b)).To(Equal([]config.Change{
+			{Path: "bind", Restart: true},
+			{Path: "origins"},
+			{Path: "limits.max_sessions"},
+			{Path: "timeouts.write"},
+			{Path: "audit.log", Restart: true},
+		}))
+	})
+})
+
+var _ = Describe("Reload", func() {
+	It("applies settings that do not require a restart", func() {
+		c := config.Default()
+		next := config.Default()
+		next.Origins = []string{"example.org"}
+
+		r, changes, err := config.Reload(&c, next)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(changes).To(Equal([]config.Change{{Path: "origins"}}))
+		Expect(r.Origins).To(Equal([]string{"example.org"}))
+		Expect(r.OriginPatterns()).To(HaveLen(1))
+	})
+
+	It("retains the current value of settings that require a restart", func() {
+		c := config.Default()
+		next := config.Default()
+		next.Bind = ":8080"
+		next.Encodings = []string{"json"}
+
+		r, changes, err := config.Reload(&c, next)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(changes).To(Equal([]config.Change{
+			{Path: "bind", Restart: true},
+			{Path: "encodings", Restart: true},
+		}))
+		Expect(r.Bind).To(Equal(""))
+		Expect(r.EncodingList()).To(HaveLen(2))
+	})
+
+	It("returns an error if the result is invalid", func() {
+		c := config.Default()
+		next := config.Default()
+		next.PingInterval = 0
+
+		_, _, err := config.Reload(&c, next)
+
+		Expect(err).To(MatchError("ping_interval: must be positive"))
+	})
+})
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// applyEnv overrides the fields of c with the values of the environment
+// variables named by their "env" tags. Variables that are unset or empty are
+// ignored.
+//
+// The "env" tag of a Duration field must specify the unit of the variable's
+// value, which is either "seconds" or "milliseconds".
+func applyEnv(c *Config, lookup func(string) (string, bool)) error {
+	var errs Errors
+
+	walk(reflect.ValueOf(c).Elem(), "", func(v reflect.Value, f reflect.StructField, _ string) {
+		tag := f.Tag.Get("env")
+		if tag == "" {
+			return
+		}
+
+		name := strings.Split(tag, ",")[0]
+		s, ok := lookup(name)
+		if !ok || s == "" {
+			return
+		}
+
+		if err := setEnv(v, tag, s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %q, %s", name, s, err))
+		}
+	})
+
+	return errs.err()
+}
+
+// setEnv sets v to the value of an environment variable, s. tag is the
+// field's "env" tag.
+func setEnv(v reflect.Value, tag string, s string) error {
+	if v.Type() == durationType {
+		i, err := strconv.ParseUint(s, 10, 63)
+		if err != nil {
+			return fmt.Errorf("expected a non-negative integer")
+		}
+
+		unit := time.Second
+		if strings.HasSuffix(tag, ",milliseconds") {
+			unit = time.Millisecond
+		}
+
+		v.SetInt(int64(i) * int64(unit))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseUint(s, 10, 63)
+		if err != nil {
+			return fmt.Errorf("expected a non-negative integer")
+		}
+		v.SetInt(int64(i))
+
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("expected a non-negative number")
+		}
+		v.SetFloat(f)
+
+	case reflect.Slice:
+		var list []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+
+	default:
+		panic("unsupported field type: " + v.Type().String())
+	}
+
+	return nil
+}
+
+// redact replaces the values of the fields of c that are tagged as secret.
+func redact(c *Config) {
+	walk(reflect.ValueOf(c).Elem(), "", func(v reflect.Value, f reflect.StructField, _ string) {
+		if f.Tag.Get("secret") != "" && v.String() != "" {
+			v.SetString("[REDACTED]")
+		}
+	})
+}
+
+// walk calls fn for each exported, non-struct field of the struct v,
+// recursing into nested structs. path is the YAML path of the field.
+func walk(
+	v reflect.Value,
+	prefix string,
+	fn func(v reflect.Value, f reflect.StructField, path string),
+) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		path := prefix + strings.Split(f.Tag.Get("yaml"), ",")[0]
+
+		if f.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), path+".", fn)
+		} else {
+			fn(v.Field(i), f, path)
+		}
+	}
+}
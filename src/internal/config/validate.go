@@ -0,0 +1,343 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rinq/httpd/src/internal/clientaddr"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native"
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+// Errors is a list of configuration errors.
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// err returns e as an error, or nil if e is empty.
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// NamespaceRate is a call rate limit that applies to a single namespace.
+type NamespaceRate struct {
+	Namespace string
+	Rate      float64
+	Burst     int
+}
+
+// parsed holds the values that are parsed from a Config during validation.
+type parsed struct {
+	encodings            []message.Encoding
+	origins              []websock.OriginPattern
+	trustedProxies       []*net.IPNet
+	forwardedHeader      clientaddr.Header
+	proxyProtocolTrusted []*net.IPNet
+	clientAuth           tls.ClientAuthType
+	overloadPolicy       native.OverloadPolicy
+	rateLimitPolicy      native.RateLimitPolicy
+	namespaceRates       []NamespaceRate
+	cacheRules           []native.CacheRule
+	callPolicies         *native.CallPolicies
+	accessLogFormat      websock.AccessLogFormat
+}
+
+// Validate checks that c is valid, returning an Errors value that describes
+// each problem found. The accessor methods of c, such as OriginPatterns(), may
+// only be used once c has been validated.
+func (c *Config) Validate() error {
+	var errs Errors
+
+	check := func(path string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
+		}
+	}
+
+	walk(reflect.ValueOf(c).Elem(), "", func(v reflect.Value, _ reflect.StructField, path string) {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int64:
+			if v.Int() < 0 {
+				check(path, fmt.Errorf("must not be negative"))
+			}
+		case reflect.Float64:
+			if v.Float() < 0 {
+				check(path, fmt.Errorf("must not be negative"))
+			}
+		}
+	})
+
+	var err error
+	p := &c.parsed
+
+	if c.Admin.Bind != "" && c.Admin.Bind == c.Bind {
+		check("admin.bind", fmt.Errorf("must differ from bind"))
+	}
+
+	p.encodings, err = parseEncodings(c.Encodings)
+	check("encodings", err)
+
+	if c.PingInterval <= 0 {
+		check("ping_interval", fmt.Errorf("must be positive"))
+	}
+
+	if c.MaxMessageSize <= 0 {
+		check("max_message_size", fmt.Errorf("must be positive"))
+	}
+
+	p.origins, err = websock.ParseOriginPatterns(c.Origins...)
+	check("origins", err)
+
+	p.trustedProxies, err = clientaddr.ParseNetworks(strings.Join(c.TrustedProxies, ","))
+	check("trusted_proxies", err)
+
+	p.forwardedHeader, err = clientaddr.ParseHeader(c.ForwardedHeader)
+	check("forwarded_header", err)
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		check("tls", fmt.Errorf("cert and key must be specified together"))
+	}
+
+	if c.TLS.ClientCA != "" && c.TLS.Cert == "" {
+		check("tls.client_ca", fmt.Errorf("requires tls.cert and tls.key"))
+	}
+
+	p.clientAuth, err = parseClientAuth(c.TLS.ClientAuth)
+	check("tls.client_auth", err)
+
+	p.proxyProtocolTrusted, err = clientaddr.ParseNetworks(strings.Join(c.ProxyProtocol.Trusted, ","))
+	check("proxy_protocol.trusted", err)
+
+	if c.ProxyProtocol.Enabled && len(c.ProxyProtocol.Trusted) == 0 {
+		check("proxy_protocol.trusted", fmt.Errorf("is required when the PROXY protocol is enabled"))
+	}
+
+	if (c.Admin.Username == "") != (c.Admin.Password == "") {
+		check("admin", fmt.Errorf("username and password must be specified together"))
+	}
+
+	p.overloadPolicy, err = native.ParseOverloadPolicy(c.Limits.OverloadPolicy)
+	check("limits.overload_policy", err)
+
+	p.rateLimitPolicy, err = native.ParseRateLimitPolicy(c.Limits.RateLimitPolicy)
+	check("limits.rate_limit_policy", err)
+
+	p.namespaceRates = nil
+	for _, s := range c.Limits.NamespaceCallRates {
+		r, err := parseNamespaceRate(s)
+		check("limits.namespace_call_rates", err)
+		p.namespaceRates = append(p.namespaceRates, r)
+	}
+
+	if c.Breakers.FailureRatio > 1 {
+		check("breakers.failure_ratio", fmt.Errorf("must be between 0 and 1"))
+	}
+
+	if c.Breakers.FailureRatio > 0 && len(c.Breakers.Namespaces) == 0 {
+		check("breakers.namespaces", fmt.Errorf("is required when circuit breakers are enabled"))
+	}
+
+	p.cacheRules = nil
+	for _, s := range c.Cache.Rules {
+		r, err := parseCacheRule(s)
+		check("cache.rules", err)
+		p.cacheRules = append(p.cacheRules, r)
+	}
+
+	p.callPolicies, err = loadCallPolicies(c.CallPolicyFile)
+	check("call_policy_file", err)
+
+	p.accessLogFormat, err = websock.ParseAccessLogFormat(c.AccessLog.Format)
+	check("access_log.format", err)
+
+	return errs.err()
+}
+
+// parseEncodings returns the message encodings with the given names.
+func parseEncodings(names []string) ([]message.Encoding, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one encoding is required")
+	}
+
+	var encodings []message.Encoding
+	seen := map[string]bool{}
+
+	for _, n := range names {
+		if seen[n] {
+			return nil, fmt.Errorf("duplicate encoding %q", n)
+		}
+		seen[n] = true
+
+		switch n {
+		case message.CBOREncoding.Name():
+			encodings = append(encodings, message.CBOREncoding)
+		case message.JSONEncoding.Name():
+			encodings = append(encodings, message.JSONEncoding)
+		default:
+			return nil, fmt.Errorf("unknown encoding %q, expected cbor or json", n)
+		}
+	}
+
+	return encodings, nil
+}
+
+// parseClientAuth returns the TLS client authentication type with the given
+// name.
+func parseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth %q, expected optional or require", s)
+	}
+}
+
+// parseNamespaceRate parses a limit in the form "<namespace>=<rate>[:<burst>]".
+func parseNamespaceRate(s string) (NamespaceRate, error) {
+	i := strings.LastIndex(s, "=")
+	if i == -1 {
+		return NamespaceRate{}, fmt.Errorf("%q, expected <namespace>=<rate>[:<burst>]", s)
+	}
+
+	r := NamespaceRate{Namespace: s[:i]}
+	limit := s[i+1:]
+
+	if j := strings.Index(limit, ":"); j != -1 {
+		b, err := strconv.Atoi(limit[j+1:])
+		if err != nil || b < 0 {
+			return NamespaceRate{}, fmt.Errorf("%q, invalid burst", s)
+		}
+
+		limit, r.Burst = limit[:j], b
+	}
+
+	rate, err := strconv.ParseFloat(limit, 64)
+	if err != nil || rate < 0 {
+		return NamespaceRate{}, fmt.Errorf("%q, invalid rate", s)
+	}
+
+	r.Rate = rate
+
+	return r, nil
+}
+
+// parseCacheRule parses a rule in the form "<namespace>::<command>=<ttl>",
+// where command may be "*".
+func parseCacheRule(s string) (native.CacheRule, error) {
+	i := strings.LastIndex(s, "=")
+	j := strings.Index(s, "::")
+	if i == -1 || j == -1 || j > i {
+		return native.CacheRule{}, fmt.Errorf("%q, expected <namespace>::<command>=<ttl>", s)
+	}
+
+	ttl, err := time.ParseDuration(s[i+1:])
+	if err != nil || ttl <= 0 {
+		return native.CacheRule{}, fmt.Errorf("%q, invalid ttl", s)
+	}
+
+	return native.CacheRule{
+		Namespace: s[:j],
+		Command:   s[j+2 : i],
+		TTL:       ttl,
+	}, nil
+}
+
+// loadCallPolicies loads the call policies from the JSON file at path, if
+// path is non-empty.
+func loadCallPolicies(path string) (*native.CallPolicies, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return native.LoadCallPolicies(f)
+}
+
+// EncodingList returns the message encodings to support, in order of
+// preference.
+func (c *Config) EncodingList() []message.Encoding {
+	return c.parsed.encodings
+}
+
+// OriginPatterns returns the parsed origin patterns.
+func (c *Config) OriginPatterns() []websock.OriginPattern {
+	return c.parsed.origins
+}
+
+// TrustedProxyNetworks returns the parsed trusted proxy networks.
+func (c *Config) TrustedProxyNetworks() []*net.IPNet {
+	return c.parsed.trustedProxies
+}
+
+// ForwardingHeader returns the parsed forwarding header that trusted proxies
+// use to report the client's address.
+func (c *Config) ForwardingHeader() clientaddr.Header {
+	return c.parsed.forwardedHeader
+}
+
+// ProxyProtocolTrustedNetworks returns the parsed networks that are trusted to
+// send PROXY protocol headers.
+func (c *Config) ProxyProtocolTrustedNetworks() []*net.IPNet {
+	return c.parsed.proxyProtocolTrusted
+}
+
+// TLSClientAuth returns the parsed TLS client authentication type.
+func (c *Config) TLSClientAuth() tls.ClientAuthType {
+	return c.parsed.clientAuth
+}
+
+// OverloadPolicy returns the parsed overload policy.
+func (c *Config) OverloadPolicy() native.OverloadPolicy {
+	return c.parsed.overloadPolicy
+}
+
+// RateLimitPolicy returns the parsed rate limit policy.
+func (c *Config) RateLimitPolicy() native.RateLimitPolicy {
+	return c.parsed.rateLimitPolicy
+}
+
+// NamespaceRates returns the parsed per-namespace call rate limits.
+func (c *Config) NamespaceRates() []NamespaceRate {
+	return c.parsed.namespaceRates
+}
+
+// CacheRules returns the parsed response cache rules.
+func (c *Config) CacheRules() []native.CacheRule {
+	return c.parsed.cacheRules
+}
+
+// CallPolicies returns the call policies loaded from the call policy file, or
+// nil if there is no call policy file.
+func (c *Config) CallPolicies() *native.CallPolicies {
+	return c.parsed.callPolicies
+}
+
+// AccessLogFormat returns the parsed access log format.
+func (c *Config) AccessLogFormat() websock.AccessLogFormat {
+	return c.parsed.accessLogFormat
+}
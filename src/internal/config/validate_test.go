@@ -0,0 +1,86 @@
+package config_test
+
+import (
+	"crypto/tls"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/internal/config"
+)
+
+var _ = Describe("Validate", func() {
+	It("accepts the default configuration", func() {
+		c := config.Default()
+
+		Expect(c.Validate()).To(Succeed())
+	})
+
+	It("parses the TLS client authentication type", func() {
+		c := config.Default()
+		c.TLS.ClientAuth = "require"
+
+		Expect(c.Validate()).To(Succeed())
+		Expect(c.TLSClientAuth()).To(Equal(tls.RequireAndVerifyClientCert))
+	})
+
+	It("parses the cache rules", func() {
+		c := config.Default()
+		c.Cache.Rules = []string{"ns::*=5s"}
+
+		Expect(c.Validate()).To(Succeed())
+		Expect(c.CacheRules()).To(HaveLen(1))
+		Expect(c.CacheRules()[0].Namespace).To(Equal("ns"))
+		Expect(c.CacheRules()[0].Command).To(Equal("*"))
+		Expect(c.CacheRules()[0].TTL).To(Equal(5 * time.Second))
+	})
+
+	It("reports every problem", func() {
+		c := config.Default()
+		c.Encodings = nil
+		c.PingInterval = 0
+		c.Limits.MaxSessions = -1
+
+		err := c.Validate()
+
+		Expect(err).To(MatchError(
+			"limits.max_sessions: must not be negative\n" +
+				"encodings: at least one encoding is required\n" +
+				"ping_interval: must be positive",
+		))
+	})
+
+	DescribeTable(
+		"returns a descriptive error",
+		func(modify func(*config.Config), expected string) {
+			c := config.Default()
+			modify(&c)
+
+			Expect(c.Validate()).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("admin bind", func(c *config.Config) { c.Bind = ":8080"; c.Admin.Bind = ":8080" }, "admin.bind: must differ from bind"),
+		Entry("unknown encoding", func(c *config.Config) { c.Encodings = []string{"xml"} }, `encodings: unknown encoding "xml"`),
+		Entry("duplicate encoding", func(c *config.Config) { c.Encodings = []string{"json", "json"} }, `encodings: duplicate encoding "json"`),
+		Entry("max message size", func(c *config.Config) { c.MaxMessageSize = 0 }, "max_message_size: must be positive"),
+		Entry("origin", func(c *config.Config) { c.Origins = []string{"/[/"} }, `origins: invalid origin pattern "/[/"`),
+		Entry("trusted proxies", func(c *config.Config) { c.TrustedProxies = []string{"x.x.x.x"} }, "trusted_proxies: "),
+		Entry("forwarded header", func(c *config.Config) { c.ForwardedHeader = "x-real-ip" }, "forwarded_header: unknown forwarding header: x-real-ip"),
+		Entry("tls key", func(c *config.Config) { c.TLS.Cert = "cert.pem" }, "tls: cert and key must be specified together"),
+		Entry("tls client ca", func(c *config.Config) { c.TLS.ClientCA = "ca.pem" }, "tls.client_ca: requires tls.cert and tls.key"),
+		Entry("tls client auth", func(c *config.Config) { c.TLS.ClientAuth = "sometimes" }, `tls.client_auth: unknown client auth "sometimes"`),
+		Entry("proxy protocol trusted", func(c *config.Config) { c.ProxyProtocol.Enabled = true }, "proxy_protocol.trusted: is required when the PROXY protocol is enabled"),
+		Entry("admin password", func(c *config.Config) { c.Admin.Username = "admin" }, "admin: username and password must be specified together"),
+		Entry("overload policy", func(c *config.Config) { c.Limits.OverloadPolicy = "panic" }, "limits.overload_policy: "),
+		Entry("rate limit policy", func(c *config.Config) { c.Limits.RateLimitPolicy = "panic" }, "limits.rate_limit_policy: "),
+		Entry("namespace rate", func(c *config.Config) { c.Limits.NamespaceCallRates = []string{"ns"} }, `limits.namespace_call_rates: "ns", expected <namespace>=<rate>[:<burst>]`),
+		Entry("namespace burst", func(c *config.Config) { c.Limits.NamespaceCallRates = []string{"ns=1:x"} }, `limits.namespace_call_rates: "ns=1:x", invalid burst`),
+		Entry("failure ratio", func(c *config.Config) { c.Breakers.FailureRatio = 1.5 }, "breakers.failure_ratio: must be between 0 and 1"),
+		Entry("breaker namespaces", func(c *config.Config) { c.Breakers.FailureRatio = 0.5 }, "breakers.namespaces: is required when circuit breakers are enabled"),
+		Entry("cache rule", func(c *config.Config) { c.Cache.Rules = []string{"ns=5s"} }, `cache.rules: "ns=5s", expected <namespace>::<command>=<ttl>`),
+		Entry("cache ttl", func(c *config.Config) { c.Cache.Rules = []string{"ns::cmd=0s"} }, `cache.rules: "ns::cmd=0s", invalid ttl`),
+		Entry("call policy file", func(c *config.Config) { c.CallPolicyFile = "/nonexistent/policies.json" }, "call_policy_file: "),
+		Entry("access log format", func(c *config.Config) { c.AccessLog.Format = "apache" }, "access_log.format: "),
+		Entry("negative duration", func(c *config.Config) { c.Timeouts.Write = config.Duration(-time.Second) }, "timeouts.write: must not be negative"),
+	)
+})
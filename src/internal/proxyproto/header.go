@@ -0,0 +1,176 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoHeader is returned when a connection from a trusted source does not
+	// begin with a PROXY protocol header.
+	ErrNoHeader = errors.New("proxy protocol: connection did not begin with a header")
+
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// v1MaxLength is the maximum length of a version 1 header, including the
+	// terminating CRLF.
+	v1MaxLength = 107
+
+	v2CommandLocal = 0x0
+	v2CommandProxy = 0x1
+
+	v2FamilyInet  = 0x1
+	v2FamilyInet6 = 0x2
+)
+
+// readHeader reads a PROXY protocol header of either version from r, and
+// returns the source and destination addresses it describes.
+//
+// The addresses are nil if the header does not convey the addresses of the
+// original connection, such as for health checks performed by the proxy.
+func readHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	prefix, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(prefix, v1Prefix) {
+		return readV1(r)
+	}
+
+	prefix, err = r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(prefix, v2Signature) {
+		return readV2(r)
+	}
+
+	return nil, nil, ErrNoHeader
+}
+
+// readV1 reads a human-readable (version 1) header from r.
+func readV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > v1MaxLength {
+		return nil, nil, errors.New("proxy protocol: v1 header is too long")
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("proxy protocol: v1 header is not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("proxy protocol: malformed v1 header: %q", line)
+	}
+
+	var ipLen int
+	switch fields[1] {
+	case "TCP4":
+		ipLen = net.IPv4len
+	case "TCP6":
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported v1 protocol: %q", fields[1])
+	}
+
+	src, err = parseV1Addr(fields[2], fields[4], ipLen)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dst, err = parseV1Addr(fields[3], fields[5], ipLen)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return src, dst, nil
+}
+
+// parseV1Addr parses an address and port from a version 1 header.
+func parseV1Addr(addr, port string, ipLen int) (*net.TCPAddr, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil || (ipLen == net.IPv4len) != (ip.To4() != nil) {
+		return nil, fmt.Errorf("proxy protocol: invalid v1 address: %q", addr)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol: invalid v1 port: %q", port)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary (version 2) header from r.
+func readV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var hdr [16]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+
+	if version := hdr[12] >> 4; version != 2 {
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported version: %d", version)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	switch command := hdr[12] & 0xf; command {
+	case v2CommandLocal:
+		return nil, nil, nil
+	case v2CommandProxy:
+	default:
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported v2 command: 0x%x", command)
+	}
+
+	var ipLen int
+	switch family := hdr[13] >> 4; family {
+	case v2FamilyInet:
+		ipLen = net.IPv4len
+	case v2FamilyInet6:
+		ipLen = net.IPv6len
+	default:
+		// Unspecified, unix socket and other address families are not
+		// meaningful as client addresses, fallback to the addresses of the
+		// underlying connection.
+		return nil, nil, nil
+	}
+
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, errors.New("proxy protocol: v2 address block is too short")
+	}
+
+	src = &net.TCPAddr{
+		IP:   net.IP(body[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+	}
+
+	dst = &net.TCPAddr{
+		IP:   net.IP(body[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+	}
+
+	return src, dst, nil
+}
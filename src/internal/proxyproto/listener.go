@@ -0,0 +1,139 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener is a net.Listener that accepts connections that are prefixed with
+// a PROXY protocol (version 1 or 2) header, as sent by load balancers such as
+// HAProxy and AWS NLB.
+//
+// The addresses conveyed by the header are reported as the local and remote
+// addresses of the accepted connections.
+type Listener struct {
+	net.Listener
+
+	timeout time.Duration
+	trusted []*net.IPNet
+}
+
+// NewListener returns a listener that reads PROXY protocol headers from
+// connections accepted by l.
+//
+// Connections from sources within the trusted networks must begin with a PROXY
+// protocol header. Connections from other sources are passed through
+// unmodified. If no trusted networks are given, no sources are trusted.
+//
+// If timeout is non-zero, it is the maximum amount of time to wait for the
+// header to be received.
+func NewListener(l net.Listener, timeout time.Duration, trusted ...*net.IPNet) *Listener {
+	return &Listener{
+		Listener: l,
+		timeout:  timeout,
+		trusted:  trusted,
+	}
+}
+
+// Accept waits for and returns the next connection to the listener.
+//
+// The PROXY protocol header is not read until the connection is first used,
+// so that a slow client can not block the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	return &Conn{Conn: c, timeout: l.timeout}, nil
+}
+
+// isTrusted returns true if addr is permitted to send a PROXY protocol header.
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Conn is a net.Conn that begins with a PROXY protocol header.
+type Conn struct {
+	net.Conn
+
+	timeout time.Duration
+	once    sync.Once
+	reader  *bufio.Reader
+	remote  net.Addr
+	local   net.Addr
+	err     error
+}
+
+// Read reads data from the connection, after the PROXY protocol header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address conveyed by the PROXY protocol header,
+// or the remote address of the underlying connection if the header does not
+// include addresses.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address conveyed by the PROXY protocol
+// header, or the local address of the underlying connection if the header
+// does not include addresses.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+
+	if c.local != nil {
+		return c.local
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		if c.err = c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); c.err != nil {
+			return
+		}
+		defer func() {
+			_ = c.Conn.SetReadDeadline(time.Time{})
+		}()
+	}
+
+	c.reader = bufio.NewReader(c.Conn)
+	c.remote, c.local, c.err = readHeader(c.reader)
+}
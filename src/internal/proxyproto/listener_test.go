@@ -0,0 +1,176 @@
+package proxyproto_test
+
+import (
+	"io/ioutil"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/proxyproto"
+)
+
+var _ = Describe("Listener", func() {
+	var (
+		inner   net.Listener
+		subject *Listener
+	)
+
+	BeforeEach(func() {
+		var err error
+		inner, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		inner.Close()
+	})
+
+	// exchange sends data to the listener and returns the accepted connection
+	// and the data read from it.
+	exchange := func(data string) (net.Conn, string, error) {
+		client, err := net.Dial("tcp", inner.Addr().String())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = client.Write([]byte(data))
+		Expect(err).ShouldNot(HaveOccurred())
+		client.Close()
+
+		conn, err := subject.Accept()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		buf, err := ioutil.ReadAll(conn)
+		return conn, string(buf), err
+	}
+
+	Context("when the source is trusted", func() {
+		BeforeEach(func() {
+			_, n, _ := net.ParseCIDR("127.0.0.0/8")
+			subject = NewListener(inner, time.Second, n)
+		})
+
+		DescribeTable(
+			"reports the addresses from the header",
+			func(header, remote, local string) {
+				conn, data, err := exchange(header + "<data>")
+
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(data).To(Equal("<data>"))
+				Expect(conn.RemoteAddr().String()).To(Equal(remote))
+				Expect(conn.LocalAddr().String()).To(Equal(local))
+			},
+			Entry(
+				"v1 TCP4",
+				"PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n",
+				"192.0.2.1:56324",
+				"192.0.2.2:443",
+			),
+			Entry(
+				"v1 TCP6",
+				"PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+				"[2001:db8::1]:56324",
+				"[2001:db8::2]:443",
+			),
+			Entry(
+				"v2 IPv4",
+				"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"+
+					"\xc0\x00\x02\x01\xc0\x00\x02\x02\xdc\x04\x01\xbb",
+				"192.0.2.1:56324",
+				"192.0.2.2:443",
+			),
+			Entry(
+				"v2 IPv4 with TLVs",
+				"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x10"+
+					"\xc0\x00\x02\x01\xc0\x00\x02\x02\xdc\x04\x01\xbb"+
+					"\x04\x00\x01\x00",
+				"192.0.2.1:56324",
+				"192.0.2.2:443",
+			),
+			Entry(
+				"v2 IPv6",
+				"\r\n\r\n\x00\r\nQUIT\n\x21\x21\x00\x24"+
+					"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"+
+					"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02"+
+					"\xdc\x04\x01\xbb",
+				"[2001:db8::1]:56324",
+				"[2001:db8::2]:443",
+			),
+		)
+
+		DescribeTable(
+			"reports the underlying addresses when the header does not contain addresses",
+			func(header string) {
+				conn, data, err := exchange(header + "<data>")
+
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(data).To(Equal("<data>"))
+				Expect(conn.RemoteAddr().(*net.TCPAddr).IP.String()).To(Equal("127.0.0.1"))
+			},
+			Entry("v1 UNKNOWN", "PROXY UNKNOWN\r\n"),
+			Entry("v1 UNKNOWN with addresses", "PROXY UNKNOWN 192.0.2.1 192.0.2.2 56324 443\r\n"),
+			Entry("v2 LOCAL", "\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00"),
+			Entry("v2 UNSPEC", "\r\n\r\n\x00\r\nQUIT\n\x21\x00\x00\x00"),
+		)
+
+		DescribeTable(
+			"returns an error when the header is invalid",
+			func(header string) {
+				_, _, err := exchange(header + "<data>")
+				Expect(err).Should(HaveOccurred())
+			},
+			Entry("missing header", "GET / HTTP/1.1\r\n\r\n"),
+			Entry("v1 without CRLF", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n"),
+			Entry("v1 unsupported protocol", "PROXY UDP4 192.0.2.1 192.0.2.2 56324 443\r\n"),
+			Entry("v1 mismatched family", "PROXY TCP4 2001:db8::1 192.0.2.2 56324 443\r\n"),
+			Entry("v1 invalid port", "PROXY TCP4 192.0.2.1 192.0.2.2 99999 443\r\n"),
+			Entry("v1 missing fields", "PROXY TCP4 192.0.2.1\r\n"),
+			Entry("v2 unsupported version", "\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x00"),
+			Entry("v2 truncated addresses", "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\xc0\x00\x02\x01"),
+		)
+
+		It("returns an error when the header is not received in time", func() {
+			_, n, _ := net.ParseCIDR("127.0.0.0/8")
+			subject = NewListener(inner, 10*time.Millisecond, n)
+
+			client, err := net.Dial("tcp", inner.Addr().String())
+			Expect(err).ShouldNot(HaveOccurred())
+			defer client.Close()
+
+			conn, err := subject.Accept()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = conn.Read(make([]byte, 1))
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("when the source is not trusted", func() {
+		BeforeEach(func() {
+			_, n, _ := net.ParseCIDR("192.0.2.0/24")
+			subject = NewListener(inner, time.Second, n)
+		})
+
+		It("does not read a header", func() {
+			conn, data, err := exchange("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(data).To(Equal("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+			Expect(conn.RemoteAddr().(*net.TCPAddr).IP.String()).To(Equal("127.0.0.1"))
+		})
+	})
+
+	Context("when there are no trusted networks", func() {
+		BeforeEach(func() {
+			subject = NewListener(inner, time.Second)
+		})
+
+		It("does not read a header", func() {
+			conn, data, err := exchange("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n")
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(data).To(Equal("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"))
+			Expect(conn.RemoteAddr().(*net.TCPAddr).IP.String()).To(Equal("127.0.0.1"))
+		})
+	})
+})
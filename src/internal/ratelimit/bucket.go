@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket rate limiter.
+//
+// The bucket holds up to burst tokens, and is refilled at a constant rate.
+// Each event that is subject to the limit consumes one token.
+type Bucket struct {
+	rate  float64
+	burst float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket that is refilled at rate tokens per second,
+// and holds at most burst tokens. The burst is always at least one.
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Take consumes a token if one is available. It returns false if the bucket
+// is empty.
+func (b *Bucket) Take() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// Reserve consumes a token, borrowing against future refills if the bucket is
+// empty. It returns the amount of time the caller must wait before the token
+// is actually available.
+func (b *Bucket) Reserve() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	b.tokens--
+
+	if b.tokens >= 0 || b.rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *Bucket) refill() {
+	now := time.Now()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	b.last = now
+}
@@ -0,0 +1,55 @@
+package ratelimit_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/ratelimit"
+)
+
+var _ = Describe("Bucket", func() {
+	Describe("Take", func() {
+		It("allows bursts up to the bucket size", func() {
+			b := NewBucket(1, 3)
+
+			Expect(b.Take()).To(BeTrue())
+			Expect(b.Take()).To(BeTrue())
+			Expect(b.Take()).To(BeTrue())
+			Expect(b.Take()).To(BeFalse())
+		})
+
+		It("refills the bucket over time", func() {
+			b := NewBucket(100, 1)
+
+			Expect(b.Take()).To(BeTrue())
+			Expect(b.Take()).To(BeFalse())
+
+			Eventually(b.Take).Should(BeTrue())
+		})
+
+		It("treats a burst of zero as one", func() {
+			b := NewBucket(1, 0)
+
+			Expect(b.Take()).To(BeTrue())
+			Expect(b.Take()).To(BeFalse())
+		})
+	})
+
+	Describe("Reserve", func() {
+		It("returns zero while tokens are available", func() {
+			b := NewBucket(1, 2)
+
+			Expect(b.Reserve()).To(BeZero())
+			Expect(b.Reserve()).To(BeZero())
+		})
+
+		It("returns the time until the token is available", func() {
+			b := NewBucket(10, 1)
+			b.Reserve()
+
+			Expect(b.Reserve()).To(BeNumerically("~", 100*time.Millisecond, 10*time.Millisecond))
+			Expect(b.Reserve()).To(BeNumerically("~", 200*time.Millisecond, 10*time.Millisecond))
+		})
+	})
+})
@@ -0,0 +1,142 @@
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is an append-only log file that is rotated once it reaches a maximum
+// size. When rotated, the file is renamed with the suffix ".1", and any
+// existing backups are renamed with the next higher suffix, up to the maximum
+// number of backups. Older backups are removed.
+type File struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// Open opens the file at path for appending, creating it if necessary. The
+// file is rotated before a write that would take it beyond maxBytes. If
+// maxBytes is zero, the file is never rotated.
+func Open(path string, maxBytes int64, maxBackups int) (*File, error) {
+	f := &File{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write appends p to the file, rotating it first if necessary.
+func (f *File) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Reopen closes and reopens the file, such that writes go to a new file if it
+// has been moved by an external tool.
+func (f *File) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	return f.open()
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+// open opens the file at f.path. f.mutex must be held, unless f has not yet
+// been shared.
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		f.file = nil
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// rotate renames the current file and its backups, then opens a new file.
+// f.mutex must be held.
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.maxBackups > 0 {
+		_ = os.Remove(f.backup(f.maxBackups))
+
+		for i := f.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(f.backup(i), f.backup(i+1))
+		}
+
+		if err := os.Rename(f.path, f.backup(1)); err != nil {
+			f.file = nil
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		f.file = nil
+		return err
+	}
+
+	return f.open()
+}
+
+// backup returns the path of the backup with the given number.
+func (f *File) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
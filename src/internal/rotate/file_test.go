@@ -0,0 +1,107 @@
+package rotate_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/internal/rotate"
+)
+
+var _ = Describe("File", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	read := func(p string) string {
+		buf, err := ioutil.ReadFile(p)
+		Expect(err).ShouldNot(HaveOccurred())
+		return string(buf)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "rotate")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		path = filepath.Join(dir, "audit.log")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("appends to an existing file", func() {
+		err := ioutil.WriteFile(path, []byte("a\n"), 0644)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		f, err := Open(path, 0, 0)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer f.Close()
+
+		_, err = f.Write([]byte("b\n"))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(read(path)).To(Equal("a\nb\n"))
+	})
+
+	It("rotates the file before it exceeds the maximum size", func() {
+		f, err := Open(path, 4, 2)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer f.Close()
+
+		for _, s := range []string{"a\n", "b\n", "c\n", "d\n", "e\n", "f\n", "g\n"} {
+			_, err = f.Write([]byte(s))
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(read(path)).To(Equal("g\n"))
+		Expect(read(path + ".1")).To(Equal("e\nf\n"))
+		Expect(read(path + ".2")).To(Equal("c\nd\n"))
+		Expect(path + ".3").NotTo(BeAnExistingFile())
+	})
+
+	It("truncates the file if there are no backups", func() {
+		f, err := Open(path, 4, 0)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer f.Close()
+
+		for _, s := range []string{"a\n", "b\n", "c\n"} {
+			_, err = f.Write([]byte(s))
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+
+		Expect(read(path)).To(Equal("c\n"))
+		Expect(path + ".1").NotTo(BeAnExistingFile())
+	})
+
+	It("writes to a new file after Reopen", func() {
+		f, err := Open(path, 0, 0)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer f.Close()
+
+		err = os.Rename(path, path+".old")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = f.Reopen()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = f.Write([]byte("a\n"))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(read(path)).To(Equal("a\n"))
+	})
+
+	It("returns an error when writing to a closed file", func() {
+		f, err := Open(path, 0, 0)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(f.Close()).To(Succeed())
+
+		_, err = f.Write([]byte("a\n"))
+		Expect(err).Should(HaveOccurred())
+	})
+})
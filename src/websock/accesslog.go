@@ -0,0 +1,198 @@
+package websock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// AccessLogFormat is the format of the entries written to the access log.
+type AccessLogFormat int
+
+const (
+	// AccessLogCombined is the Apache "combined" log format, followed by the
+	// WebSocket specific fields as key/value pairs.
+	AccessLogCombined AccessLogFormat = iota
+
+	// AccessLogJSON writes each entry as a JSON object, one entry per line.
+	AccessLogJSON
+)
+
+// ParseAccessLogFormat returns the access log format with the given name,
+// which is either "combined" or "json".
+func ParseAccessLogFormat(s string) (AccessLogFormat, error) {
+	switch s {
+	case "combined":
+		return AccessLogCombined, nil
+	case "json":
+		return AccessLogJSON, nil
+	}
+
+	return 0, fmt.Errorf("unknown access log format: %s", s)
+}
+
+// AccessLogEntry is a record of a single upgrade request. Entries for accepted
+// upgrades are written when the connection is closed; rejected upgrades are
+// written immediately.
+type AccessLogEntry struct {
+	Time        time.Time `json:"time"`
+	Connection  uint64    `json:"connection"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ClientIP    string    `json:"client_ip"`
+	Request     string    `json:"request"`
+	Origin      string    `json:"origin,omitempty"`
+	Referer     string    `json:"referer,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Protocol    string    `json:"protocol,omitempty"`
+	Status      int       `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  float64   `json:"duration_ms"`
+	CloseCode   int       `json:"close_code,omitempty"`
+	CloseReason string    `json:"close_reason,omitempty"`
+	MessagesIn  uint64    `json:"messages_in"`
+	MessagesOut uint64    `json:"messages_out"`
+	BytesIn     uint64    `json:"bytes_in"`
+	BytesOut    uint64    `json:"bytes_out"`
+	Sessions    uint64    `json:"sessions"`
+}
+
+// accessLog writes access log entries to a writer.
+type accessLog struct {
+	format AccessLogFormat
+
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// redactedParams are the query parameters of an upgrade request whose values
+// are credentials, such as the resume tokens of the native protocol. They are
+// not written to the access log.
+var redactedParams = map[string]bool{
+	"resume": true,
+}
+
+// redactedValue replaces the values of redacted query parameters.
+const redactedValue = "[REDACTED]"
+
+// newEntry returns an access log entry for the upgrade request r, which has
+// just been received.
+func newEntry(r *http.Request, client string, info *connectionInfo) *AccessLogEntry {
+	return &AccessLogEntry{
+		Time:       time.Now().UTC(),
+		Connection: info.id,
+		RemoteAddr: r.RemoteAddr,
+		ClientIP:   client,
+		Request:    fmt.Sprintf("%s %s %s", r.Method, requestURI(r.URL), r.Proto),
+		Origin:     r.Header.Get("Origin"),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+	}
+}
+
+// requestURI returns the request URI to write to the access log for a request
+// to u, with the values of redacted query parameters replaced.
+func requestURI(u *url.URL) string {
+	uri := u.EscapedPath()
+	if u.RawQuery == "" {
+		return uri
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	for i, p := range params {
+		k := p
+		if n := strings.IndexByte(p, '='); n != -1 {
+			k = p[:n]
+		}
+
+		if key, err := url.QueryUnescape(k); err != nil || redactedParams[key] {
+			params[i] = k + "=" + redactedValue
+		}
+	}
+
+	return uri + "?" + strings.Join(params, "&")
+}
+
+// closed populates e with the details of a connection that was closed by a
+// handler that returned err.
+func (e *AccessLogEntry) closed(err error) {
+	switch err := err.(type) {
+	case nil:
+		e.CloseCode = websocket.CloseNormalClosure
+	case *CloseError:
+		e.CloseCode, e.CloseReason = err.Code, err.Reason
+	case *websocket.CloseError:
+		e.CloseCode, e.CloseReason = err.Code, err.Text
+	default:
+		e.CloseCode, e.Error = websocket.CloseAbnormalClosure, err.Error()
+	}
+}
+
+// write writes e to the log, setting its duration to the time elapsed since
+// the request was received. It is a no-op if l is nil. Write errors are
+// ignored.
+func (l *accessLog) write(e *AccessLogEntry) {
+	if l == nil {
+		return
+	}
+
+	e.DurationMS = time.Since(e.Time).Seconds() * 1000
+
+	var buf []byte
+	if l.format == AccessLogJSON {
+		var err error
+		if buf, err = json.Marshal(e); err != nil {
+			return
+		}
+	} else {
+		buf = formatCombined(e)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, _ = l.w.Write(append(buf, '\n'))
+}
+
+// formatCombined returns e in the Apache "combined" log format, followed by the
+// WebSocket specific fields. The byte count is the number of bytes written to
+// the client.
+func formatCombined(e *AccessLogEntry) []byte {
+	return []byte(fmt.Sprintf(
+		`%s - - [%s] %s %d %d %s %s connection=%d origin=%s protocol=%s duration_ms=%.3f close_code=%d close_reason=%s messages_in=%d messages_out=%d bytes_in=%d sessions=%d error=%s`,
+		e.ClientIP,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quote(e.Request),
+		e.Status,
+		e.BytesOut,
+		quote(e.Referer),
+		quote(e.UserAgent),
+		e.Connection,
+		quote(e.Origin),
+		quote(e.Protocol),
+		e.DurationMS,
+		e.CloseCode,
+		quote(e.CloseReason),
+		e.MessagesIn,
+		e.MessagesOut,
+		e.BytesIn,
+		e.Sessions,
+		quote(e.Error),
+	))
+}
+
+// quote returns s as a quoted string, or "-" if s is empty.
+func quote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+
+	return strconv.Quote(s)
+}
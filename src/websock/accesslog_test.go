@@ -0,0 +1,19 @@
+package websock_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/websock"
+)
+
+var _ = Describe("ParseAccessLogFormat", func() {
+	It("parses the format names", func() {
+		Expect(ParseAccessLogFormat("combined")).To(Equal(AccessLogCombined))
+		Expect(ParseAccessLogFormat("json")).To(Equal(AccessLogJSON))
+	})
+
+	It("returns an error if the format is unknown", func() {
+		_, err := ParseAccessLogFormat("xml")
+		Expect(err).Should(HaveOccurred())
+	})
+})
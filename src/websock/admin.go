@@ -0,0 +1,114 @@
+package websock
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rinq/httpd/src/internal/statuspage"
+)
+
+// adminHandler is an http.Handler that exposes a Registry as a JSON API.
+type adminHandler struct {
+	registry *Registry
+}
+
+// NewAdminHandler returns an HTTP handler that serves the admin API for the
+// connections in r. The API consists of the following endpoints, relative to
+// the handler's path:
+//
+//	GET    /connections                         list live connections
+//	DELETE /connections/<id>?reason=...         close a connection
+//	GET    /connections/<id>/sessions           list a connection's sessions
+//	DELETE /connections/<id>/sessions/<session>?reason=...
+//	                                            destroy a session
+//
+// The handler should be mounted at the root of a separate, private listener.
+func NewAdminHandler(r *Registry) http.Handler {
+	return &adminHandler{r}
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "connections" {
+		statuspage.Write(w, r, http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		h.serveConnections(w, r)
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		statuspage.Write(w, r, http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 2:
+		h.serveConnection(w, r, id)
+	case len(parts) == 3 && parts[2] == "sessions":
+		h.serveSessions(w, r, id)
+	case len(parts) == 4 && parts[2] == "sessions":
+		h.serveSession(w, r, id, parts[3])
+	default:
+		statuspage.Write(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *adminHandler) serveConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		statuspage.Write(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, h.registry.Connections())
+}
+
+func (h *adminHandler) serveConnection(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodDelete {
+		statuspage.Write(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.registry.CloseConnection(id, r.URL.Query().Get("reason")) {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		statuspage.Write(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *adminHandler) serveSessions(w http.ResponseWriter, r *http.Request, id uint64) {
+	if r.Method != http.MethodGet {
+		statuspage.Write(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if sessions, ok := h.registry.Sessions(id); ok {
+		writeJSON(w, sessions)
+	} else {
+		statuspage.Write(w, r, http.StatusNotFound)
+	}
+}
+
+func (h *adminHandler) serveSession(w http.ResponseWriter, r *http.Request, id uint64, session string) {
+	if r.Method != http.MethodDelete {
+		statuspage.Write(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.registry.DestroySession(id, session, r.URL.Query().Get("reason")) {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		statuspage.Write(w, r, http.StatusNotFound)
+	}
+}
+
+// writeJSON writes v to w as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,172 @@
+package websock_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+)
+
+// inspector is a SessionInspector with a single session.
+type inspector struct {
+	destroyed chan string
+}
+
+func (i *inspector) Sessions() []SessionInfo {
+	return []SessionInfo{{ID: "1-2.3", Namespaces: []string{"ns"}}}
+}
+
+func (i *inspector) DestroySession(id, reason string) bool {
+	if id != "1-2.3" {
+		return false
+	}
+
+	i.destroyed <- reason
+	return true
+}
+
+var _ = Describe("NewAdminHandler", func() {
+	var (
+		handler  *mock.Handler
+		registry *Registry
+		insp     *inspector
+		server   *httptest.Server
+		admin    *httptest.Server
+		con      *websocket.Conn
+		id       uint64
+	)
+
+	BeforeEach(func() {
+		insp = &inspector{destroyed: make(chan string, 1)}
+
+		handler = &mock.Handler{}
+		handler.Impl.Protocol = "proto-a"
+		handler.Impl.Handle = func(c Connection, r *http.Request) error {
+			Inspect(r.Context(), insp)
+
+			for {
+				if _, err := c.NextReader(); err != nil {
+					return err
+				}
+			}
+		}
+
+		origins, err := ParseOriginPatterns("*")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		registry = NewRegistry()
+		server = httptest.NewServer(
+			NewHTTPHandlerWithOptions(
+				origins,
+				time.Second,
+				10,
+				nil,
+				[]Handler{handler},
+				Connections(registry),
+			),
+		)
+		admin = httptest.NewServer(NewAdminHandler(registry))
+
+		url := strings.Replace(server.URL, "http://", "ws://", 1)
+		d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+		con, _, err = d.Dial(url, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(registry.Connections).Should(HaveLen(1))
+		id = registry.Connections()[0].ID
+	})
+
+	AfterEach(func() {
+		con.Close()
+		admin.Close()
+		server.Close()
+	})
+
+	request := func(method, path string) *http.Response {
+		req, err := http.NewRequest(method, admin.URL+path, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return res
+	}
+
+	It("lists live connections", func() {
+		res := request("GET", "/connections")
+		defer res.Body.Close()
+
+		var conns []ConnectionSummary
+		err := json.NewDecoder(res.Body).Decode(&conns)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(conns).To(HaveLen(1))
+		Expect(conns[0].ID).To(Equal(id))
+		Expect(conns[0].ClientIP).To(Equal("127.0.0.1"))
+		Expect(conns[0].Protocol).To(Equal("proto-a"))
+		Expect(conns[0].Sessions).To(Equal(1))
+	})
+
+	It("lists the sessions of a connection", func() {
+		res := request("GET", fmt.Sprintf("/connections/%d/sessions", id))
+		defer res.Body.Close()
+
+		var sessions []SessionInfo
+		err := json.NewDecoder(res.Body).Decode(&sessions)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(sessions).To(Equal(insp.Sessions()))
+	})
+
+	It("closes connections with the given reason", func() {
+		res := request("DELETE", fmt.Sprintf("/connections/%d?reason=bye", id))
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+
+		_, _, err := con.ReadMessage()
+		Expect(err).To(Equal(&websocket.CloseError{
+			Code: CloseKicked,
+			Text: "bye",
+		}))
+
+		Eventually(registry.Connections).Should(BeEmpty())
+	})
+
+	It("destroys sessions with the given reason", func() {
+		res := request("DELETE", fmt.Sprintf("/connections/%d/sessions/1-2.3?reason=bye", id))
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+
+		Expect(insp.destroyed).To(Receive(Equal("bye")))
+	})
+
+	It("closes all connections when draining", func() {
+		registry.CloseAll("shutting down")
+
+		_, _, err := con.ReadMessage()
+		Expect(err).To(Equal(&websocket.CloseError{
+			Code: CloseGoingAway,
+			Text: "shutting down",
+		}))
+
+		Eventually(registry.Len).Should(BeZero())
+	})
+
+	It("responds with 404 for unknown connections and sessions", func() {
+		res := request("DELETE", "/connections/0")
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+		res = request("DELETE", fmt.Sprintf("/connections/%d/sessions/unknown", id))
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
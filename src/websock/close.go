@@ -0,0 +1,26 @@
+package websock
+
+import (
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// ClosePolicyViolation is the WebSocket close code used when a client has
+// violated the server's policy, such as by exceeding a rate limit.
+const ClosePolicyViolation = websocket.ClosePolicyViolation
+
+// CloseGoingAway is the WebSocket close code used when the server is shutting
+// down.
+const CloseGoingAway = websocket.CloseGoingAway
+
+// CloseError is an error that can be returned by Handler.Handle() to close the
+// connection with a specific WebSocket close code and reason.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("connection closed (%d): %s", e.Code, e.Reason)
+}
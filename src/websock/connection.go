@@ -1,8 +1,11 @@
 package websock
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,93 +13,372 @@ import (
 
 // Connection is an interface for performing IO on a WebSocket connection.
 type Connection interface {
+	// NextReader returns a reader for the next incoming message.
 	NextReader() (io.Reader, error)
-	NextWriter() (io.WriteCloser, error)
+
+	// NextWriter returns a writer for the next outgoing message. The message is
+	// queued for delivery when the writer is closed.
+	NextWriter(Priority) (io.WriteCloser, error)
+}
+
+// Idler is implemented by connections that report when their queued messages
+// have been written, allowing producers to hold back messages that may be
+// superseded until the client can accept them.
+type Idler interface {
+	// Idle returns a channel that receives a value each time a message is
+	// written and no other messages, of any priority, remain queued. Only one
+	// goroutine should receive from the channel.
+	Idle() <-chan struct{}
+}
+
+// Drainer is implemented by connections that can return the outgoing messages
+// that have not been written, allowing them to be delivered via another
+// connection.
+type Drainer interface {
+	// Drain stops writing outgoing messages, and returns those that are still
+	// queued, in the order they would have been written. Messages can not be
+	// written to the connection once it has been drained.
+	Drain() [][]byte
+}
+
+// Priority is the delivery priority of an outgoing message. Queued messages
+// with a higher priority are written before those with a lower priority.
+type Priority int
+
+const (
+	// PriorityNormal is the priority of messages that are not in response to
+	// a client request, such as notifications.
+	PriorityNormal Priority = iota
+
+	// PriorityHigh is the priority of messages that a client is waiting on,
+	// such as command responses.
+	PriorityHigh
+)
+
+var (
+	// errConnClosed is returned when writing to a connection that has been
+	// closed.
+	errConnClosed = errors.New("connection is closed")
+
+	// errSlowClient is returned when a client does not keep up with the
+	// messages sent to it.
+	errSlowClient = errors.New("outbound queue is full, client is too slow")
+)
+
+// connConfig holds the configuration of a connection's outbound queue.
+type connConfig struct {
+	pingInterval      time.Duration
+	writeTimeout      time.Duration
+	queueSize         int
+	slowClientTimeout time.Duration
 }
 
+// connection is a Connection that writes outgoing messages from a single
+// goroutine, such that producers are never blocked by the client unless its
+// queue is full.
 type connection struct {
-	socket       *websocket.Conn
-	pingInterval time.Duration
-	closeReply   func(int, string) error
+	// counters are accessed atomically and must remain 64-bit aligned
+	messagesIn, messagesOut uint64
+	bytesIn, bytesOut       uint64
+
+	socket *websocket.Conn
+	config connConfig
 
-	mutex sync.Mutex // write mutex
-	done  chan struct{}
+	high   chan []byte
+	normal chan []byte
+	idle   chan struct{} // receives a value when both queues become empty
+
+	once sync.Once
+	stop chan struct{} // closed when the writer should stop
+	done chan struct{} // closed when the writer has stopped
+
+	mutex    sync.Mutex
+	kicked   *CloseError // the reason the connection was kicked, if any
+	draining bool        // true if queued messages are to be drained, not written
 }
 
-func newConn(socket *websocket.Conn, pingInterval time.Duration) *connection {
+func newConn(socket *websocket.Conn, config connConfig) *connection {
 	c := &connection{
-		socket:       socket,
-		pingInterval: pingInterval,
-		done:         make(chan struct{}),
+		socket: socket,
+		config: config,
+		high:   make(chan []byte, config.queueSize),
+		normal: make(chan []byte, config.queueSize),
+		idle:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
 	}
 
+	// the queue starts empty
+	c.idle <- struct{}{}
+
 	socket.SetPongHandler(c.pong)
 
-	go c.pingLoop()
+	go c.writeLoop()
 
 	return c
 }
 
 func (c *connection) NextReader() (io.Reader, error) {
 	_, r, err := c.socket.NextReader()
+	if err != nil {
+		return nil, err
+	}
 
-	if _, ok := err.(*websocket.CloseError); ok {
-		c.mutex.Lock()
-		defer c.mutex.Unlock()
+	atomic.AddUint64(&c.messagesIn, 1)
 
-		select {
-		case <-c.done:
-		default:
-			close(c.done)
-		}
+	return &countingReader{r, &c.bytesIn}, nil
+}
+
+func (c *connection) Idle() <-chan struct{} {
+	return c.idle
+}
+
+func (c *connection) NextWriter(p Priority) (io.WriteCloser, error) {
+	select {
+	case <-c.stop:
+		return nil, errConnClosed
+	default:
+		return &queuedWriter{conn: c, priority: p}, nil
+	}
+}
+
+// enqueue adds a message to the outbound queue. If the queue is full it waits
+// for up to the slow client timeout before disconnecting the client.
+func (c *connection) enqueue(p Priority, buf []byte) error {
+	queue := c.normal
+	if p == PriorityHigh {
+		queue = c.high
+	}
+
+	select {
+	case queue <- buf:
+		return nil
+	case <-c.stop:
+		return errConnClosed
+	default:
 	}
 
-	return r, err
+	timer := time.NewTimer(c.config.slowClientTimeout)
+	defer timer.Stop()
+
+	select {
+	case queue <- buf:
+		return nil
+	case <-c.stop:
+		return errConnClosed
+	case <-timer.C:
+		c.abort()
+		return errSlowClient
+	}
 }
 
-func (c *connection) NextWriter() (io.WriteCloser, error) {
+func (c *connection) Drain() [][]byte {
 	c.mutex.Lock()
+	c.draining = true
+	c.mutex.Unlock()
 
-	w, err := c.socket.NextWriter(websocket.BinaryMessage)
-	if err != nil {
-		c.mutex.Unlock()
-		return nil, err
+	c.once.Do(func() { close(c.stop) })
+	<-c.done
+
+	var queued [][]byte
+
+	for _, queue := range []chan []byte{c.high, c.normal} {
+		for len(queue) != 0 {
+			queued = append(queued, <-queue)
+		}
+	}
+
+	return queued
+}
+
+// close stops the writer once the messages that are already queued have been
+// written, or the write timeout elapses.
+func (c *connection) close() {
+	c.once.Do(func() { close(c.stop) })
+	<-c.done
+}
+
+// abort stops the writer and closes the underlying socket without writing any
+// further messages.
+func (c *connection) abort() {
+	c.once.Do(func() { close(c.stop) })
+	_ = c.socket.Close()
+}
+
+// kick writes a close message with the code and reason from e, then closes the
+// underlying socket without writing any further messages.
+func (c *connection) kick(e *CloseError) {
+	c.mutex.Lock()
+	if c.kicked == nil {
+		c.kicked = e
 	}
+	c.mutex.Unlock()
+
+	_ = c.socket.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(e.Code, e.Reason),
+		time.Now().Add(c.config.writeTimeout),
+	)
+
+	c.abort()
+}
+
+// kickedWith returns the close error passed to kick(), or nil if the
+// connection has not been kicked.
+func (c *connection) kickedWith() *CloseError {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.kicked
+}
+
+// isDraining returns true if Drain() has been called.
+func (c *connection) isDraining() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.draining
+}
 
-	return writeCloser{w, &c.mutex}, nil
+// queueDepth returns the number of outgoing messages that are queued.
+func (c *connection) queueDepth() int {
+	return len(c.high) + len(c.normal)
 }
 
-func (c *connection) pingLoop() {
-	ping := time.NewTicker(c.pingInterval)
+func (c *connection) writeLoop() {
+	defer close(c.done)
+
+	ping := time.NewTicker(c.config.pingInterval)
 	defer ping.Stop()
 
 	for {
+		var err error
+
+		// always prefer high priority messages, if any are queued
 		select {
-		case <-ping.C:
-			c.ping()
-		case <-c.done:
+		case buf := <-c.high:
+			err = c.writeQueued(buf)
+		default:
+			select {
+			case buf := <-c.high:
+				err = c.writeQueued(buf)
+			case buf := <-c.normal:
+				err = c.writeQueued(buf)
+			case <-ping.C:
+				err = c.socket.WriteControl(
+					websocket.PingMessage,
+					nil,
+					time.Now().Add(c.config.writeTimeout),
+				)
+			case <-c.stop:
+				if !c.isDraining() {
+					c.flush()
+				}
+				return
+			}
+		}
+
+		if err != nil {
+			c.abort()
 			return
 		}
 	}
 }
 
-func (c *connection) ping() {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	_ = c.socket.WriteMessage(websocket.PingMessage, nil)
+// writeQueued writes buf, which was taken from one of the queues, and signals
+// that the connection is idle if both queues are now empty.
+//
+// Normal priority messages may be sent with high priority, such as when they
+// are batched with a response, so writing from either queue can leave the
+// connection idle.
+func (c *connection) writeQueued(buf []byte) error {
+	if err := c.write(buf); err != nil {
+		return err
+	}
+
+	if c.queueDepth() == 0 {
+		c.signalIdle()
+	}
+
+	return nil
+}
+
+// signalIdle notifies the receiver of c.idle that the outbound queues are
+// empty, unless a notification is already pending.
+func (c *connection) signalIdle() {
+	select {
+	case c.idle <- struct{}{}:
+	default:
+	}
+}
+
+// write writes buf to the socket as a single binary message.
+func (c *connection) write(buf []byte) error {
+	_ = c.socket.SetWriteDeadline(time.Now().Add(c.config.writeTimeout))
+	return c.writeMessage(buf)
+}
+
+// flush writes any queued messages, giving up after the write timeout.
+func (c *connection) flush() {
+	_ = c.socket.SetWriteDeadline(time.Now().Add(c.config.writeTimeout))
+
+	for _, queue := range []chan []byte{c.high, c.normal} {
+		for len(queue) != 0 {
+			if c.writeMessage(<-queue) != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeMessage writes buf to the socket as a single binary message, without
+// setting a deadline.
+func (c *connection) writeMessage(buf []byte) error {
+	if err := c.socket.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+		return err
+	}
+
+	atomic.AddUint64(&c.messagesOut, 1)
+	atomic.AddUint64(&c.bytesOut, uint64(len(buf)))
+
+	return nil
+}
+
+// stats returns the number of messages and bytes read from and written to the
+// socket.
+func (c *connection) stats() (messagesIn, messagesOut, bytesIn, bytesOut uint64) {
+	return atomic.LoadUint64(&c.messagesIn),
+		atomic.LoadUint64(&c.messagesOut),
+		atomic.LoadUint64(&c.bytesIn),
+		atomic.LoadUint64(&c.bytesOut)
 }
 
 func (c *connection) pong(string) error {
-	deadline := time.Now().Add(c.pingInterval * 2)
+	deadline := time.Now().Add(c.config.pingInterval * 2)
 	return c.socket.SetReadDeadline(deadline)
 }
 
-type writeCloser struct {
-	io.WriteCloser
-	mutex *sync.Mutex
+// queuedWriter buffers an outgoing message until it is closed, at which point
+// the message is added to the connection's outbound queue.
+type queuedWriter struct {
+	bytes.Buffer
+	conn     *connection
+	priority Priority
+}
+
+func (w *queuedWriter) Close() error {
+	return w.conn.enqueue(w.priority, w.Bytes())
+}
+
+// countingReader is an io.Reader that adds the number of bytes read to a
+// counter.
+type countingReader struct {
+	r io.Reader
+	n *uint64
 }
 
-func (w writeCloser) Close() error {
-	defer w.mutex.Unlock()
-	return w.WriteCloser.Close()
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	atomic.AddUint64(r.n, uint64(n))
+	return n, err
 }
@@ -0,0 +1,182 @@
+package websock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("connection", func() {
+	var (
+		server  *httptest.Server
+		sockets chan *websocket.Conn
+		client  *websocket.Conn
+		config  connConfig
+	)
+
+	BeforeEach(func() {
+		config = connConfig{
+			pingInterval:      time.Minute,
+			writeTimeout:      time.Second,
+			queueSize:         4,
+			slowClientTimeout: 50 * time.Millisecond,
+		}
+
+		sockets = make(chan *websocket.Conn, 1)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := websocket.Upgrader{}
+			if s, err := u.Upgrade(w, r, nil); err == nil {
+				sockets <- s
+			}
+		}))
+
+		url := strings.Replace(server.URL, "http://", "ws://", 1)
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		client = c
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+
+	write := func(c *connection, p Priority, s string) error {
+		w, err := c.NextWriter(p)
+		if err != nil {
+			return err
+		}
+
+		_, _ = w.Write([]byte(s))
+		return w.Close()
+	}
+
+	read := func() string {
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		_, buf, err := client.ReadMessage()
+		Expect(err).ShouldNot(HaveOccurred())
+		return string(buf)
+	}
+
+	It("writes queued messages in order", func() {
+		subject := newConn(<-sockets, config)
+		defer subject.close()
+
+		Expect(write(subject, PriorityNormal, "a")).To(Succeed())
+		Expect(write(subject, PriorityNormal, "b")).To(Succeed())
+
+		Expect(read()).To(Equal("a"))
+		Expect(read()).To(Equal("b"))
+	})
+
+	It("writes high priority messages before normal priority messages", func() {
+		subject := &connection{
+			socket: <-sockets,
+			config: config,
+			high:   make(chan []byte, config.queueSize),
+			normal: make(chan []byte, config.queueSize),
+			stop:   make(chan struct{}),
+			done:   make(chan struct{}),
+		}
+
+		Expect(write(subject, PriorityNormal, "notification")).To(Succeed())
+		Expect(write(subject, PriorityHigh, "response")).To(Succeed())
+
+		go subject.writeLoop()
+		defer subject.close()
+
+		Expect(read()).To(Equal("response"))
+		Expect(read()).To(Equal("notification"))
+	})
+
+	It("writes queued messages before closing", func() {
+		subject := newConn(<-sockets, config)
+
+		for i := 0; i < config.queueSize; i++ {
+			Expect(write(subject, PriorityNormal, "x")).To(Succeed())
+		}
+
+		subject.close()
+
+		for i := 0; i < config.queueSize; i++ {
+			Expect(read()).To(Equal("x"))
+		}
+	})
+
+	It("returns the queued messages when drained", func() {
+		// the writer is not started, so that the messages remain queued
+		done := make(chan struct{})
+		close(done)
+
+		subject := &connection{
+			socket: <-sockets,
+			config: config,
+			high:   make(chan []byte, config.queueSize),
+			normal: make(chan []byte, config.queueSize),
+			stop:   make(chan struct{}),
+			done:   done,
+		}
+
+		Expect(write(subject, PriorityNormal, "notification")).To(Succeed())
+		Expect(write(subject, PriorityHigh, "response")).To(Succeed())
+
+		Expect(subject.Drain()).To(Equal([][]byte{
+			[]byte("response"),
+			[]byte("notification"),
+		}))
+		Expect(write(subject, PriorityHigh, "x")).To(Equal(errConnClosed))
+	})
+
+	It("returns an error when writing to a closed connection", func() {
+		subject := newConn(<-sockets, config)
+		subject.close()
+
+		Expect(write(subject, PriorityHigh, "x")).To(Equal(errConnClosed))
+	})
+
+	It("signals when the queue becomes empty", func() {
+		subject := newConn(<-sockets, config)
+		defer subject.close()
+
+		Expect(subject.Idle()).To(Receive())
+
+		Expect(write(subject, PriorityNormal, "a")).To(Succeed())
+		Expect(read()).To(Equal("a"))
+
+		Eventually(subject.Idle()).Should(Receive())
+		Consistently(subject.Idle()).ShouldNot(Receive())
+	})
+
+	It("signals when the queue becomes empty after writing a high priority message", func() {
+		subject := newConn(<-sockets, config)
+		defer subject.close()
+
+		Expect(subject.Idle()).To(Receive())
+
+		Expect(write(subject, PriorityHigh, "a")).To(Succeed())
+		Expect(read()).To(Equal("a"))
+
+		Eventually(subject.Idle()).Should(Receive())
+	})
+
+	It("disconnects clients whose queue remains full", func() {
+		subject := newConn(<-sockets, config)
+		defer subject.close()
+
+		// the client never reads, so large messages eventually fill the TCP
+		// buffers and then the outbound queue
+		payload := strings.Repeat("x", 1<<20)
+
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = write(subject, PriorityNormal, payload)
+		}
+
+		Expect(err).To(Or(Equal(errSlowClient), Equal(errConnClosed)))
+	})
+})
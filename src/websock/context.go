@@ -0,0 +1,101 @@
+package websock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type connectionKey struct{}
+
+// connectionInfo holds information about a connection that is shared with
+// its handler via the request context.
+type connectionInfo struct {
+	sessions uint64 // accessed atomically, must remain 64-bit aligned
+	id       uint64
+
+	// the remaining fields are set before the connection is registered, and
+	// are not modified thereafter
+	clientIP    string
+	origin      string
+	protocol    string
+	established time.Time
+	conn        *connection
+
+	mutex     sync.Mutex
+	inspector SessionInspector
+}
+
+// connectionCount is used to give each connection a unique ID.
+var connectionCount uint64
+
+// newConnectionContext returns a new context that carries a newly allocated
+// connection ID.
+func newConnectionContext(ctx context.Context) (context.Context, *connectionInfo) {
+	info := &connectionInfo{
+		id: atomic.AddUint64(&connectionCount, 1),
+	}
+
+	return context.WithValue(ctx, connectionKey{}, info), info
+}
+
+// ConnectionID returns the ID of the connection that was upgraded from the
+// request with context ctx, if any. IDs are unique within the process.
+func ConnectionID(ctx context.Context) (uint64, bool) {
+	if info, ok := ctx.Value(connectionKey{}).(*connectionInfo); ok {
+		return info.id, true
+	}
+
+	return 0, false
+}
+
+// SessionCreated records that the handler has created a session on the
+// connection that was upgraded from the request with context ctx. The number of
+// sessions is included in the access log.
+func SessionCreated(ctx context.Context) {
+	if info, ok := ctx.Value(connectionKey{}).(*connectionInfo); ok {
+		atomic.AddUint64(&info.sessions, 1)
+	}
+}
+
+// sessionCount returns the number of sessions created on the connection.
+func (info *connectionInfo) sessionCount() uint64 {
+	return atomic.LoadUint64(&info.sessions)
+}
+
+// SessionInspector is implemented by handlers that expose the sessions on a
+// connection to the admin API.
+type SessionInspector interface {
+	// Sessions returns information about each of the connection's sessions.
+	Sessions() []SessionInfo
+
+	// DestroySession destroys the session with the given ID, notifying the
+	// client of the reason. It returns false if there is no such session.
+	DestroySession(id, reason string) bool
+}
+
+// SessionInfo describes a session created by a handler.
+type SessionInfo struct {
+	ID         string   `json:"id"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// Inspect registers i as the inspector of the sessions on the connection that
+// was upgraded from the request with context ctx, replacing any existing
+// inspector.
+func Inspect(ctx context.Context, i SessionInspector) {
+	if info, ok := ctx.Value(connectionKey{}).(*connectionInfo); ok {
+		info.mutex.Lock()
+		info.inspector = i
+		info.mutex.Unlock()
+	}
+}
+
+// sessionInspector returns the connection's session inspector, if any.
+func (info *connectionInfo) sessionInspector() (SessionInspector, bool) {
+	info.mutex.Lock()
+	defer info.mutex.Unlock()
+
+	return info.inspector, info.inspector != nil
+}
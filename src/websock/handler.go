@@ -1,13 +1,15 @@
 package websock
 
 import (
-	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/alecthomas/units"
 	"github.com/gorilla/websocket"
+	"github.com/rinq/httpd/src/internal/clientaddr"
 	"github.com/rinq/httpd/src/internal/statuspage"
 )
 
@@ -25,30 +27,92 @@ type Handler interface {
 // httpHandler is an http.Handler that negotiates a WebSocket upgrade and
 // dispatches handling to the appropriate sub-protocol.
 type httpHandler struct {
-	pingInterval       time.Duration
+	conn               connConfig
 	maxIncomingMsgSize units.MetricBytes
 	logger             *log.Logger
 	handlers           map[string]Handler
 	upgrader           websocket.Upgrader
+	resolver           *clientaddr.Resolver
+	trustedProxies     []*net.IPNet
+	forwardedHeader    clientaddr.Header
+	denyNullOrigin     bool
+	limiter            connLimiter
+	access             *accessLog
+	registry           *Registry
 }
 
+// limitRetryAfter is the delay suggested to clients whose upgrade request is
+// rejected because a connection limit has been reached.
+const limitRetryAfter = 10 * time.Second
+
+const (
+	// DefaultWriteTimeout is the default maximum time allowed to write a single
+	// message to a client.
+	DefaultWriteTimeout = 10 * time.Second
+
+	// DefaultOutboundQueueSize is the default number of outgoing messages of
+	// each priority that may be queued for a single client.
+	DefaultOutboundQueueSize = 64
+
+	// DefaultSlowClientTimeout is the default time that a client's outbound
+	// queue may remain full before the client is disconnected.
+	DefaultSlowClientTimeout = 5 * time.Second
+)
+
 // NewHTTPHandler returns an HTTP handler for a set of WebSocket handlers.
+//
+// Upgrade requests are accepted if their Origin header matches any of the
+// given patterns. If no patterns are given, the origin must match the Host
+// header of the request.
 func NewHTTPHandler(
-	originPattern string,
+	origins []OriginPattern,
 	pingInterval time.Duration,
 	maxIncomingMsgSize units.MetricBytes,
 	logger *log.Logger,
 	handlers ...Handler,
+) http.Handler {
+	return NewHTTPHandlerWithOptions(
+		origins,
+		pingInterval,
+		maxIncomingMsgSize,
+		logger,
+		handlers,
+	)
+}
+
+// NewHTTPHandlerWithOptions returns an HTTP handler for a set of WebSocket
+// handlers, configured by the given options.
+//
+// Upgrade requests are accepted as per NewHTTPHandler().
+func NewHTTPHandlerWithOptions(
+	origins []OriginPattern,
+	pingInterval time.Duration,
+	maxIncomingMsgSize units.MetricBytes,
+	logger *log.Logger,
+	handlers []Handler,
+	options ...Option,
 ) http.Handler {
 	h := &httpHandler{
 		maxIncomingMsgSize: maxIncomingMsgSize,
-		pingInterval:       pingInterval,
-		logger:             logger,
-		handlers:           map[string]Handler{},
+		conn: connConfig{
+			pingInterval:      pingInterval,
+			writeTimeout:      DefaultWriteTimeout,
+			queueSize:         DefaultOutboundQueueSize,
+			slowClientTimeout: DefaultSlowClientTimeout,
+		},
+		logger:   logger,
+		handlers: map[string]Handler{},
+		limiter:  connLimiter{counts: &connCounts{}},
 	}
 
+	for _, opt := range options {
+		opt.modify(h)
+	}
+
+	h.resolver = clientaddr.NewResolver(h.forwardedHeader, h.trustedProxies...)
+
 	h.upgrader = websocket.Upgrader{
-		CheckOrigin:       newOriginChecker(originPattern),
+		CheckOrigin:       newOriginChecker(origins, h.denyNullOrigin, logger),
 		EnableCompression: true,
 		Error: func(w http.ResponseWriter, r *http.Request, c int, _ error) {
 			statuspage.Write(w, r, c)
@@ -67,14 +131,50 @@ func NewHTTPHandler(
 }
 
 func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	socket, err := h.upgrader.Upgrade(w, r, nil)
+	client := h.resolver.Resolve(r)
+	ctx, info := newConnectionContext(
+		clientaddr.NewContext(r.Context(), client),
+	)
+	r = r.WithContext(ctx)
+
+	entry := newEntry(r, client.IP, info)
+
+	if code, ok := h.limiter.acquire(client.IP); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(limitRetryAfter/time.Second)))
+		statuspage.Write(w, r, code)
+		entry.Status, entry.Error = code, "connection limit reached"
+		h.access.write(entry)
+		h.logf("rejected WebSocket upgrade from %s: connection limit reached", client.IP)
+		return
+	}
+	defer h.limiter.release(client.IP)
+
+	// copy the upgrader so that the status of a rejected upgrade can be
+	// recorded in the access log
+	upgrader := h.upgrader
+	upgrader.Error = func(w http.ResponseWriter, r *http.Request, c int, _ error) {
+		entry.Status = c
+		statuspage.Write(w, r, c)
+	}
+
+	socket, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		fmt.Println("upgrade error:", err) // TODO: log
+		entry.Error = err.Error()
+		h.access.write(entry)
+
+		// rejected origins are logged by the origin checker
+		if entry.Status != http.StatusForbidden {
+			h.logf("unable to upgrade connection from %s: %s", client.IP, err)
+		}
+
 		return
 	}
 	defer socket.Close()
 
-	wsh, ok := h.handlers[socket.Subprotocol()]
+	entry.Status = http.StatusSwitchingProtocols
+	entry.Protocol = socket.Subprotocol()
+
+	wsh, ok := h.handlers[entry.Protocol]
 	if !ok {
 		// Write a close message for those clients that don't automatically
 		// disconnect after a failed sub-protocol negotiation.
@@ -86,17 +186,53 @@ func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			),
 			time.Now().Add(time.Second),
 		)
-		fmt.Println("unsupported sub-protocol") // TODO: log, pull from headers
+		entry.closed(&CloseError{
+			Code:   websocket.CloseProtocolError,
+			Reason: "unsupported sub-protocol",
+		})
+		h.access.write(entry)
+		h.logf("closed connection %d from %s: unsupported sub-protocol", info.id, client.IP)
 		return
 	}
 
 	socket.SetReadLimit(int64(h.maxIncomingMsgSize))
 
-	conn := newConn(socket, h.pingInterval)
+	conn := newConn(socket, h.conn)
+
+	info.clientIP = client.IP
+	info.origin = entry.Origin
+	info.protocol = entry.Protocol
+	info.established = time.Now()
+	info.conn = conn
 
+	h.registry.add(info)
 	err = wsh.Handle(conn, r)
-	if err != nil {
-		fmt.Println("handler error:", err) // TODO: log
-		return
+	h.registry.remove(info)
+	conn.close()
+
+	if e := conn.kickedWith(); e != nil {
+		err = e
+	} else if e, ok := err.(*CloseError); ok {
+		_ = socket.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(e.Code, e.Reason),
+			time.Now().Add(time.Second),
+		)
+	}
+
+	entry.closed(err)
+	entry.MessagesIn, entry.MessagesOut, entry.BytesIn, entry.BytesOut = conn.stats()
+	entry.Sessions = info.sessionCount()
+	h.access.write(entry)
+
+	if entry.Error != "" {
+		h.logf("connection %d from %s failed: %s", info.id, client.IP, entry.Error)
+	}
+}
+
+// logf writes a message to the handler's logger, if it has one.
+func (h *httpHandler) logf(format string, args ...interface{}) {
+	if h.logger != nil {
+		h.logger.Printf(format, args...)
 	}
 }
@@ -1,6 +1,7 @@
 package websock_test
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 	"github.com/gorilla/websocket"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rinq/httpd/src/internal/clientaddr"
 	. "github.com/rinq/httpd/src/websock"
 	"github.com/rinq/httpd/src/websock/internal/mock"
 )
@@ -30,8 +33,11 @@ var _ = Describe("httpHandler", func() {
 		handlerB = &mock.Handler{}
 		handlerB.Impl.Protocol = "proto-b"
 
+		origins, err := ParseOriginPatterns("*")
+		Expect(err).ShouldNot(HaveOccurred())
+
 		subject = NewHTTPHandler(
-			"*",
+			origins,
 			time.Second,
 			10,
 			logger,
@@ -73,6 +79,84 @@ var _ = Describe("httpHandler", func() {
 		}
 	})
 
+	It("resolves the client address before dispatching", func() {
+		barrier := make(chan clientaddr.Info, 1)
+		handlerA.Impl.Handle = func(_ Connection, r *http.Request) error {
+			info, _ := clientaddr.FromContext(r.Context())
+			barrier <- info
+			return nil
+		}
+
+		url := strings.Replace(server.URL, "http://", "ws://", 1)
+		d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+		con, _, err := d.Dial(url, http.Header{"X-Forwarded-For": {"192.0.2.1"}})
+		if con != nil {
+			defer con.Close()
+		}
+
+		Expect(err).ShouldNot(HaveOccurred())
+
+		select {
+		case info := <-barrier:
+			Expect(info.IP).To(Equal("127.0.0.1"))
+		case <-time.After(time.Second):
+			panic("timeout")
+		}
+	})
+
+	It("assigns a unique connection ID before dispatching", func() {
+		barrier := make(chan uint64, 2)
+		handlerA.Impl.Handle = func(_ Connection, r *http.Request) error {
+			id, _ := ConnectionID(r.Context())
+			barrier <- id
+			return nil
+		}
+
+		url := strings.Replace(server.URL, "http://", "ws://", 1)
+		d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+
+		for i := 0; i < 2; i++ {
+			con, _, err := d.Dial(url, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer con.Close()
+		}
+
+		var ids []uint64
+		for i := 0; i < 2; i++ {
+			select {
+			case id := <-barrier:
+				ids = append(ids, id)
+			case <-time.After(time.Second):
+				panic("timeout")
+			}
+		}
+
+		Expect(ids[0]).NotTo(BeZero())
+		Expect(ids[1]).NotTo(BeZero())
+		Expect(ids[0]).NotTo(Equal(ids[1]))
+	})
+
+	It("closes the connection with the code from a CloseError", func() {
+		handlerA.Impl.Handle = func(Connection, *http.Request) error {
+			return &CloseError{Code: ClosePolicyViolation, Reason: "too fast"}
+		}
+
+		url := strings.Replace(server.URL, "http://", "ws://", 1)
+		d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+		con, _, err := d.Dial(url, nil)
+		if con != nil {
+			defer con.Close()
+		}
+
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, _, err = con.ReadMessage()
+		Expect(err).To(Equal(&websocket.CloseError{
+			Code: websocket.ClosePolicyViolation,
+			Text: "too fast",
+		}))
+	})
+
 	It("closes the connection if the sub-protocol is not supported", func() {
 		url := strings.Replace(server.URL, "http://", "ws://", 1)
 		d := websocket.Dialer{Subprotocols: []string{"unsupported-protocol"}}
@@ -101,6 +185,189 @@ var _ = Describe("httpHandler", func() {
 		}
 	})
 
+	Context("when a connection limit is reached", func() {
+		var (
+			origins  []OriginPattern
+			registry *Registry
+			release  chan struct{}
+			output   *gbytes.Buffer
+		)
+
+		BeforeEach(func() {
+			server.Close()
+
+			var err error
+			origins, err = ParseOriginPatterns("*")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			output = gbytes.NewBuffer()
+			registry = NewRegistry()
+			subject = NewHTTPHandlerWithOptions(
+				origins,
+				time.Second,
+				10,
+				log.New(output, "", 0),
+				[]Handler{handlerA},
+				MaxConnectionsPerIP(1),
+				Connections(registry),
+			)
+
+			server = httptest.NewServer(subject)
+
+			release = make(chan struct{})
+			r := release
+			handlerA.Impl.Handle = func(Connection, *http.Request) error {
+				<-r
+				return nil
+			}
+		})
+
+		AfterEach(func() {
+			close(release)
+		})
+
+		It("rejects the upgrade request", func() {
+			url := strings.Replace(server.URL, "http://", "ws://", 1)
+			d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+
+			con, _, err := d.Dial(url, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer con.Close()
+
+			_, res, err := d.Dial(url, nil)
+			Expect(err).Should(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(res.Header.Get("Retry-After")).To(Equal("10"))
+			Expect(output).To(gbytes.Say("rejected WebSocket upgrade from 127.0.0.1: connection limit reached"))
+		})
+
+		It("applies the limit across handlers that share a registry", func() {
+			url := strings.Replace(server.URL, "http://", "ws://", 1)
+			d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+
+			con, _, err := d.Dial(url, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer con.Close()
+
+			other := httptest.NewServer(
+				NewHTTPHandlerWithOptions(
+					origins,
+					time.Second,
+					10,
+					logger,
+					[]Handler{handlerA},
+					MaxConnectionsPerIP(1),
+					Connections(registry),
+				),
+			)
+			defer other.Close()
+
+			_, res, err := d.Dial(strings.Replace(other.URL, "http://", "ws://", 1), nil)
+			Expect(err).Should(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+		})
+	})
+
+	Context("when the access log is enabled", func() {
+		var accessLog *gbytes.Buffer
+
+		BeforeEach(func() {
+			server.Close()
+
+			origins, err := ParseOriginPatterns("example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			accessLog = gbytes.NewBuffer()
+			subject = NewHTTPHandlerWithOptions(
+				origins,
+				time.Second,
+				10,
+				logger,
+				[]Handler{handlerA},
+				AccessLog(accessLog, AccessLogJSON),
+			)
+
+			server = httptest.NewServer(subject)
+		})
+
+		It("records the connection when it is closed", func() {
+			handlerA.Impl.Handle = func(c Connection, r *http.Request) error {
+				SessionCreated(r.Context())
+
+				if _, err := c.NextReader(); err != nil {
+					return err
+				}
+
+				return &CloseError{Code: ClosePolicyViolation, Reason: "too fast"}
+			}
+
+			url := strings.Replace(server.URL, "http://", "ws://", 1)
+			d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+			con, _, err := d.Dial(url, http.Header{
+				"Origin":     {"http://example.org"},
+				"User-Agent": {"test-agent"},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			defer con.Close()
+
+			err = con.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(accessLog).Should(gbytes.Say(`\n`))
+
+			var entry AccessLogEntry
+			err = json.Unmarshal(accessLog.Contents(), &entry)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(entry.ClientIP).To(Equal("127.0.0.1"))
+			Expect(entry.Origin).To(Equal("http://example.org"))
+			Expect(entry.UserAgent).To(Equal("test-agent"))
+			Expect(entry.Protocol).To(Equal("proto-a"))
+			Expect(entry.Status).To(Equal(http.StatusSwitchingProtocols))
+			Expect(entry.CloseCode).To(Equal(websocket.ClosePolicyViolation))
+			Expect(entry.CloseReason).To(Equal("too fast"))
+			Expect(entry.MessagesIn).To(BeEquivalentTo(1))
+			Expect(entry.Sessions).To(BeEquivalentTo(1))
+		})
+
+		It("does not record the resume token", func() {
+			handlerA.Impl.Handle = func(Connection, *http.Request) error {
+				return nil
+			}
+
+			url := strings.Replace(server.URL, "http://", "ws://", 1) + "/?client_id=abc&resume=secret-token"
+			d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+			con, _, err := d.Dial(url, http.Header{"Origin": {"http://example.org"}})
+			Expect(err).ShouldNot(HaveOccurred())
+			defer con.Close()
+
+			Eventually(accessLog).Should(gbytes.Say(`\n`))
+
+			var entry AccessLogEntry
+			err = json.Unmarshal(accessLog.Contents(), &entry)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(entry.Request).To(Equal("GET /?client_id=abc&resume=[REDACTED] HTTP/1.1"))
+			Expect(accessLog.Contents()).ToNot(ContainSubstring("secret-token"))
+		})
+
+		It("records rejected upgrades", func() {
+			url := strings.Replace(server.URL, "http://", "ws://", 1)
+			d := websocket.Dialer{Subprotocols: []string{"proto-a"}}
+			_, _, err := d.Dial(url, http.Header{"Origin": {"http://example.com"}})
+			Expect(err).Should(HaveOccurred())
+
+			Eventually(accessLog).Should(gbytes.Say(`\n`))
+
+			var entry AccessLogEntry
+			err = json.Unmarshal(accessLog.Contents(), &entry)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(entry.Status).To(Equal(http.StatusForbidden))
+			Expect(entry.Error).To(ContainSubstring("Origin"))
+		})
+	})
+
 	It("renders an error page when the request is not an upgrade", func() {
 		r, err := http.Get(server.URL)
 		if r != nil {
@@ -0,0 +1,170 @@
+package mock
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+)
+
+// Peer is a mock rinq.Peer. Methods that are not implemented by the mock
+// panic.
+type Peer struct {
+	rinq.Peer
+
+	Impl struct {
+		Session func() rinq.Session
+	}
+}
+
+// Session forwards to p.Impl.Session
+func (p *Peer) Session() rinq.Session {
+	return p.Impl.Session()
+}
+
+// Session is a mock rinq.Session that records its notification listeners.
+// Methods that are not implemented by the mock panic.
+type Session struct {
+	rinq.Session
+
+	Impl struct {
+		ID        ident.SessionID
+		Attrs     []rinq.Attr
+		Call      func(ctx context.Context, ns, cmd string, p *rinq.Payload) (*rinq.Payload, error)
+		CallAsync func(ctx context.Context, ns, cmd string, p *rinq.Payload) (ident.MessageID, error)
+		Execute   func(ctx context.Context, ns, cmd string, p *rinq.Payload) error
+	}
+
+	mutex     sync.Mutex
+	listeners map[string]rinq.NotificationHandler
+	done      chan struct{}
+}
+
+// NewSession returns a mock session with the given ID sequence number.
+func NewSession(seq uint32) *Session {
+	s := &Session{
+		listeners: map[string]rinq.NotificationHandler{},
+		done:      make(chan struct{}),
+	}
+	s.Impl.ID.Seq = seq
+
+	return s
+}
+
+// ID returns s.Impl.ID
+func (s *Session) ID() ident.SessionID {
+	return s.Impl.ID
+}
+
+// Call forwards to s.Impl.Call
+func (s *Session) Call(ctx context.Context, ns, cmd string, p *rinq.Payload) (*rinq.Payload, error) {
+	return s.Impl.Call(ctx, ns, cmd, p)
+}
+
+// CallAsync forwards to s.Impl.CallAsync
+func (s *Session) CallAsync(ctx context.Context, ns, cmd string, p *rinq.Payload) (ident.MessageID, error) {
+	return s.Impl.CallAsync(ctx, ns, cmd, p)
+}
+
+// Execute forwards to s.Impl.Execute
+func (s *Session) Execute(ctx context.Context, ns, cmd string, p *rinq.Payload) error {
+	return s.Impl.Execute(ctx, ns, cmd, p)
+}
+
+// SetAsyncHandler does nothing, asynchronous responses are not delivered by
+// the mock.
+func (s *Session) SetAsyncHandler(rinq.AsyncHandler) error {
+	return nil
+}
+
+// CurrentRevision returns a revision with the attributes in s.Impl.Attrs.
+func (s *Session) CurrentRevision() rinq.Revision {
+	return &Revision{Attrs: s.Impl.Attrs}
+}
+
+// Listen records h as the handler for notifications in ns.
+func (s *Session) Listen(ns string, h rinq.NotificationHandler) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.listeners[ns] = h
+	return nil
+}
+
+// Unlisten removes the handler for notifications in ns.
+func (s *Session) Unlisten(ns string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.listeners, ns)
+	return nil
+}
+
+// IsListening returns true if s has a handler for notifications in ns.
+func (s *Session) IsListening(ns string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.listeners[ns]
+	return ok
+}
+
+// Deliver invokes the handler for the notification's namespace, if any.
+func (s *Session) Deliver(n rinq.Notification) {
+	s.mutex.Lock()
+	h := s.listeners[n.Namespace]
+	s.mutex.Unlock()
+
+	if h != nil {
+		h(context.Background(), s, n)
+	}
+}
+
+// Destroy closes the channel returned by Done().
+func (s *Session) Destroy() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// Done returns a channel that is closed when the session is destroyed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Revision is a mock rinq.Revision. Methods that are not implemented by the
+// mock panic.
+type Revision struct {
+	rinq.Revision
+
+	Attrs []rinq.Attr
+}
+
+// GetMany returns the attributes in r.Attrs with the given keys. Namespaces
+// are ignored.
+func (r *Revision) GetMany(_ context.Context, _ string, keys ...string) ([]rinq.Attr, error) {
+	attrs := make([]rinq.Attr, len(keys))
+
+	for i, k := range keys {
+		attrs[i].Key = k
+
+		for _, a := range r.Attrs {
+			if a.Key == k {
+				attrs[i] = a
+			}
+		}
+	}
+
+	return attrs, nil
+}
+
+// Update returns r unchanged.
+func (r *Revision) Update(context.Context, string, ...rinq.Attr) (rinq.Revision, error) {
+	return r, nil
+}
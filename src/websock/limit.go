@@ -0,0 +1,68 @@
+package websock
+
+import (
+	"net/http"
+	"sync"
+)
+
+// connLimiter bounds the number of concurrent connections, both in total and
+// per client IP address.
+type connLimiter struct {
+	max      int
+	maxPerIP int
+
+	// counts holds the number of open connections. It is shared by the
+	// handlers that share a Registry, so that the limits apply across them.
+	counts *connCounts
+}
+
+// connCounts is the number of open connections, in total and per client IP
+// address.
+type connCounts struct {
+	mutex sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// acquire reserves a connection slot for a client with the given IP address.
+// If no slot is available it returns false along with the HTTP status code
+// that should be used to reject the request.
+func (l *connLimiter) acquire(ip string) (int, bool) {
+	c := l.counts
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if l.max > 0 && c.total >= l.max {
+		return http.StatusServiceUnavailable, false
+	}
+
+	if l.maxPerIP > 0 && c.perIP[ip] >= l.maxPerIP {
+		return http.StatusTooManyRequests, false
+	}
+
+	if c.perIP == nil {
+		c.perIP = map[string]int{}
+	}
+
+	c.total++
+	c.perIP[ip]++
+
+	return 0, true
+}
+
+// release frees a connection slot previously reserved by acquire().
+func (l *connLimiter) release(ip string) {
+	c := l.counts
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.total--
+
+	if n := c.perIP[ip] - 1; n > 0 {
+		c.perIP[ip] = n
+	} else {
+		delete(c.perIP, ip)
+	}
+}
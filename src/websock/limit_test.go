@@ -0,0 +1,56 @@
+package websock
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("connLimiter", func() {
+	var subject *connLimiter
+
+	BeforeEach(func() {
+		subject = &connLimiter{max: 3, maxPerIP: 2, counts: &connCounts{}}
+	})
+
+	It("allows connections up to the per-IP limit", func() {
+		_, ok := subject.acquire("192.0.2.1")
+		Expect(ok).To(BeTrue())
+
+		_, ok = subject.acquire("192.0.2.1")
+		Expect(ok).To(BeTrue())
+
+		code, ok := subject.acquire("192.0.2.1")
+		Expect(ok).To(BeFalse())
+		Expect(code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("allows connections up to the global limit", func() {
+		subject.acquire("192.0.2.1")
+		subject.acquire("192.0.2.2")
+		subject.acquire("192.0.2.3")
+
+		code, ok := subject.acquire("192.0.2.4")
+		Expect(ok).To(BeFalse())
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("allows new connections once existing connections are released", func() {
+		subject.acquire("192.0.2.1")
+		subject.acquire("192.0.2.1")
+		subject.release("192.0.2.1")
+
+		_, ok := subject.acquire("192.0.2.1")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("does not limit connections when the limits are zero", func() {
+		subject = &connLimiter{counts: &connCounts{}}
+
+		for i := 0; i < 100; i++ {
+			_, ok := subject.acquire("192.0.2.1")
+			Expect(ok).To(BeTrue())
+		}
+	})
+})
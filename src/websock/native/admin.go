@@ -0,0 +1,119 @@
+package native
+
+import (
+	"sort"
+
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// Sessions returns information about each of the connection's sessions,
+// ordered by session index. It implements websock.SessionInspector.
+func (v *visitor) Sessions() []websock.SessionInfo {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	indices := make([]message.SessionIndex, 0, len(v.forward))
+	for i := range v.forward {
+		indices = append(indices, i)
+	}
+
+	sort.Slice(indices, func(a, b int) bool {
+		return indices[a] < indices[b]
+	})
+
+	result := make([]websock.SessionInfo, len(indices))
+	for n, i := range indices {
+		result[n] = websock.SessionInfo{
+			ID:         v.forward[i].ID().String(),
+			Namespaces: []string{},
+		}
+
+		for k := range v.listening {
+			if k.Session == i {
+				result[n].Namespaces = append(result[n].Namespaces, k.Namespace)
+			}
+		}
+
+		sort.Strings(result[n].Namespaces)
+	}
+
+	return result
+}
+
+// DestroySession destroys the session with the given Rinq session ID, and
+// notifies the client. The reason is included in the notification if the
+// client supports it. It implements websock.SessionInspector.
+func (v *visitor) DestroySession(id, reason string) bool {
+	m, traceID, ok := v.destroySession(id, reason)
+	if !ok {
+		return false
+	}
+
+	v.send(m)
+	v.logf(traceID, "session %s destroyed by an administrator: %s", id, reason)
+
+	return true
+}
+
+// destroySession destroys the session with the given Rinq session ID. It
+// returns the message to send to the client, and the connection's trace ID.
+func (v *visitor) destroySession(id, reason string) (*message.SessionDestroy, string, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for i, sess := range v.forward {
+		if sess.ID().String() != id {
+			continue
+		}
+
+		v.forget(i, sess)
+		v.stopFanOut(sess)
+		go sess.Destroy()
+
+		m := message.NewSessionDestroy(i)
+		if v.capabilities.SessionDestroyReason {
+			m.Reason = reason
+		}
+
+		return m, v.traceID, true
+	}
+
+	return nil, "", false
+}
+
+// setListening records whether session i is listening for notifications in
+// ns.
+func (v *visitor) setListening(i message.SessionIndex, ns string, listening bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	k := listenKey{i, ns}
+
+	if !listening {
+		delete(v.listening, k)
+		return
+	}
+
+	if v.listening == nil {
+		v.listening = map[listenKey]struct{}{}
+	}
+
+	v.listening[k] = struct{}{}
+}
+
+// forget removes session i from the visitor's session state. v.mutex must be
+// held for writing.
+func (v *visitor) forget(i message.SessionIndex, sess rinq.Session) {
+	delete(v.forward, i)
+	delete(v.reverse, sess.ID())
+	delete(v.inflight.sessions, i)
+	v.clearConflation(i)
+
+	for k := range v.listening {
+		if k.Session == i {
+			delete(v.listening, k)
+		}
+	}
+}
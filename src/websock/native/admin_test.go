@@ -0,0 +1,104 @@
+package native
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+)
+
+var _ = Describe("visitor inspection", func() {
+	var (
+		sent    []message.Outgoing
+		sess    *mock.Session
+		subject *visitor
+	)
+
+	BeforeEach(func() {
+		sent = nil
+		sess = mock.NewSession(1)
+		sess.Impl.ID.Peer = ident.PeerID{Clock: 1, Rand: 2}
+
+		subject = newVisitor(
+			context.Background(),
+			nil,
+			nil,
+			func(m message.Outgoing) {
+				// the client's messages are sent without holding the lock
+				subject.Sessions()
+				sent = append(sent, m)
+			},
+		)
+		subject.forward = map[message.SessionIndex]rinq.Session{1: sess}
+		subject.reverse = map[ident.SessionID]message.SessionIndex{sess.ID(): 1}
+	})
+
+	Describe("Sessions", func() {
+		It("returns the session IDs and listened namespaces", func() {
+			for _, ns := range []string{"ns-b", "ns-a"} {
+				m := &message.Listen{}
+				m.Session = 1
+				m.Namespaces = []string{ns}
+
+				err := subject.VisitListen(m)
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+
+			Expect(subject.Sessions()).To(Equal([]websock.SessionInfo{
+				{ID: sess.ID().String(), Namespaces: []string{"ns-a", "ns-b"}},
+			}))
+		})
+
+		It("does not include namespaces that are no longer listened to", func() {
+			m := &message.Listen{}
+			m.Session = 1
+			m.Namespaces = []string{"ns"}
+			err := subject.VisitListen(m)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			u := &message.Unlisten{}
+			u.Session = 1
+			u.Namespaces = []string{"ns"}
+			err = subject.VisitUnlisten(u)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(subject.Sessions()[0].Namespaces).To(BeEmpty())
+		})
+	})
+
+	Describe("DestroySession", func() {
+		It("destroys the session and notifies the client", func() {
+			ok := subject.DestroySession(sess.ID().String(), "kicked")
+
+			Expect(ok).To(BeTrue())
+			Expect(subject.Sessions()).To(BeEmpty())
+			Eventually(sess.Done()).Should(BeClosed())
+
+			Expect(sent).To(Equal([]message.Outgoing{message.NewSessionDestroy(1)}))
+		})
+
+		It("includes the reason if the client supports it", func() {
+			m := &message.Capabilities{}
+			m.SessionDestroyReason = true
+
+			err := subject.VisitCapabilities(m)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			subject.DestroySession(sess.ID().String(), "kicked")
+
+			expected := message.NewSessionDestroy(1)
+			expected.Reason = "kicked"
+			Expect(sent).To(Equal([]message.Outgoing{expected}))
+		})
+
+		It("returns false if there is no such session", func() {
+			Expect(subject.DestroySession("unknown", "kicked")).To(BeFalse())
+			Expect(sent).To(BeEmpty())
+		})
+	})
+})
@@ -1,10 +1,14 @@
 package native
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"net"
 	"net/http"
+	"strings"
 
-	"github.com/golang/gddo/httputil/header"
+	"github.com/rinq/httpd/src/internal/clientaddr"
 	"github.com/rinq/rinq-go/src/rinq"
 )
 
@@ -19,36 +23,95 @@ const (
 	HttpdAttrRemoteAddr = "remote-addr"
 	//HttpdAttrLocalAddr contains the report local host:port
 	HttpdAttrLocalAddr = "local-addr"
+	//HttpdAttrForwardedProto contains the protocol reported by a trusted proxy
+	HttpdAttrForwardedProto = "forwarded-proto"
+	//HttpdAttrForwardedHost contains the host reported by a trusted proxy
+	HttpdAttrForwardedHost = "forwarded-host"
+	//HttpdAttrTLSClientSubject contains the subject of the verified client certificate
+	HttpdAttrTLSClientSubject = "tls-client-subject"
+	//HttpdAttrTLSClientSANs contains the subject alternative names of the verified client certificate
+	HttpdAttrTLSClientSANs = "tls-client-sans"
+	//HttpdAttrTLSClientFingerprint contains the SHA-256 fingerprint of the verified client certificate
+	HttpdAttrTLSClientFingerprint = "tls-client-fingerprint"
 )
 
 // sessionAttributes returns the set of attributes to apply to new sessions for
 // the given request.
+//
+// The client's address is taken from the request context, where it is placed
+// by the websock package. Forwarding headers are ignored if it is not present.
 func sessionAttributes(r *http.Request) []rinq.Attr {
-	clientIP := ""
-	for _, ip := range header.ParseList(r.Header, "X-Forwarded-For") {
-		clientIP = ip
-		break
-	}
-
-	if clientIP == "" {
-		host, _, _ := net.SplitHostPort(r.RemoteAddr)
-		if host != "" {
-			clientIP = host
-		} else {
-			clientIP = r.RemoteAddr
-		}
-	}
+	client := resolveClient(r)
 
 	attr := []rinq.Attr{
 		rinq.Freeze(HttpdAttrHost, r.Host),
-		rinq.Freeze(HttpdAttrClientIP, clientIP),
+		rinq.Freeze(HttpdAttrClientIP, client.IP),
 
 		rinq.Freeze(HttpdAttrRemoteAddr, r.RemoteAddr),
 	}
 
+	if client.Proto != "" {
+		attr = append(attr, rinq.Freeze(HttpdAttrForwardedProto, client.Proto))
+	}
+
+	if client.Host != "" {
+		attr = append(attr, rinq.Freeze(HttpdAttrForwardedHost, client.Host))
+	}
+
 	if localAddr := r.Context().Value(http.LocalAddrContextKey); localAddr != nil {
 		attr = append(attr, rinq.Freeze(HttpdAttrLocalAddr, localAddr.(net.Addr).String()))
 	}
 
-	return attr
+	return append(attr, tlsAttributes(r.TLS)...)
+}
+
+// resolveClient returns the client's address from the request context, where
+// it is placed by the websock package. Forwarding headers are ignored if it is
+// not present.
+func resolveClient(r *http.Request) clientaddr.Info {
+	client, ok := clientaddr.FromContext(r.Context())
+	if !ok {
+		client = clientaddr.NewResolver(clientaddr.XForwardedFor).Resolve(r)
+	}
+
+	return client
+}
+
+// tlsAttributes returns the attributes that describe the client certificate
+// presented on a TLS connection, if it has been verified.
+func tlsAttributes(state *tls.ConnectionState) []rinq.Attr {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	fingerprint, _ := clientFingerprint(state)
+
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+
+	return []rinq.Attr{
+		rinq.Freeze(HttpdAttrTLSClientSubject, cert.Subject.String()),
+		rinq.Freeze(HttpdAttrTLSClientSANs, strings.Join(sans, ",")),
+		rinq.Freeze(HttpdAttrTLSClientFingerprint, fingerprint),
+	}
+}
+
+// clientFingerprint returns the hex-encoded SHA-256 fingerprint of the client
+// certificate presented on a TLS connection, if it has been verified.
+func clientFingerprint(state *tls.ConnectionState) (string, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return "", false
+	}
+
+	fingerprint := sha256.Sum256(state.VerifiedChains[0][0].Raw)
+
+	return hex.EncodeToString(fingerprint[:]), true
 }
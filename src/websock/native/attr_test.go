@@ -1,14 +1,26 @@
 package native
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"time"
 
-	"context"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/internal/clientaddr"
 	"github.com/rinq/rinq-go/src/rinq"
-	"net"
-	"net/http"
 )
 
 var _ = Describe("sessionAttributes", func() {
@@ -69,13 +81,111 @@ var _ = Describe("sessionAttributes", func() {
 		))
 	})
 
-	It("uses the X-Forwarded-For header when present", func() {
+	It("ignores the X-Forwarded-For header when the client address has not been resolved", func() {
 		request := httptest.NewRequest("GET", "/", nil)
 		request.Header.Add("X-Forwarded-For", "10.1.1.1,10.2.2.2")
 		attrs := sessionAttributes(request)
 
 		Expect(attrs).To(ContainElement(
-			rinq.Freeze(HttpdAttrClientIP, "10.1.1.1"),
+			rinq.Freeze(HttpdAttrClientIP, "192.0.2.1"),
 		))
 	})
+
+	Context("when the client presented a verified certificate", func() {
+		var (
+			request *http.Request
+			cert    *x509.Certificate
+		)
+
+		BeforeEach(func() {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			u, _ := url.Parse("spiffe://example.com/device")
+			template := &x509.Certificate{
+				SerialNumber:   big.NewInt(1),
+				Subject:        pkix.Name{CommonName: "device-1", Organization: []string{"Example"}},
+				DNSNames:       []string{"device-1.example.com"},
+				EmailAddresses: []string{"ops@example.com"},
+				IPAddresses:    []net.IP{net.ParseIP("192.0.2.1")},
+				URIs:           []*url.URL{u},
+				NotBefore:      time.Now().Add(-time.Hour),
+				NotAfter:       time.Now().Add(time.Hour),
+			}
+
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			cert, err = x509.ParseCertificate(der)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request = httptest.NewRequest("GET", "/", nil)
+			request.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			}
+		})
+
+		It("includes attributes describing the certificate", func() {
+			attrs := sessionAttributes(request)
+			fingerprint := sha256.Sum256(cert.Raw)
+
+			Expect(attrs).To(ContainElement(
+				rinq.Freeze(HttpdAttrTLSClientSubject, "CN=device-1,O=Example"),
+			))
+			Expect(attrs).To(ContainElement(
+				rinq.Freeze(
+					HttpdAttrTLSClientSANs,
+					"device-1.example.com,ops@example.com,192.0.2.1,spiffe://example.com/device",
+				),
+			))
+			Expect(attrs).To(ContainElement(
+				rinq.Freeze(HttpdAttrTLSClientFingerprint, hex.EncodeToString(fingerprint[:])),
+			))
+		})
+
+		It("does not include the attributes if the certificate was not verified", func() {
+			request.TLS.VerifiedChains = nil
+			attrs := sessionAttributes(request)
+
+			for _, attr := range attrs {
+				Expect(attr.Key).NotTo(HavePrefix("tls-"))
+			}
+		})
+	})
+
+	Context("when the client address has been resolved", func() {
+		var request *http.Request
+
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/", nil)
+			request = request.WithContext(clientaddr.NewContext(
+				request.Context(),
+				clientaddr.Info{
+					IP:    "10.1.1.1",
+					Proto: "https",
+					Host:  "app.example.com",
+				},
+			))
+		})
+
+		It("uses the resolved client IP", func() {
+			attrs := sessionAttributes(request)
+
+			Expect(attrs).To(ContainElement(
+				rinq.Freeze(HttpdAttrClientIP, "10.1.1.1"),
+			))
+		})
+
+		It("includes attributes containing the forwarded protocol and host", func() {
+			attrs := sessionAttributes(request)
+
+			Expect(attrs).To(ContainElement(
+				rinq.Freeze(HttpdAttrForwardedProto, "https"),
+			))
+			Expect(attrs).To(ContainElement(
+				rinq.Freeze(HttpdAttrForwardedHost, "app.example.com"),
+			))
+		})
+	})
 })
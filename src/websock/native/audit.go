@@ -0,0 +1,297 @@
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// auditMetrics contains the counters for all auditors.
+var auditMetrics = expvar.NewMap("rinq_httpd_audit")
+
+// redactedValue replaces the value of redacted payload fields.
+const redactedValue = "[REDACTED]"
+
+// AuditConfig controls which command requests are audited.
+type AuditConfig struct {
+	// Namespaces is the list of namespaces in which command requests are
+	// audited, or "*" to audit all namespaces.
+	Namespaces []string
+
+	// CapturePayloads includes the request payload in each record.
+	CapturePayloads bool
+
+	// Redact is the list of payload field names whose values are replaced
+	// before the payload is recorded. Names are matched case-insensitively at
+	// any depth.
+	Redact []string
+}
+
+// AuditRecord is a record of a single command request made by a client.
+type AuditRecord struct {
+	Time         time.Time         `json:"time"`
+	Connection   uint64            `json:"connection"`
+	SessionIndex uint16            `json:"session_index"`
+	Session      string            `json:"session,omitempty"`
+	Identity     map[string]string `json:"identity,omitempty"`
+	ClientIP     string            `json:"client_ip,omitempty"`
+	TraceID      string            `json:"trace_id,omitempty"`
+	Kind         string            `json:"kind"`
+	Namespace    string            `json:"namespace"`
+	Command      string            `json:"command"`
+	Result       string            `json:"result"`
+	Reason       string            `json:"reason,omitempty"`
+	LatencyMS    float64           `json:"latency_ms"`
+	PayloadSize  int               `json:"payload_size"`
+	Payload      interface{}       `json:"payload,omitempty"`
+}
+
+const (
+	// auditCall, auditAsyncCall and auditExecute are the kinds of audited
+	// command requests.
+	auditCall      = "call"
+	auditAsyncCall = "async-call"
+	auditExecute   = "execute"
+
+	// auditDispatched is the result of async calls and executes that were
+	// sent to Rinq. Their outcome is not recorded.
+	auditDispatched = "dispatched"
+)
+
+// Auditor writes a JSON record of each audited command request to a writer,
+// one record per line.
+//
+// Writes are best-effort. Write errors are counted in the "errors" expvar of
+// rinq_httpd_audit, but do not affect the command request.
+//
+// Records are written to w one at a time, so Handlers that use the same Auditor
+// produce a single log whose lines are never interleaved.
+type Auditor struct {
+	all        bool
+	namespaces map[string]bool
+	capture    bool
+	redact     map[string]bool
+
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewAuditor returns an auditor that writes records to w.
+func NewAuditor(w io.Writer, c AuditConfig) *Auditor {
+	a := &Auditor{
+		namespaces: map[string]bool{},
+		capture:    c.CapturePayloads,
+		redact:     map[string]bool{},
+		w:          w,
+	}
+
+	for _, ns := range c.Namespaces {
+		if ns == "*" {
+			a.all = true
+		}
+		a.namespaces[ns] = true
+	}
+
+	for _, f := range c.Redact {
+		a.redact[strings.ToLower(f)] = true
+	}
+
+	return a
+}
+
+// audits returns true if command requests in ns are audited.
+func (a *Auditor) audits(ns string) bool {
+	return a != nil && (a.all || a.namespaces[ns])
+}
+
+// write writes r to the auditor's writer.
+func (a *Auditor) write(r *AuditRecord) {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		auditMetrics.Add("errors", 1)
+		return
+	}
+
+	a.mutex.Lock()
+	_, err = a.w.Write(append(buf, '\n'))
+	a.mutex.Unlock()
+
+	if err != nil {
+		auditMetrics.Add("errors", 1)
+	} else {
+		auditMetrics.Add("records", 1)
+	}
+}
+
+// payload returns the value of p to include in a record, with redacted fields
+// replaced, or nil if payloads are not captured.
+func (a *Auditor) payload(p *rinq.Payload) interface{} {
+	if !a.capture || p == nil {
+		return nil
+	}
+
+	return a.sanitize(p.Value())
+}
+
+// sanitize returns a copy of v that can be encoded as JSON, with the values of
+// redacted fields replaced.
+func (a *Auditor) sanitize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			a.sanitizeField(m, fmt.Sprint(k), e)
+		}
+		return m
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			a.sanitizeField(m, k, e)
+		}
+		return m
+
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = a.sanitize(e)
+		}
+		return s
+	}
+
+	return v
+}
+
+// sanitizeField adds the field k with value v to m, replacing v if the field is
+// redacted.
+func (a *Auditor) sanitizeField(m map[string]interface{}, k string, v interface{}) {
+	if a.redact[strings.ToLower(k)] {
+		m[k] = redactedValue
+	} else {
+		m[k] = a.sanitize(v)
+	}
+}
+
+// rejection is an error describing why a command request was rejected
+// without being forwarded to Rinq.
+type rejection message.RejectReason
+
+// rejectExecuteDenied is the reason recorded for executes that are discarded
+// because the call policy denies them. It is not sent to the client.
+const rejectExecuteDenied message.RejectReason = "execute-denied"
+
+func (r rejection) Error() string {
+	return "rejected: " + string(r)
+}
+
+// auditResult returns the result class of a command request of the given kind
+// that completed with err, and the reason for that result, if any.
+func auditResult(kind string, err error) (result, reason string) {
+	switch e := err.(type) {
+	case nil:
+		if kind == auditCall {
+			return "success", ""
+		}
+		return auditDispatched, ""
+	case rinq.Failure:
+		return "failure", e.Type
+	case rinq.CommandError:
+		return "command-error", ""
+	case rejection:
+		return "rejected", string(e)
+	}
+
+	switch err {
+	case context.DeadlineExceeded:
+		return "timeout", ""
+	case context.Canceled:
+		return "canceled", ""
+	case errUnavailable:
+		return "rejected", string(message.RejectUnavailable)
+	}
+
+	return "error", ""
+}
+
+// audit writes a record of a command request made by the given session that
+// completed with err, if requests in ns are audited.
+func (v *visitor) audit(
+	kind string,
+	i message.SessionIndex,
+	ns, cmd string,
+	p *rinq.Payload,
+	traceID string,
+	start time.Time,
+	err error,
+) {
+	if !v.auditor.audits(ns) {
+		return
+	}
+
+	r := &AuditRecord{
+		Time:         start.UTC(),
+		SessionIndex: uint16(i),
+		TraceID:      traceID,
+		Kind:         kind,
+		Namespace:    ns,
+		Command:      cmd,
+		LatencyMS:    time.Since(start).Seconds() * 1000,
+	}
+
+	v.mutex.RLock()
+	r.Connection = v.connectionID
+	r.Identity = v.identityAttrs()
+	r.ClientIP = v.clientIP
+	v.mutex.RUnlock()
+
+	// the payload is decoded and redacted without holding the lock, as it
+	// may be large
+	r.PayloadSize = p.Len()
+	r.Payload = v.auditor.payload(p)
+
+	r.Result, r.Reason = auditResult(kind, err)
+
+	if sess, ok := v.find(i); ok {
+		r.Session = sess.ID().String()
+	}
+
+	v.auditor.write(r)
+}
+
+// auditRejection writes a record of a command request that was rejected
+// without being forwarded to Rinq.
+func (v *visitor) auditRejection(m message.Incoming, reason message.RejectReason) {
+	now := time.Now()
+
+	switch m := m.(type) {
+	case *message.SyncCall:
+		v.audit(auditCall, m.Session, m.Namespace, m.Command, m.Payload, v.traceOf(m.TraceID), now, rejection(reason))
+	case *message.AsyncCall:
+		v.audit(auditAsyncCall, m.Session, m.Namespace, m.Command, m.Payload, v.traceOf(m.TraceID), now, rejection(reason))
+	case *message.Execute:
+		v.audit(auditExecute, m.Session, m.Namespace, m.Command, m.Payload, v.traceOf(m.TraceID), now, rejection(reason))
+	}
+}
+
+// identityAttrs returns the httpd attributes that identify the client.
+// v.mutex must be held.
+func (v *visitor) identityAttrs() map[string]string {
+	if len(v.attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, len(v.attrs))
+	for _, attr := range v.attrs {
+		m[attr.Key] = attr.Value
+	}
+
+	return m
+}
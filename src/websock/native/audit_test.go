@@ -0,0 +1,197 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+)
+
+var _ = Describe("Auditor", func() {
+	Describe("audits", func() {
+		It("returns true for opted-in namespaces", func() {
+			subject := NewAuditor(nil, AuditConfig{Namespaces: []string{"ns"}})
+
+			Expect(subject.audits("ns")).To(BeTrue())
+			Expect(subject.audits("other")).To(BeFalse())
+		})
+
+		It("returns true for all namespaces when the wildcard is used", func() {
+			subject := NewAuditor(nil, AuditConfig{Namespaces: []string{"*"}})
+
+			Expect(subject.audits("ns")).To(BeTrue())
+		})
+
+		It("returns false for a nil auditor", func() {
+			var subject *Auditor
+
+			Expect(subject.audits("ns")).To(BeFalse())
+		})
+	})
+
+	Describe("payload", func() {
+		It("returns nil if payloads are not captured", func() {
+			subject := NewAuditor(nil, AuditConfig{})
+
+			Expect(subject.payload(rinq.NewPayload("value"))).To(BeNil())
+		})
+
+		It("replaces the values of redacted fields at any depth", func() {
+			subject := NewAuditor(nil, AuditConfig{
+				CapturePayloads: true,
+				Redact:          []string{"Password"},
+			})
+
+			// round-trip through CBOR, so that the payload is decoded as it
+			// would be when received from a client
+			encoded := rinq.NewPayload(map[string]interface{}{
+				"user":     "bob",
+				"password": "secret",
+				"nested": []interface{}{
+					map[string]interface{}{"PASSWORD": "secret"},
+				},
+			}).Bytes()
+
+			p := subject.payload(rinq.NewPayloadFromBytes(encoded))
+
+			Expect(json.Marshal(p)).To(MatchJSON(`{
+				"user": "bob",
+				"password": "[REDACTED]",
+				"nested": [{"PASSWORD": "[REDACTED]"}]
+			}`))
+		})
+	})
+})
+
+var _ = Describe("visitor auditing", func() {
+	var (
+		buf     bytes.Buffer
+		sess    *mock.Session
+		callErr error
+		subject *visitor
+	)
+
+	records := func() []map[string]interface{} {
+		var result []map[string]interface{}
+
+		dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for dec.More() {
+			var r map[string]interface{}
+			Expect(dec.Decode(&r)).To(Succeed())
+			result = append(result, r)
+		}
+
+		return result
+	}
+
+	syncCall := func(ns string) *message.SyncCall {
+		m := &message.SyncCall{}
+		m.Session = 1
+		m.Seq = 123
+		m.Namespace = ns
+		m.Command = "cmd"
+		m.Timeout = time.Second
+		m.Payload = rinq.NewPayload("payload")
+		return m
+	}
+
+	BeforeEach(func() {
+		buf.Reset()
+
+		callErr = nil
+		sess = mock.NewSession(1)
+		sess.Impl.ID.Peer = ident.PeerID{Clock: 1, Rand: 2}
+		sess.Impl.Call = func(context.Context, string, string, *rinq.Payload) (*rinq.Payload, error) {
+			return nil, callErr
+		}
+
+		subject = newVisitor(
+			context.Background(),
+			nil,
+			[]rinq.Attr{rinq.Freeze(HttpdAttrClientIP, "192.0.2.1")},
+			func(message.Outgoing) {},
+		)
+		subject.forward = map[message.SessionIndex]rinq.Session{1: sess}
+		subject.connectionID = 7
+		subject.clientIP = "192.0.2.1"
+
+		Audit(NewAuditor(&buf, AuditConfig{Namespaces: []string{"ns"}})).modify(subject)
+	})
+
+	It("records sync calls", func() {
+		subject.call(sess, syncCall("ns"), CallPolicy{})
+
+		r := records()
+		Expect(r).To(HaveLen(1))
+		Expect(r[0]).To(HaveKeyWithValue("connection", BeNumerically("==", 7)))
+		Expect(r[0]).To(HaveKeyWithValue("session_index", BeNumerically("==", 1)))
+		Expect(r[0]).To(HaveKeyWithValue("session", sess.ID().String()))
+		Expect(r[0]).To(HaveKeyWithValue("identity", HaveKeyWithValue(HttpdAttrClientIP, "192.0.2.1")))
+		Expect(r[0]).To(HaveKeyWithValue("client_ip", "192.0.2.1"))
+		Expect(r[0]).To(HaveKeyWithValue("kind", "call"))
+		Expect(r[0]).To(HaveKeyWithValue("namespace", "ns"))
+		Expect(r[0]).To(HaveKeyWithValue("command", "cmd"))
+		Expect(r[0]).To(HaveKeyWithValue("result", "success"))
+		Expect(r[0]).To(HaveKeyWithValue("payload_size", BeNumerically(">", 0)))
+		Expect(r[0]).To(HaveKey("latency_ms"))
+		Expect(r[0]).NotTo(HaveKey("payload"))
+	})
+
+	It("records the failure type of failed calls", func() {
+		callErr = rinq.Failure{Type: "not-found"}
+
+		subject.call(sess, syncCall("ns"), CallPolicy{})
+
+		r := records()
+		Expect(r[0]).To(HaveKeyWithValue("result", "failure"))
+		Expect(r[0]).To(HaveKeyWithValue("reason", "not-found"))
+	})
+
+	It("records rejected calls", func() {
+		Policies(NewCallPolicies(CallPolicy{
+			Namespace:      "ns",
+			Command:        "*",
+			MaxPayloadSize: 1,
+		})).modify(subject)
+
+		err := subject.VisitSyncCall(syncCall("ns"))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		r := records()
+		Expect(r).To(HaveLen(1))
+		Expect(r[0]).To(HaveKeyWithValue("result", "rejected"))
+		Expect(r[0]).To(HaveKeyWithValue("reason", "payload-too-large"))
+	})
+
+	It("records executes", func() {
+		sess.Impl.Execute = func(context.Context, string, string, *rinq.Payload) error {
+			return nil
+		}
+
+		m := &message.Execute{}
+		m.Session = 1
+		m.Namespace = "ns"
+		m.Command = "cmd"
+
+		err := subject.VisitExecute(m)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		r := records()
+		Expect(r).To(HaveLen(1))
+		Expect(r[0]).To(HaveKeyWithValue("kind", "execute"))
+		Expect(r[0]).To(HaveKeyWithValue("result", "dispatched"))
+	})
+
+	It("does not record requests in other namespaces", func() {
+		subject.call(sess, syncCall("other"), CallPolicy{})
+
+		Expect(records()).To(BeEmpty())
+	})
+})
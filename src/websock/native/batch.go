@@ -0,0 +1,224 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+const (
+	// DefaultMaxBatchDelay is the default upper bound on the batching delay
+	// that a client may request.
+	DefaultMaxBatchDelay = time.Second
+
+	// DefaultMaxBatchCount is the default upper bound on the number of
+	// messages per batch that a client may request.
+	DefaultMaxBatchCount = 1000
+
+	// DefaultMaxBatchBytes is the default upper bound on the size of a batch
+	// that a client may request.
+	DefaultMaxBatchBytes = 1 << 20
+)
+
+// batchWindow describes when a batch of messages is sent.
+type batchWindow struct {
+	maxDelay time.Duration
+	maxCount int
+	maxBytes int
+}
+
+// clamp returns the window requested by m, within the bounds of w.
+func (w batchWindow) clamp(m *message.BatchConfig) batchWindow {
+	r := batchWindow{
+		maxDelay: m.MaxDelay,
+		maxCount: int(m.MaxCount),
+		maxBytes: int(m.MaxBytes),
+	}
+
+	if r.maxDelay > w.maxDelay {
+		r.maxDelay = w.maxDelay
+	}
+
+	if r.maxCount <= 0 || r.maxCount > w.maxCount {
+		r.maxCount = w.maxCount
+	}
+
+	if r.maxBytes <= 0 || r.maxBytes > w.maxBytes {
+		r.maxBytes = w.maxBytes
+	}
+
+	if r.maxCount <= 0 || r.maxCount > math.MaxUint16 {
+		r.maxCount = math.MaxUint16
+	}
+
+	return r
+}
+
+// batcher coalesces notifications and asynchronous responses into Batch
+// messages, once batching has been enabled by the client.
+//
+// Any other message causes the current batch to be sent first, rather than
+// holding batched messages back until the delay elapses.
+//
+// A batch is sent with the highest priority of the messages it contains. When
+// it is sent before another message, it is sent with at least the priority of
+// that message, so that the writer can not reorder them.
+type batcher struct {
+	encoding message.Encoding
+	send     func(message.Outgoing, websock.Priority)
+
+	mutex    sync.Mutex
+	window   batchWindow
+	pending  []message.Outgoing
+	frames   [][]byte
+	size     int
+	priority websock.Priority
+	timer    *time.Timer
+}
+
+func newBatcher(e message.Encoding, send func(message.Outgoing, websock.Priority)) *batcher {
+	return &batcher{
+		encoding: e,
+		send:     send,
+	}
+}
+
+// configure flushes the current batch, then applies the window w to future
+// batches. A zero delay disables batching.
+func (b *batcher) configure(w batchWindow) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.flush()
+	b.window = w
+}
+
+// Send sends m to the client, either immediately or as part of a batch.
+func (b *batcher) Send(m message.Outgoing) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	p := priority(m)
+
+	if b.window.maxDelay <= 0 || !isBatchable(m) {
+		b.flushAt(p)
+		b.send(m, p)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := message.Write(&buf, b.encoding, m); err != nil {
+		return
+	}
+
+	if len(b.frames) != 0 && b.size+buf.Len() > b.window.maxBytes {
+		b.flush()
+	}
+
+	b.pending = append(b.pending, m)
+	b.frames = append(b.frames, buf.Bytes())
+	b.size += buf.Len()
+
+	if p > b.priority {
+		b.priority = p
+	}
+
+	if len(b.frames) >= b.window.maxCount || b.size >= b.window.maxBytes {
+		b.flush()
+	} else if len(b.frames) == 1 {
+		b.timer = time.AfterFunc(b.window.maxDelay, b.expire)
+	}
+}
+
+// stop discards the current batch.
+func (b *batcher) stop() {
+	b.drain()
+}
+
+// drain discards the current batch, and returns the messages it contained.
+func (b *batcher) drain() []message.Outgoing {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+
+	pending := b.pending
+	b.reset()
+
+	return pending
+}
+
+// reset clears the current batch. b.mutex must be held.
+func (b *batcher) reset() {
+	b.pending = nil
+	b.frames = nil
+	b.size = 0
+	b.priority = websock.PriorityNormal
+}
+
+// expire sends the current batch once the maximum delay has elapsed.
+func (b *batcher) expire() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.flush()
+}
+
+// flush sends the current batch, if any. b.mutex must be held.
+func (b *batcher) flush() {
+	b.flushAt(websock.PriorityNormal)
+}
+
+// flushAt sends the current batch, if any, with at least priority p. b.mutex
+// must be held.
+func (b *batcher) flushAt(p websock.Priority) {
+	if len(b.frames) == 0 {
+		return
+	}
+
+	b.timer.Stop()
+
+	if b.priority > p {
+		p = b.priority
+	}
+
+	m := message.NewBatch(b.frames)
+	b.reset()
+
+	b.send(m, p)
+}
+
+// isBatchable returns true if m may be sent as part of a batch.
+func isBatchable(m message.Outgoing) bool {
+	switch m.(type) {
+	case *message.Notification,
+		*message.AsyncSuccess,
+		*message.AsyncFailure,
+		*message.AsyncError,
+		*message.AsyncRejection:
+		return true
+	default:
+		return false
+	}
+}
+
+func (v *visitor) VisitBatchConfig(m *message.BatchConfig) error {
+	v.mutex.RLock()
+	b := v.batcher
+	v.mutex.RUnlock()
+
+	if b == nil {
+		return errors.New("batching is not supported")
+	}
+
+	b.configure(v.batchLimits.clamp(m))
+
+	return nil
+}
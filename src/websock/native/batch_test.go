@@ -0,0 +1,218 @@
+package native
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+var _ = Describe("batcher", func() {
+	var (
+		mutex      sync.Mutex
+		sent       []message.Outgoing
+		priorities []websock.Priority
+		subject    *batcher
+	)
+
+	notification := func(t string) *message.Notification {
+		return message.NewNotification(1, rinq.Notification{Namespace: "ns", Type: t})
+	}
+
+	encode := func(m message.Outgoing) []byte {
+		var buf bytes.Buffer
+		err := message.Write(&buf, message.JSONEncoding, m)
+		Expect(err).ShouldNot(HaveOccurred())
+		return buf.Bytes()
+	}
+
+	received := func() []message.Outgoing {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]message.Outgoing(nil), sent...)
+	}
+
+	sentWith := func() []websock.Priority {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]websock.Priority(nil), priorities...)
+	}
+
+	BeforeEach(func() {
+		sent = nil
+		priorities = nil
+		subject = newBatcher(message.JSONEncoding, func(m message.Outgoing, p websock.Priority) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			sent = append(sent, m)
+			priorities = append(priorities, p)
+		})
+	})
+
+	AfterEach(func() {
+		subject.stop()
+	})
+
+	It("sends messages immediately when batching is disabled", func() {
+		m := notification("a")
+		subject.Send(m)
+
+		Expect(received()).To(ConsistOf(m))
+	})
+
+	Context("when batching is enabled", func() {
+		BeforeEach(func() {
+			subject.configure(batchWindow{
+				maxDelay: 20 * time.Millisecond,
+				maxCount: 3,
+				maxBytes: 1000,
+			})
+		})
+
+		It("sends a batch once the delay elapses", func() {
+			a, b := notification("a"), notification("b")
+			subject.Send(a)
+			subject.Send(b)
+
+			Expect(received()).To(BeEmpty())
+			Eventually(received).Should(ConsistOf(
+				message.NewBatch([][]byte{encode(a), encode(b)}),
+			))
+		})
+
+		It("sends a batch once the maximum count is reached", func() {
+			a, b, c := notification("a"), notification("b"), notification("c")
+			subject.Send(a)
+			subject.Send(b)
+			subject.Send(c)
+
+			Expect(received()).To(ConsistOf(
+				message.NewBatch([][]byte{encode(a), encode(b), encode(c)}),
+			))
+		})
+
+		It("starts a new batch rather than exceed the maximum size", func() {
+			subject.configure(batchWindow{
+				maxDelay: time.Minute,
+				maxCount: 100,
+				maxBytes: 30,
+			})
+
+			a, b := notification("a"), notification("b")
+			subject.Send(a) // 20 bytes
+			subject.Send(b)
+
+			Expect(received()).To(ConsistOf(
+				message.NewBatch([][]byte{encode(a)}),
+			))
+		})
+
+		It("sends the current batch before messages that are not batchable", func() {
+			a := notification("a")
+			d := message.NewSessionDestroy(1)
+			subject.Send(a)
+			subject.Send(d)
+
+			Expect(received()).To(Equal([]message.Outgoing{
+				message.NewBatch([][]byte{encode(a)}),
+				d,
+			}))
+		})
+
+		It("returns the messages in the current batch when drained", func() {
+			subject.configure(batchWindow{
+				maxDelay: time.Minute,
+				maxCount: 100,
+				maxBytes: 1000,
+			})
+
+			a, b := notification("a"), notification("b")
+			subject.Send(a)
+			subject.Send(b)
+
+			Expect(subject.drain()).To(Equal([]message.Outgoing{a, b}))
+			Consistently(received).Should(BeEmpty())
+		})
+
+		It("sends batches of notifications with normal priority", func() {
+			subject.Send(notification("a"))
+
+			Eventually(sentWith).Should(Equal([]websock.Priority{
+				websock.PriorityNormal,
+			}))
+		})
+
+		It("sends batches containing asynchronous responses with high priority", func() {
+			subject.Send(notification("a"))
+			subject.Send(message.NewAsyncRejection(1, "ns", "cmd", message.RejectOverloaded))
+
+			Eventually(sentWith).Should(Equal([]websock.Priority{
+				websock.PriorityHigh,
+			}))
+		})
+
+		It("sends the current batch with the priority of the message that follows it", func() {
+			subject.Send(notification("a"))
+			subject.Send(message.NewSessionDestroy(1))
+
+			Expect(sentWith()).To(Equal([]websock.Priority{
+				websock.PriorityHigh,
+				websock.PriorityHigh,
+			}))
+		})
+	})
+})
+
+var _ = Describe("batchWindow", func() {
+	limits := batchWindow{
+		maxDelay: time.Second,
+		maxCount: 100,
+		maxBytes: 1000,
+	}
+
+	config := func(delay time.Duration, count, bytes uint) *message.BatchConfig {
+		m := &message.BatchConfig{}
+		m.MaxDelay = delay
+		m.MaxCount = count
+		m.MaxBytes = bytes
+		return m
+	}
+
+	Describe("clamp", func() {
+		It("returns the requested window if it is within the limits", func() {
+			w := limits.clamp(config(time.Millisecond, 10, 100))
+			Expect(w).To(Equal(batchWindow{time.Millisecond, 10, 100}))
+		})
+
+		It("reduces the requested window to the limits", func() {
+			w := limits.clamp(config(time.Minute, 1000, 10000))
+			Expect(w).To(Equal(limits))
+		})
+
+		It("uses the limits when no count or size is requested", func() {
+			w := limits.clamp(config(time.Millisecond, 0, 0))
+			Expect(w).To(Equal(batchWindow{time.Millisecond, 100, 1000}))
+		})
+	})
+})
+
+var _ = Describe("visitor batching", func() {
+	It("applies the requested batching window", func() {
+		v := newVisitor(nil, nil, nil, nil)
+		v.batcher = newBatcher(message.JSONEncoding, nil)
+		MaxBatchWindow(time.Second, 10, 100).modify(v)
+
+		m := &message.BatchConfig{}
+		m.MaxDelay = time.Minute
+
+		err := v.VisitBatchConfig(m)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(v.batcher.window).To(Equal(batchWindow{time.Second, 10, 100}))
+	})
+})
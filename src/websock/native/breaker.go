@@ -0,0 +1,147 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"expvar"
+
+	"github.com/rinq/httpd/src/internal/breaker"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// breakerMetrics contains the state of each namespace's circuit breaker, and
+// the number of times each has tripped.
+var breakerMetrics = expvar.NewMap("rinq_httpd_circuit_breakers")
+
+// CircuitBreakers maintains a circuit breaker for each of a fixed set of
+// namespaces. While a namespace's breaker is open, sync calls to that
+// namespace are rejected immediately, rather than waiting for the call to
+// time out. Calls to other namespaces are not protected.
+//
+// Only errors that indicate a problem with the namespace's command handlers
+// count towards tripping the breaker. Application-level failures, calls that
+// are canceled, and timeouts that are shorter than the namespace's default
+// timeout are not counted.
+//
+// Handlers that use the same CircuitBreakers share the state of each breaker,
+// so calls made by clients of any Handler trip, and are rejected by, the same
+// breaker.
+type CircuitBreakers struct {
+	breakers map[string]*breaker.Breaker
+}
+
+// NewCircuitBreakers returns circuit breakers that use the given
+// configuration for each of the given namespaces.
+func NewCircuitBreakers(c breaker.Config, namespaces ...string) *CircuitBreakers {
+	b := &CircuitBreakers{
+		breakers: make(map[string]*breaker.Breaker, len(namespaces)),
+	}
+
+	for _, ns := range namespaces {
+		if _, ok := b.breakers[ns]; !ok {
+			b.breakers[ns] = newBreaker(c, ns)
+		}
+	}
+
+	return b
+}
+
+// newBreaker returns a breaker for ns that publishes its state via expvar.
+func newBreaker(c breaker.Config, ns string) *breaker.Breaker {
+	state := new(expvar.String)
+	state.Set(breaker.Closed.String())
+	breakerMetrics.Set(ns+".state", state)
+
+	c.OnStateChange = func(_, to breaker.State) {
+		state.Set(to.String())
+		if to == breaker.Open {
+			breakerMetrics.Add(ns+".trips", 1)
+		}
+	}
+
+	return breaker.New(c)
+}
+
+// States returns the state of the circuit breaker for each namespace.
+func (b *CircuitBreakers) States() map[string]breaker.State {
+	states := make(map[string]breaker.State, len(b.breakers))
+	for ns, br := range b.breakers {
+		states[ns] = br.State()
+	}
+
+	return states
+}
+
+// get returns the breaker for ns, if it has one.
+func (b *CircuitBreakers) get(ns string) (*breaker.Breaker, bool) {
+	br, ok := b.breakers[ns]
+	return br, ok
+}
+
+// outcome returns the outcome of an attempt to make a call that returned err,
+// as recorded by the circuit breaker of its namespace. call and attempt are
+// the contexts of the call as a whole and of the attempt, which differ if the
+// call is retried.
+func (v *visitor) outcome(
+	call, attempt context.Context,
+	m *message.SyncCall,
+	err error,
+) breaker.Outcome {
+	switch err.(type) {
+	case nil, rinq.Failure:
+		return breaker.Success
+	}
+
+	switch err {
+	case context.Canceled:
+		return breaker.Ignored
+
+	case context.DeadlineExceeded:
+		// the attempt timed out, the call is either retried or fails with
+		// the error of the last attempt
+		if attempt.Err() != nil && call.Err() == nil {
+			return breaker.Ignored
+		}
+
+		// the client did not allow the time the command normally takes
+		policy := v.policies.lookup(m.Namespace, m.Command)
+		if v.callTimeout(policy, m.Timeout) < v.callTimeout(policy, 0) {
+			return breaker.Ignored
+		}
+	}
+
+	return breaker.Failure
+}
+
+// errUnavailable indicates that a call was not made because the circuit
+// breaker for its namespace is open.
+var errUnavailable = errors.New("namespace is unavailable")
+
+// invoke calls the command within ctx, unless the circuit breaker for its
+// namespace is open. call is the context of the call as a whole, which differs
+// from ctx if the call is retried.
+func (v *visitor) invoke(
+	call, ctx context.Context,
+	sess rinq.Session,
+	m *message.SyncCall,
+) (*rinq.Payload, error) {
+	if v.breakers == nil {
+		return sess.Call(ctx, m.Namespace, m.Command, m.Payload)
+	}
+
+	br, ok := v.breakers.get(m.Namespace)
+	if !ok {
+		return sess.Call(ctx, m.Namespace, m.Command, m.Payload)
+	}
+
+	done, ok := br.Allow()
+	if !ok {
+		return nil, errUnavailable
+	}
+
+	p, err := sess.Call(ctx, m.Namespace, m.Command, m.Payload)
+	done(v.outcome(call, ctx, m, err))
+
+	return p, err
+}
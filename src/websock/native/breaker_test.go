@@ -0,0 +1,139 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/internal/breaker"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+var _ = Describe("visitor circuit breakers", func() {
+	var (
+		callErr error
+		sess    *mock.Session
+		sent    []message.Outgoing
+		subject *visitor
+	)
+
+	syncCall := func(ns string) *message.SyncCall {
+		m := &message.SyncCall{}
+		m.Session = 1
+		m.Seq = 123
+		m.Namespace = ns
+		m.Command = "cmd"
+		m.Timeout = time.Second
+		return m
+	}
+
+	BeforeEach(func() {
+		callErr = errors.New("transport error")
+		sess = mock.NewSession(1)
+		sess.Impl.Call = func(ctx context.Context, _, _ string, _ *rinq.Payload) (*rinq.Payload, error) {
+			if callErr == context.DeadlineExceeded {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+
+			return nil, callErr
+		}
+
+		sent = nil
+		subject = newVisitor(context.Background(), nil, nil, func(m message.Outgoing) {
+			sent = append(sent, m)
+		})
+
+		Breakers(NewCircuitBreakers(breaker.Config{
+			Window:       time.Minute,
+			MinRequests:  2,
+			FailureRatio: 1,
+			OpenTimeout:  time.Minute,
+		}, "ns")).modify(subject)
+	})
+
+	It("rejects calls while the namespace's breaker is open", func() {
+		subject.call(sess, syncCall("ns"), CallPolicy{})
+		subject.call(sess, syncCall("ns"), CallPolicy{})
+		subject.call(sess, syncCall("ns"), CallPolicy{})
+
+		Expect(sent).To(ConsistOf(
+			message.NewSyncRejection(1, 123, message.RejectUnavailable),
+		))
+		Expect(subject.breakers.States()).To(Equal(map[string]breaker.State{
+			"ns": breaker.Open,
+		}))
+	})
+
+	It("does not reject calls to other namespaces", func() {
+		subject.call(sess, syncCall("ns"), CallPolicy{})
+		subject.call(sess, syncCall("ns"), CallPolicy{})
+
+		callErr = nil
+		subject.call(sess, syncCall("other"), CallPolicy{})
+
+		Expect(sent).To(HaveLen(1))
+		Expect(sent[0]).To(BeAssignableToTypeOf(&message.SyncSuccess{}))
+	})
+
+	It("does not maintain breakers for namespaces that are not configured", func() {
+		subject.call(sess, syncCall("other"), CallPolicy{})
+		subject.call(sess, syncCall("other"), CallPolicy{})
+		subject.call(sess, syncCall("other"), CallPolicy{})
+
+		Expect(sent).ToNot(ContainElement(
+			message.NewSyncRejection(1, 123, message.RejectUnavailable),
+		))
+		Expect(subject.breakers.States()).To(Equal(map[string]breaker.State{
+			"ns": breaker.Closed,
+		}))
+	})
+
+	It("counts timeouts of calls made with the default timeout", func() {
+		callErr = context.DeadlineExceeded
+
+		m := syncCall("ns")
+		m.Timeout = 0
+		policy := CallPolicy{DefaultTimeout: 10 * time.Millisecond}
+
+		subject.call(sess, m, policy)
+		subject.call(sess, m, policy)
+
+		Expect(subject.breakers.States()["ns"]).To(Equal(breaker.Open))
+	})
+
+	DescribeTable(
+		"does not count errors that are not caused by the namespace's handlers",
+		func(err error, timeout time.Duration, policy CallPolicy) {
+			callErr = err
+
+			m := syncCall("ns")
+			m.Timeout = timeout
+
+			subject.call(sess, m, policy)
+			subject.call(sess, m, policy)
+			subject.call(sess, m, policy)
+
+			Expect(subject.breakers.States()["ns"]).To(Equal(breaker.Closed))
+		},
+		Entry("application failures", rinq.Failure{Type: "type"}, time.Second, CallPolicy{}),
+		Entry("cancellations", context.Canceled, time.Second, CallPolicy{}),
+		Entry(
+			"timeouts shorter than the default",
+			context.DeadlineExceeded,
+			10*time.Millisecond,
+			CallPolicy{DefaultTimeout: time.Second},
+		),
+		Entry(
+			"attempt timeouts",
+			context.DeadlineExceeded,
+			time.Second,
+			CallPolicy{Retry: &RetryPolicy{MaxAttempts: 1, AttemptTimeout: time.Millisecond}},
+		),
+	)
+})
@@ -0,0 +1,268 @@
+package native
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// cacheMetrics contains the counters for all response caches.
+var cacheMetrics = expvar.NewMap("rinq_httpd_response_cache")
+
+// cacheEntryOverhead is the approximate memory used by each cache entry, in
+// addition to the response payload.
+const cacheEntryOverhead = 128
+
+// CacheRule enables caching of the responses to a command.
+type CacheRule struct {
+	// Namespace is the namespace of the command.
+	Namespace string
+
+	// Command is the name of the command, or "*" to match all commands in the
+	// namespace.
+	Command string
+
+	// TTL is the time for which responses are cached.
+	TTL time.Duration
+}
+
+// ResponseCache caches the successful responses to idempotent commands, keyed
+// by the namespace, command and request payload. Concurrent identical calls
+// are coalesced, such that they share a single call to Rinq.
+//
+// Responses are shared between all clients, regardless of their session
+// attributes, so caching must only be enabled for commands whose response
+// depends solely on the request payload.
+//
+// Handlers that use the same cache share its entries and its byte limit, and
+// identical calls made via different Handlers are coalesced.
+type ResponseCache struct {
+	maxBytes int
+	rules    map[string]time.Duration
+
+	mutex   sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     list.List
+	size    int
+	flights map[cacheKey]*flight
+}
+
+// cacheKey identifies a cached response.
+type cacheKey struct {
+	Namespace string
+	Command   string
+	Payload   [sha256.Size]byte
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	key     cacheKey
+	payload *rinq.Payload
+	expires time.Time
+	size    int
+}
+
+// flight is a call to Rinq that is shared by concurrent identical calls.
+//
+// The payload is a clone of the response that is owned by the flight. Each
+// waiter receives its own clone of it, and it is closed once every waiter has
+// done so.
+type flight struct {
+	done    chan struct{}
+	payload *rinq.Payload
+	err     error
+
+	// the remaining fields are protected by the cache's mutex
+	completed bool
+	waiters   int
+}
+
+// NewResponseCache returns a cache that holds up to maxBytes of responses to
+// the commands matched by rules.
+func NewResponseCache(maxBytes int, rules ...CacheRule) *ResponseCache {
+	c := &ResponseCache{
+		maxBytes: maxBytes,
+		rules:    map[string]time.Duration{},
+		entries:  map[cacheKey]*list.Element{},
+		flights:  map[cacheKey]*flight{},
+	}
+
+	for _, r := range rules {
+		c.rules[r.Namespace+"::"+r.Command] = r.TTL
+	}
+
+	return c
+}
+
+// ttl returns the TTL for responses to the given command, and false if they
+// are not cached.
+func (c *ResponseCache) ttl(ns, cmd string) (time.Duration, bool) {
+	if ttl, ok := c.rules[ns+"::"+cmd]; ok {
+		return ttl, true
+	}
+
+	ttl, ok := c.rules[ns+"::*"]
+	return ttl, ok
+}
+
+// call returns the cached response to the given command, if any. Otherwise,
+// it returns the result of fn, which is shared with any identical calls made
+// while it is in progress.
+//
+// fn is invoked with the context of the call that started the flight. If that
+// context is canceled or its deadline is exceeded, the identical calls that
+// are still waiting make the call again, rather than failing with the other
+// call's error.
+func (c *ResponseCache) call(
+	ctx context.Context,
+	ns, cmd string,
+	p *rinq.Payload,
+	ttl time.Duration,
+	fn func() (*rinq.Payload, error),
+) (*rinq.Payload, error) {
+	k := cacheKey{ns, cmd, sha256.Sum256(p.Bytes())}
+
+	for {
+		r, ok, err := c.attempt(ctx, k, ttl, fn)
+		if ok {
+			return r, err
+		}
+	}
+}
+
+// attempt returns the cached response for k, if any. Otherwise, it invokes fn
+// or waits for an identical call that is already in progress. It returns false
+// if it waited for a call that failed due to that call's context, in which
+// case the call should be attempted again.
+func (c *ResponseCache) attempt(
+	ctx context.Context,
+	k cacheKey,
+	ttl time.Duration,
+	fn func() (*rinq.Payload, error),
+) (*rinq.Payload, bool, error) {
+	c.mutex.Lock()
+
+	if e, ok := c.entries[k]; ok {
+		entry := e.Value.(*cacheEntry)
+
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(e)
+			c.mutex.Unlock()
+
+			cacheMetrics.Add("hits", 1)
+			return entry.payload.Clone(), true, nil
+		}
+
+		c.remove(e)
+	}
+
+	if f, ok := c.flights[k]; ok {
+		f.waiters++
+		c.mutex.Unlock()
+
+		cacheMetrics.Add("coalesced", 1)
+
+		return c.wait(ctx, f)
+	}
+
+	f := &flight{done: make(chan struct{})}
+	c.flights[k] = f
+
+	c.mutex.Unlock()
+
+	cacheMetrics.Add("misses", 1)
+
+	r, err := fn()
+
+	c.mutex.Lock()
+	delete(c.flights, k)
+	if err == nil {
+		c.add(k, r.Clone(), ttl)
+	}
+
+	f.payload = r.Clone()
+	f.err = err
+	f.completed = true
+	f.release()
+	c.mutex.Unlock()
+
+	close(f.done)
+
+	return r, true, err
+}
+
+// wait blocks until f is complete, then returns a clone of its result. It
+// returns false if f failed due to the context of the call that started it,
+// and ctx is not done.
+func (c *ResponseCache) wait(ctx context.Context, f *flight) (*rinq.Payload, bool, error) {
+	var err error
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	f.waiters--
+	defer f.release()
+
+	if err != nil {
+		return nil, true, err
+	}
+
+	if f.err == context.Canceled || f.err == context.DeadlineExceeded {
+		if ctx.Err() == nil {
+			return nil, false, nil
+		}
+	}
+
+	return f.payload.Clone(), true, f.err
+}
+
+// release closes the payload of f once it is complete and every waiter has
+// received its result. c.mutex must be held.
+func (f *flight) release() {
+	if f.completed && f.waiters == 0 {
+		f.payload.Close()
+	}
+}
+
+// add adds a response to the cache, evicting the least recently used entries
+// as necessary. c.mutex must be held.
+func (c *ResponseCache) add(k cacheKey, p *rinq.Payload, ttl time.Duration) {
+	entry := &cacheEntry{
+		key:     k,
+		payload: p,
+		expires: time.Now().Add(ttl),
+		size:    p.Len() + cacheEntryOverhead,
+	}
+
+	if entry.size > c.maxBytes {
+		return
+	}
+
+	c.entries[k] = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+		cacheMetrics.Add("evictions", 1)
+	}
+}
+
+// remove removes e from the cache. c.mutex must be held.
+func (c *ResponseCache) remove(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+
+	c.lru.Remove(e)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
@@ -0,0 +1,231 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+var _ = Describe("ResponseCache", func() {
+	var (
+		calls   int32
+		subject *ResponseCache
+	)
+
+	call := func(payload interface{}, result interface{}) (*rinq.Payload, error) {
+		return subject.call(
+			context.Background(),
+			"ns",
+			"cmd",
+			rinq.NewPayload(payload),
+			time.Minute,
+			func() (*rinq.Payload, error) {
+				atomic.AddInt32(&calls, 1)
+				return rinq.NewPayload(result), nil
+			},
+		)
+	}
+
+	BeforeEach(func() {
+		calls = 0
+		subject = NewResponseCache(
+			1000,
+			CacheRule{"ns", "cmd", time.Minute},
+			CacheRule{"other", "*", time.Second},
+		)
+	})
+
+	Describe("ttl", func() {
+		It("returns the TTL for a specific command", func() {
+			ttl, ok := subject.ttl("ns", "cmd")
+			Expect(ok).To(BeTrue())
+			Expect(ttl).To(Equal(time.Minute))
+		})
+
+		It("returns the TTL for a namespace wildcard", func() {
+			ttl, ok := subject.ttl("other", "cmd")
+			Expect(ok).To(BeTrue())
+			Expect(ttl).To(Equal(time.Second))
+		})
+
+		It("returns false for commands that are not cached", func() {
+			_, ok := subject.ttl("ns", "uncached")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("call", func() {
+		It("returns cached responses to identical calls", func() {
+			call("request", "a")
+			p, err := call("request", "b")
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(p.Value()).To(Equal("a"))
+			Expect(calls).To(BeEquivalentTo(1))
+		})
+
+		It("does not return cached responses to calls with a different payload", func() {
+			call("request", "a")
+			p, _ := call("other-request", "b")
+
+			Expect(p.Value()).To(Equal("b"))
+			Expect(calls).To(BeEquivalentTo(2))
+		})
+
+		It("does not cache errors", func() {
+			fail := func() (*rinq.Payload, error) {
+				atomic.AddInt32(&calls, 1)
+				return nil, errors.New("failed")
+			}
+
+			subject.call(context.Background(), "ns", "cmd", nil, time.Minute, fail)
+			subject.call(context.Background(), "ns", "cmd", nil, time.Minute, fail)
+
+			Expect(calls).To(BeEquivalentTo(2))
+		})
+
+		It("expires cached responses after the TTL", func() {
+			fn := func() (*rinq.Payload, error) {
+				atomic.AddInt32(&calls, 1)
+				return nil, nil
+			}
+
+			subject.call(context.Background(), "ns", "cmd", nil, time.Millisecond, fn)
+			time.Sleep(5 * time.Millisecond)
+			subject.call(context.Background(), "ns", "cmd", nil, time.Millisecond, fn)
+
+			Expect(calls).To(BeEquivalentTo(2))
+		})
+
+		It("evicts the least recently used responses when full", func() {
+			subject = NewResponseCache(3 * (cacheEntryOverhead + 8))
+
+			call("a", "a")
+			call("b", "b")
+			call("c", "c")
+			call("a", "a")
+			call("d", "d")
+			Expect(calls).To(BeEquivalentTo(4))
+
+			call("a", "a")
+			Expect(calls).To(BeEquivalentTo(4))
+
+			call("b", "b")
+			Expect(calls).To(BeEquivalentTo(5))
+		})
+
+		It("coalesces concurrent identical calls", func() {
+			release := make(chan struct{})
+			fn := func() (*rinq.Payload, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return rinq.NewPayload("result"), nil
+			}
+
+			done := make(chan *rinq.Payload, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					p, _ := subject.call(context.Background(), "ns", "cmd", nil, time.Minute, fn)
+					done <- p
+				}()
+			}
+
+			Eventually(func() int {
+				subject.mutex.Lock()
+				defer subject.mutex.Unlock()
+				return len(subject.flights)
+			}).Should(Equal(1))
+			Consistently(func() int32 { return atomic.LoadInt32(&calls) }).Should(BeEquivalentTo(1))
+
+			close(release)
+
+			Eventually(done).Should(Receive())
+			Eventually(done).Should(Receive())
+			Expect(calls).To(BeEquivalentTo(1))
+		})
+
+		Context("when a call is in progress", func() {
+			var (
+				release chan struct{}
+				first   chan *rinq.Payload
+			)
+
+			// begin starts a call that returns result and err once release is
+			// closed, then waits for another identical call to join it.
+			begin := func(result *rinq.Payload, err error) {
+				release = make(chan struct{})
+				first = make(chan *rinq.Payload, 1)
+
+				go func() {
+					p, _ := subject.call(context.Background(), "ns", "cmd", nil, time.Minute, func() (*rinq.Payload, error) {
+						atomic.AddInt32(&calls, 1)
+						<-release
+						return result, err
+					})
+					first <- p
+				}()
+
+				Eventually(func() int {
+					subject.mutex.Lock()
+					defer subject.mutex.Unlock()
+					return len(subject.flights)
+				}).Should(Equal(1))
+			}
+
+			// join makes an identical call that returns result if it is invoked.
+			join := func(result string) chan *rinq.Payload {
+				done := make(chan *rinq.Payload, 1)
+
+				go func() {
+					p, _ := subject.call(context.Background(), "ns", "cmd", nil, time.Minute, func() (*rinq.Payload, error) {
+						atomic.AddInt32(&calls, 1)
+						return rinq.NewPayload(result), nil
+					})
+					done <- p
+				}()
+
+				Eventually(func() int {
+					subject.mutex.Lock()
+					defer subject.mutex.Unlock()
+					for _, f := range subject.flights {
+						return f.waiters
+					}
+					return 0
+				}).Should(Equal(1))
+
+				return done
+			}
+
+			It("gives each coalesced call its own copy of the response", func() {
+				begin(rinq.NewPayload("result"), nil)
+				done := join("other")
+
+				close(release)
+
+				var p *rinq.Payload
+				Eventually(first).Should(Receive(&p))
+				p.Close()
+
+				Eventually(done).Should(Receive(&p))
+				Expect(p.Value()).To(Equal("result"))
+			})
+
+			It("makes the call again if the first call's context is canceled", func() {
+				begin(nil, context.Canceled)
+				done := join("result")
+
+				close(release)
+
+				var p *rinq.Payload
+				Eventually(done).Should(Receive(&p))
+				Expect(p.Value()).To(Equal("result"))
+				Expect(calls).To(BeEquivalentTo(2))
+			})
+		})
+	})
+})
@@ -0,0 +1,238 @@
+package native
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+// conflationKey identifies the notifications that replace one another when
+// conflation is enabled.
+type conflationKey struct {
+	Session   message.SessionIndex
+	BySession bool
+	Namespace string
+	Type      string
+}
+
+// listenKey identifies a namespace that a session is listening to.
+type listenKey struct {
+	Session   message.SessionIndex
+	Namespace string
+}
+
+// conflator delivers conflated notifications from a single goroutine.
+//
+// Notifications are held until the messages queued on the connection have
+// been written to the client, as signalled by the idle channel. Until then,
+// newer notifications replace pending notifications with the same key, rather
+// than accumulating in the queue.
+//
+// Conflated notifications are delivered independently of other messages, and
+// so may be reordered with respect to them.
+type conflator struct {
+	send     func(message.Outgoing)
+	wake     chan struct{}
+	attached chan struct{}
+
+	mutex   sync.Mutex
+	pending map[conflationKey]*message.Notification
+	order   []conflationKey
+	idle    <-chan struct{}
+}
+
+func newConflator(send func(message.Outgoing), idle <-chan struct{}) *conflator {
+	return &conflator{
+		send:     send,
+		wake:     make(chan struct{}, 1),
+		attached: make(chan struct{}, 1),
+		pending:  map[conflationKey]*message.Notification{},
+		idle:     idle,
+	}
+}
+
+// alwaysIdle is an idle channel that is always ready to receive, used for
+// connections that do not report when they are idle.
+var alwaysIdle = func() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// idleChannel returns the channel that signals when c's outbound queue is
+// empty.
+func idleChannel(c websock.Connection) <-chan struct{} {
+	if i, ok := c.(websock.Idler); ok {
+		return i.Idle()
+	}
+
+	return alwaysIdle
+}
+
+// setIdle replaces the channel that signals when notifications may be sent.
+// A nil channel holds notifications until it is replaced.
+func (c *conflator) setIdle(idle <-chan struct{}) {
+	c.mutex.Lock()
+	c.idle = idle
+	c.mutex.Unlock()
+
+	select {
+	case c.attached <- struct{}{}:
+	default:
+	}
+}
+
+// waitIdle blocks until notifications may be sent. It returns false if ctx is
+// canceled first.
+func (c *conflator) waitIdle(ctx context.Context) bool {
+	for {
+		c.mutex.Lock()
+		idle := c.idle
+		c.mutex.Unlock()
+
+		select {
+		case <-idle:
+			return true
+		case <-c.attached:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// push adds m to the pending notifications, replacing any pending notification
+// with the same key.
+func (c *conflator) push(k conflationKey, m *message.Notification) {
+	c.mutex.Lock()
+
+	if prev, ok := c.pending[k]; ok {
+		m.Dropped = prev.Dropped + 1
+	} else {
+		c.order = append(c.order, k)
+	}
+
+	c.pending[k] = m
+
+	c.mutex.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the oldest pending notification.
+func (c *conflator) pop() (*message.Notification, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.order) == 0 {
+		return nil, false
+	}
+
+	k := c.order[0]
+	c.order = c.order[1:]
+
+	m := c.pending[k]
+	delete(c.pending, k)
+
+	return m, true
+}
+
+// run sends pending notifications until ctx is canceled.
+func (c *conflator) run(ctx context.Context) {
+	for {
+		select {
+		case <-c.wake:
+		case <-ctx.Done():
+			return
+		}
+
+		if !c.waitIdle(ctx) {
+			return
+		}
+
+		for {
+			m, ok := c.pop()
+			if !ok {
+				break
+			}
+
+			c.send(m)
+		}
+	}
+}
+
+// setConflation sets the conflation mode for notifications received by
+// session i in namespace ns.
+func (v *visitor) setConflation(i message.SessionIndex, ns string, mode message.ConflateMode) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	k := listenKey{i, ns}
+
+	if mode == message.ConflateNone {
+		delete(v.conflation, k)
+		return
+	}
+
+	if v.conflation == nil {
+		v.conflation = map[listenKey]message.ConflateMode{}
+	}
+
+	if v.conflator == nil {
+		v.conflator = newConflator(v.send, v.idle)
+		go v.conflator.run(v.context)
+	}
+
+	v.conflation[k] = mode
+}
+
+// setIdle sets the channel that signals when the client's connection can
+// accept more notifications. A nil channel holds conflated notifications until
+// it is replaced, such as while the client is disconnected.
+func (v *visitor) setIdle(idle <-chan struct{}) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.setIdleLocked(idle)
+}
+
+// setIdleLocked sets the channel that signals when the client's connection
+// can accept more notifications. v.mutex must be held for writing.
+func (v *visitor) setIdleLocked(idle <-chan struct{}) {
+	v.idle = idle
+
+	if v.conflator != nil {
+		v.conflator.setIdle(idle)
+	}
+}
+
+// clearConflation removes the conflation modes of session i.
+func (v *visitor) clearConflation(i message.SessionIndex) {
+	for k := range v.conflation {
+		if k.Session == i {
+			delete(v.conflation, k)
+		}
+	}
+}
+
+// sendNotification sends m to the client, conflating it with other pending
+// notifications if the session is listening with conflation enabled.
+func (v *visitor) sendNotification(m *message.Notification) {
+	v.mutex.RLock()
+	mode := v.conflation[listenKey{m.Session, m.Namespace}]
+	c := v.conflator
+	v.mutex.RUnlock()
+
+	switch mode {
+	case message.ConflateType:
+		c.push(conflationKey{Namespace: m.Namespace, Type: m.Type}, m)
+	case message.ConflateSession:
+		c.push(conflationKey{m.Session, true, m.Namespace, m.Type}, m)
+	default:
+		v.send(m)
+	}
+}
@@ -0,0 +1,286 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+var _ = Describe("visitor notification conflation", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		sent    chan message.Outgoing
+		subject *visitor
+	)
+
+	notification := func(session message.SessionIndex, ns, t string) *message.Notification {
+		return message.NewNotification(session, rinq.Notification{
+			Namespace: ns,
+			Type:      t,
+		})
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+
+		// sent is unbuffered, so the outbound path is backed up until the test
+		// receives from it
+		sent = make(chan message.Outgoing)
+		subject = newVisitor(ctx, nil, nil, func(m message.Outgoing) {
+			select {
+			case sent <- m:
+			case <-ctx.Done():
+			}
+		})
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("sends notifications directly when conflation is not enabled", func() {
+		m := notification(1, "ns", "type")
+		go subject.sendNotification(m)
+
+		Eventually(sent).Should(Receive(Equal(m)))
+	})
+
+	Context("when conflating by type", func() {
+		BeforeEach(func() {
+			subject.setConflation(1, "ns", message.ConflateType)
+			subject.setConflation(2, "ns", message.ConflateType)
+		})
+
+		It("replaces pending notifications with the same type", func() {
+			first := notification(1, "ns", "type")
+			subject.sendNotification(first)
+
+			// the first notification is now blocked in send()
+			Eventually(func() int {
+				subject.conflator.mutex.Lock()
+				defer subject.conflator.mutex.Unlock()
+				return len(subject.conflator.pending)
+			}).Should(Equal(0))
+
+			subject.sendNotification(notification(1, "ns", "type"))
+			subject.sendNotification(notification(2, "ns", "type"))
+			last := notification(1, "ns", "type")
+			subject.sendNotification(last)
+
+			Eventually(sent).Should(Receive(Equal(first)))
+
+			var m message.Outgoing
+			Eventually(sent).Should(Receive(&m))
+			Expect(m).To(BeIdenticalTo(last))
+			Expect(last.Dropped).To(BeEquivalentTo(2))
+		})
+
+		It("does not replace notifications with a different type", func() {
+			a := notification(1, "ns", "a")
+			b := notification(1, "ns", "b")
+
+			subject.sendNotification(a)
+			subject.sendNotification(b)
+
+			Eventually(sent).Should(Receive(Equal(a)))
+			Eventually(sent).Should(Receive(Equal(b)))
+			Expect(b.Dropped).To(BeZero())
+		})
+	})
+
+	Context("when the connection is not idle", func() {
+		var idle chan struct{}
+
+		BeforeEach(func() {
+			idle = make(chan struct{})
+			subject.setIdle(idle)
+			subject.setConflation(1, "ns", message.ConflateType)
+		})
+
+		It("delivers only the latest notification once the connection is idle", func() {
+			subject.sendNotification(notification(1, "ns", "type"))
+			subject.sendNotification(notification(1, "ns", "type"))
+			last := notification(1, "ns", "type")
+			subject.sendNotification(last)
+
+			Consistently(sent).ShouldNot(Receive())
+
+			idle <- struct{}{}
+
+			var m message.Outgoing
+			Eventually(sent).Should(Receive(&m))
+			Expect(m).To(BeIdenticalTo(last))
+			Expect(last.Dropped).To(BeEquivalentTo(2))
+
+			Consistently(sent).ShouldNot(Receive())
+		})
+
+		It("holds notifications while there is no connection", func() {
+			subject.setIdle(nil)
+
+			m := notification(1, "ns", "type")
+			subject.sendNotification(m)
+
+			Consistently(sent).ShouldNot(Receive())
+
+			subject.setIdle(alwaysIdle)
+
+			Eventually(sent).Should(Receive(Equal(m)))
+		})
+	})
+
+	Context("when conflating by session", func() {
+		BeforeEach(func() {
+			subject.setConflation(1, "ns", message.ConflateSession)
+			subject.setConflation(2, "ns", message.ConflateSession)
+		})
+
+		It("does not replace notifications received by other sessions", func() {
+			blocker := notification(1, "ns", "blocker")
+			subject.sendNotification(blocker)
+			Eventually(sent).Should(Receive(Equal(blocker)))
+
+			a := notification(1, "ns", "type")
+			b := notification(2, "ns", "type")
+			subject.sendNotification(a)
+			subject.sendNotification(b)
+
+			Eventually(sent).Should(Receive(Equal(a)))
+			Eventually(sent).Should(Receive(Equal(b)))
+			Expect(b.Dropped).To(BeZero())
+		})
+	})
+
+	It("stops conflating once the session stops listening", func() {
+		subject.setConflation(1, "ns", message.ConflateType)
+		subject.setConflation(1, "ns", message.ConflateNone)
+
+		Expect(subject.conflation).To(BeEmpty())
+	})
+
+	It("clears the conflation modes of destroyed sessions", func() {
+		subject.setConflation(1, "ns", message.ConflateType)
+		subject.setConflation(2, "ns", message.ConflateType)
+		subject.clearConflation(1)
+
+		Expect(subject.conflation).To(Equal(map[listenKey]message.ConflateMode{
+			{2, "ns"}: message.ConflateType,
+		}))
+	})
+})
+
+var _ = Describe("handler notification conflation", func() {
+	var (
+		sess   *mock.Session
+		server *httptest.Server
+		client *websocket.Conn
+		frames chan string
+	)
+
+	// frame returns an incoming message with the given type, session index,
+	// JSON header and JSON payload. Empty parts are omitted.
+	frame := func(t string, session uint16, header, payload string) []byte {
+		buf := &bytes.Buffer{}
+		buf.WriteString(t)
+
+		if session != 0 {
+			_ = binary.Write(buf, binary.BigEndian, session)
+		}
+
+		if header != "" {
+			_ = binary.Write(buf, binary.BigEndian, uint16(len(header)))
+			buf.WriteString(header)
+		}
+
+		buf.WriteString(payload)
+
+		return buf.Bytes()
+	}
+
+	BeforeEach(func() {
+		sess = mock.NewSession(1)
+
+		peer := &mock.Peer{}
+		peer.Impl.Session = func() rinq.Session { return sess }
+
+		origins, err := websock.ParseOriginPatterns("*")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		server = httptest.NewServer(
+			websock.NewHTTPHandler(
+				origins,
+				time.Minute,
+				1024,
+				nil,
+				NewHandler(peer, message.JSONEncoding),
+			),
+		)
+
+		url := strings.Replace(server.URL, "http://", "ws://", 1)
+		d := websocket.Dialer{Subprotocols: []string{"rinq-1.0+json"}}
+		client, _, err = d.Dial(url, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		frames = make(chan string, 10)
+		go func() {
+			defer close(frames)
+			for {
+				_, buf, err := client.ReadMessage()
+				if err != nil {
+					return
+				}
+				frames <- string(buf)
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+
+	It("delivers conflated notifications after a batch is sent with a response", func() {
+		sess.Impl.Call = func(context.Context, string, string, *rinq.Payload) (*rinq.Payload, error) {
+			sess.Deliver(rinq.Notification{
+				Namespace: "ns",
+				Type:      "first",
+				Payload:   rinq.NewPayload(1),
+			})
+
+			// allow the first notification to be added to the batch, such
+			// that it is sent along with the response
+			time.Sleep(20 * time.Millisecond)
+
+			sess.Deliver(rinq.Notification{
+				Namespace: "ns",
+				Type:      "second",
+				Payload:   rinq.NewPayload(2),
+			})
+
+			return rinq.NewPayload("ok"), nil
+		}
+
+		for _, f := range [][]byte{
+			frame("SC", 1, "", ""),
+			frame("BC", 0, `[100,0,0]`, ""),
+			frame("NL", 1, `[["ns"],"type"]`, ""),
+			frame("CC", 1, `[1,"ns","cmd",1000,"",""]`, `null`),
+		} {
+			Expect(client.WriteMessage(websocket.BinaryMessage, f)).To(Succeed())
+		}
+
+		Eventually(frames, time.Second).Should(Receive(ContainSubstring("second")))
+	})
+})
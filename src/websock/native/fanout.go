@@ -0,0 +1,242 @@
+package native
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+)
+
+// FanOut dispatches multicast notifications in a fixed set of namespaces to the
+// sessions of many connections. The multicast notifications in each namespace
+// are dispatched by a single relay session, rather than by every session that
+// listens to it.
+//
+// Sessions still listen to the FanOut's namespaces themselves, in order to
+// receive notifications that are targeted at them, but ignore the multicast
+// notifications they receive in those namespaces.
+//
+// Each listener's notifications are delivered in order, without waiting for the
+// other listeners, so a slow client does not delay the delivery of
+// notifications to the clients of other connections.
+//
+// The relays belong to the FanOut, not to a connection, so passing the same
+// FanOut to every Handler of a peer via the SharedFanOut option results in one
+// relay per namespace for the whole peer.
+type FanOut struct {
+	peer       rinq.Peer
+	namespaces map[string]struct{}
+
+	mutex  sync.Mutex
+	relays map[string]*relay
+}
+
+// relay is the session that receives the multicast notifications for a single
+// namespace on behalf of the listening sessions.
+type relay struct {
+	session   rinq.Session
+	listeners map[ident.SessionID]*fanOutListener
+}
+
+// fanOutListener is a session listening to a namespace via a FanOut.
+type fanOutListener struct {
+	session rinq.Session
+	handler rinq.NotificationHandler
+
+	mutex   sync.Mutex
+	pending []rinq.Notification
+	running bool
+}
+
+// NewFanOut returns a new FanOut that dispatches multicast notifications in
+// the given namespaces, which it receives using sessions created by peer.
+func NewFanOut(peer rinq.Peer, namespaces ...string) *FanOut {
+	f := &FanOut{
+		peer:       peer,
+		namespaces: map[string]struct{}{},
+		relays:     map[string]*relay{},
+	}
+
+	for _, ns := range namespaces {
+		f.namespaces[ns] = struct{}{}
+	}
+
+	return f
+}
+
+// handles returns true if notifications in ns are dispatched by the FanOut.
+func (f *FanOut) handles(ns string) bool {
+	_, ok := f.namespaces[ns]
+	return ok
+}
+
+// listen starts dispatching multicast notifications in ns to sess, by invoking
+// h.
+func (f *FanOut) listen(ns string, sess rinq.Session, h rinq.NotificationHandler) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	r, ok := f.relays[ns]
+	if !ok {
+		r = &relay{
+			session:   f.peer.Session(),
+			listeners: map[ident.SessionID]*fanOutListener{},
+		}
+
+		if err := r.session.Listen(ns, f.dispatch); err != nil {
+			r.session.Destroy()
+			return err
+		}
+
+		f.relays[ns] = r
+	}
+
+	r.listeners[sess.ID()] = &fanOutListener{
+		session: sess,
+		handler: h,
+	}
+
+	return nil
+}
+
+// unlisten stops dispatching multicast notifications in ns to sess. The relay
+// for ns is destroyed once it has no listeners.
+func (f *FanOut) unlisten(ns string, sess rinq.Session) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.remove(ns, sess.ID())
+}
+
+// unlistenAll stops dispatching multicast notifications in any namespace to
+// sess.
+func (f *FanOut) unlistenAll(sess rinq.Session) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for ns := range f.relays {
+		f.remove(ns, sess.ID())
+	}
+}
+
+// remove removes the listener with the given ID from the relay for ns.
+// f.mutex must be held.
+func (f *FanOut) remove(ns string, id ident.SessionID) {
+	r, ok := f.relays[ns]
+	if !ok {
+		return
+	}
+
+	if l, ok := r.listeners[id]; ok {
+		l.discard()
+		delete(r.listeners, id)
+	}
+
+	if len(r.listeners) == 0 {
+		delete(f.relays, ns)
+		go r.session.Destroy()
+	}
+}
+
+// dispatch is the notification handler for each relay session. It queues the
+// notification for delivery to each listener in the notification's namespace
+// whose attributes match the notification's constraint. Notifications
+// targeted at the relay itself are ignored.
+func (f *FanOut) dispatch(ctx context.Context, _ rinq.Session, n rinq.Notification) {
+	if !n.IsMulticast {
+		return
+	}
+
+	f.mutex.Lock()
+	var listeners []*fanOutListener
+	if r, ok := f.relays[n.Namespace]; ok {
+		for _, l := range r.listeners {
+			listeners = append(listeners, l)
+		}
+	}
+	f.mutex.Unlock()
+
+	for _, l := range listeners {
+		if matchesConstraint(ctx, l.session, n) {
+			c := n
+			c.Payload = n.Payload.Clone()
+			l.deliver(ctx, c)
+		}
+	}
+}
+
+// deliver queues n for delivery to the listener, and starts delivering the
+// queued notifications if they are not already being delivered. It does not
+// wait for the listener's handler.
+func (l *fanOutListener) deliver(ctx context.Context, n rinq.Notification) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.pending = append(l.pending, n)
+
+	if !l.running {
+		l.running = true
+		go l.run(ctx)
+	}
+}
+
+// run invokes the listener's handler for each queued notification, in order,
+// until the queue is empty.
+func (l *fanOutListener) run(ctx context.Context) {
+	for {
+		l.mutex.Lock()
+		if len(l.pending) == 0 {
+			l.running = false
+			l.mutex.Unlock()
+			return
+		}
+
+		n := l.pending[0]
+		l.pending = l.pending[1:]
+		l.mutex.Unlock()
+
+		l.handler(ctx, l.session, n)
+	}
+}
+
+// discard drops the notifications that have not yet been delivered.
+func (l *fanOutListener) discard() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, n := range l.pending {
+		n.Payload.Close()
+	}
+
+	l.pending = nil
+}
+
+// matchesConstraint returns true if the attributes of sess in the
+// notification's namespace satisfy its constraint.
+func matchesConstraint(ctx context.Context, sess rinq.Session, n rinq.Notification) bool {
+	if len(n.Constraint) == 0 {
+		return true
+	}
+
+	keys := make([]string, 0, len(n.Constraint))
+	for k := range n.Constraint {
+		keys = append(keys, k)
+	}
+
+	attrs, err := sess.CurrentRevision().GetMany(ctx, n.Namespace, keys...)
+	if err != nil {
+		return false
+	}
+
+	found := 0
+	for _, attr := range attrs {
+		v, ok := n.Constraint[attr.Key]
+		if !ok || v != attr.Value {
+			return false
+		}
+		found++
+	}
+
+	return found == len(n.Constraint)
+}
@@ -0,0 +1,231 @@
+package native
+
+import (
+	"context"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+)
+
+var _ = Describe("FanOut", func() {
+	var (
+		relays   []*mock.Session
+		a, b     *mock.Session
+		mutex    sync.Mutex
+		received map[*mock.Session][]rinq.Notification
+		subject  *FanOut
+	)
+
+	handler := func(ctx context.Context, sess rinq.Session, n rinq.Notification) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		s := sess.(*mock.Session)
+		received[s] = append(received[s], n)
+	}
+
+	count := func(s *mock.Session) func() int {
+		return func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return len(received[s])
+		}
+	}
+
+	BeforeEach(func() {
+		relays = nil
+		received = map[*mock.Session][]rinq.Notification{}
+
+		peer := &mock.Peer{}
+		peer.Impl.Session = func() rinq.Session {
+			s := mock.NewSession(uint32(100 + len(relays)))
+			relays = append(relays, s)
+			return s
+		}
+
+		a = mock.NewSession(1)
+		b = mock.NewSession(2)
+
+		subject = NewFanOut(peer, "ns")
+	})
+
+	It("subscribes to each namespace once", func() {
+		Expect(subject.listen("ns", a, handler)).To(Succeed())
+		Expect(subject.listen("ns", b, handler)).To(Succeed())
+
+		Expect(relays).To(HaveLen(1))
+		Expect(relays[0].IsListening("ns")).To(BeTrue())
+	})
+
+	It("dispatches multicast notifications to each listener", func() {
+		subject.listen("ns", a, handler)
+		subject.listen("ns", b, handler)
+
+		relays[0].Deliver(rinq.Notification{
+			Namespace:   "ns",
+			Type:        "type",
+			Payload:     rinq.NewPayload(123),
+			IsMulticast: true,
+		})
+
+		Eventually(count(a)).Should(Equal(1))
+		Eventually(count(b)).Should(Equal(1))
+	})
+
+	It("does not wait for other listeners to handle notifications", func() {
+		block := make(chan struct{})
+		defer close(block)
+
+		subject.listen("ns", a, func(context.Context, rinq.Session, rinq.Notification) {
+			<-block
+		})
+		subject.listen("ns", b, handler)
+
+		n := rinq.Notification{Namespace: "ns", Type: "type", IsMulticast: true}
+		relays[0].Deliver(n)
+		relays[0].Deliver(n)
+
+		Eventually(count(b)).Should(Equal(2))
+	})
+
+	It("delivers notifications to each listener in order", func() {
+		subject.listen("ns", a, handler)
+
+		for _, t := range []string{"a", "b", "c"} {
+			relays[0].Deliver(rinq.Notification{Namespace: "ns", Type: t, IsMulticast: true})
+		}
+
+		Eventually(count(a)).Should(Equal(3))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		Expect(received[a][0].Type).To(Equal("a"))
+		Expect(received[a][1].Type).To(Equal("b"))
+		Expect(received[a][2].Type).To(Equal("c"))
+	})
+
+	It("ignores targeted notifications", func() {
+		subject.listen("ns", a, handler)
+
+		relays[0].Deliver(rinq.Notification{Namespace: "ns", Type: "type"})
+
+		Consistently(count(a)).Should(BeZero())
+	})
+
+	It("only dispatches to listeners that match the constraint", func() {
+		a.Impl.Attrs = []rinq.Attr{rinq.Set("region", "eu")}
+		b.Impl.Attrs = []rinq.Attr{rinq.Set("region", "us")}
+
+		subject.listen("ns", a, handler)
+		subject.listen("ns", b, handler)
+
+		relays[0].Deliver(rinq.Notification{
+			Namespace:   "ns",
+			Type:        "type",
+			Payload:     rinq.NewPayload(123),
+			IsMulticast: true,
+			Constraint:  rinq.Constraint{"region": "eu"},
+		})
+
+		Eventually(count(a)).Should(Equal(1))
+		Consistently(count(b)).Should(BeZero())
+	})
+
+	It("destroys the relay once there are no listeners", func() {
+		subject.listen("ns", a, handler)
+		subject.listen("ns", b, handler)
+
+		subject.unlisten("ns", a)
+		Consistently(relays[0].Done()).ShouldNot(BeClosed())
+
+		subject.unlistenAll(b)
+		Eventually(relays[0].Done()).Should(BeClosed())
+	})
+})
+
+var _ = Describe("visitor with a shared fan-out", func() {
+	var (
+		relays  []*mock.Session
+		a, b    *mock.Session
+		sent    chan message.Outgoing
+		subject *visitor
+	)
+
+	BeforeEach(func() {
+		relays = nil
+
+		peer := &mock.Peer{}
+		peer.Impl.Session = func() rinq.Session {
+			s := mock.NewSession(uint32(100 + len(relays)))
+			relays = append(relays, s)
+			return s
+		}
+
+		a = mock.NewSession(1)
+		b = mock.NewSession(2)
+		sent = make(chan message.Outgoing, 10)
+
+		subject = newVisitor(context.Background(), nil, nil, func(m message.Outgoing) {
+			sent <- m
+		})
+		SharedFanOut(NewFanOut(peer, "ns")).modify(subject)
+
+		subject.forward = map[message.SessionIndex]rinq.Session{1: a, 2: b}
+		subject.reverse = map[ident.SessionID]message.SessionIndex{a.ID(): 1, b.ID(): 2}
+
+		Expect(subject.listen(a, "ns")).To(Succeed())
+		Expect(subject.listen(b, "ns")).To(Succeed())
+	})
+
+	It("shares a single relay for the namespace", func() {
+		Expect(relays).To(HaveLen(1))
+		Expect(relays[0].IsListening("ns")).To(BeTrue())
+	})
+
+	It("receives multicast notifications via the fan-out", func() {
+		relays[0].Deliver(rinq.Notification{Namespace: "ns", Type: "type", IsMulticast: true})
+
+		Eventually(sent).Should(Receive())
+		Eventually(sent).Should(Receive())
+	})
+
+	It("receives targeted notifications via the session's own listener", func() {
+		Expect(a.IsListening("ns")).To(BeTrue())
+
+		a.Deliver(rinq.Notification{Namespace: "ns", Type: "type"})
+
+		Expect(sent).To(Receive())
+	})
+
+	It("ignores multicast notifications received by the session's own listener", func() {
+		a.Deliver(rinq.Notification{Namespace: "ns", Type: "type", IsMulticast: true})
+
+		Consistently(sent).ShouldNot(Receive())
+	})
+
+	It("listens individually to namespaces that are not handled by the fan-out", func() {
+		Expect(subject.listen(a, "other")).To(Succeed())
+		Expect(a.IsListening("other")).To(BeTrue())
+		Expect(relays).To(HaveLen(1))
+
+		a.Deliver(rinq.Notification{Namespace: "other", Type: "type"})
+		Expect(sent).To(Receive())
+	})
+
+	It("stops dispatching to sessions that unlisten", func() {
+		Expect(subject.unlisten(a, "ns")).To(Succeed())
+		Expect(a.IsListening("ns")).To(BeFalse())
+
+		relays[0].Deliver(rinq.Notification{Namespace: "ns", Type: "type", IsMulticast: true})
+
+		Eventually(sent).Should(Receive())
+		Consistently(sent).ShouldNot(Receive())
+	})
+})
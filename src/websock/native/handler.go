@@ -4,6 +4,8 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/rinq/httpd/src/websock"
 	"github.com/rinq/httpd/src/websock/native/message"
@@ -19,12 +21,27 @@ var _ websock.Handler = (*Handler)(nil)
 // instance supports one of these encodings, the choice of which affects the websocket
 // sub-protocol that the handler advertises itself as supporting.
 func NewHandler(peer rinq.Peer, encoding message.Encoding, options ...Option) *Handler {
+	h := &Handler{
+		Peer:             peer,
+		Encoding:         encoding,
+		visitorOpt:       options,
+		resumeBufferSize: DefaultResumeBufferSize,
+	}
 
-	return &Handler{
-		Peer:       peer,
-		Encoding:   encoding,
-		visitorOpt: options,
+	for _, opt := range options {
+		if o, ok := opt.(handlerOption); ok {
+			o.modifyHandler(h)
+		}
+	}
+
+	if h.resumeGrace > 0 {
+		h.resumer = &resumer{
+			grace:      h.resumeGrace,
+			bufferSize: h.resumeBufferSize,
+		}
 	}
+
+	return h
 }
 
 // Handler is an implementation of websock.Handler that handles connections that
@@ -34,7 +51,25 @@ type Handler struct {
 	Encoding message.Encoding
 	Logger   *log.Logger
 
-	visitorOpt []Option
+	mutex            sync.RWMutex
+	visitorOpt       []Option
+	resumeGrace      time.Duration
+	resumeBufferSize int
+	resumeBindIP     bool
+	resumer          *resumer
+}
+
+// Reconfigure replaces the options that are applied to new connections.
+// Existing connections, and those that resume their sessions, retain the
+// options that were in effect when they were established.
+//
+// Options that configure the handler itself, such as ResumeGracePeriod,
+// ResumeBufferSize and ResumeBindClientIP, can only be set by NewHandler() and
+// are ignored.
+func (h *Handler) Reconfigure(options ...Option) {
+	h.mutex.Lock()
+	h.visitorOpt = options
+	h.mutex.Unlock()
 }
 
 // Protocol returns the name of the WebSocket sub-protocol supported by this
@@ -45,25 +80,103 @@ func (h *Handler) Protocol() string {
 
 // Handle takes control of WebSocket connection until it is closed.
 func (h *Handler) Handle(c websock.Connection, r *http.Request) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	v := newVisitor(
-		ctx,
-		h.Peer,
-		sessionAttributes(r),
-		func(m message.Outgoing) {
-			if w, err := c.NextWriter(); err == nil {
+	b := newBatcher(
+		h.Encoding,
+		func(m message.Outgoing, p websock.Priority) {
+			if w, err := c.NextWriter(p); err == nil {
 				defer w.Close()
 				_ = message.Write(w, h.Encoding, m)
 			}
 		},
 	)
+	defer b.stop()
+
+	if h.resumer == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		v := h.newVisitor(ctx, r, b.Send)
+		v.attach(r, b, idleChannel(c))
+		websock.Inspect(r.Context(), v)
+
+		return h.serve(c, v)
+	}
+
+	binding := resumeBinding(r, h.resumeBindIP)
+
+	s, resumed := h.resumer.resume(r.URL.Query().Get(resumeParam), binding)
+	if !resumed {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var err error
+		s, err = h.resumer.start(binding, cancel)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		s.visitor = h.newVisitor(ctx, r, s.sender.Send)
+	}
 
-	for _, opt := range h.visitorOpt {
+	s.visitor.attach(r, b, idleChannel(c))
+	websock.Inspect(r.Context(), s.visitor)
+	b.Send(message.NewResumeToken(s.token, resumed))
+	s.sender.attach(b.Send)
+	defer h.suspend(s, c, b)
+
+	return h.serve(c, s.visitor)
+}
+
+// suspend suspends s once its connection c has closed.
+//
+// Messages that are still held by the batcher b, or queued on c, have not
+// been written to the client. They are buffered along with any later
+// messages, so that they are delivered if the connection is resumed.
+func (h *Handler) suspend(s *resumable, c websock.Connection, b *batcher) {
+	s.visitor.detach()
+
+	h.resumer.suspend(s, func() []message.Outgoing {
+		// the batcher is drained first, as it may be writing a batch to c
+		pending := b.drain()
+
+		var unsent []message.Outgoing
+		if d, ok := c.(websock.Drainer); ok {
+			for _, buf := range d.Drain() {
+				unsent = append(unsent, message.Encoded(buf))
+			}
+		}
+
+		return append(unsent, pending...)
+	})
+}
+
+// newVisitor returns a visitor for a new connection. The visitor must be
+// attached to the connection before it is used.
+//
+// The visitor's identity is taken from r. It is retained if the connection is
+// resumed, which can only be done by the same client.
+func (h *Handler) newVisitor(
+	ctx context.Context,
+	r *http.Request,
+	send func(message.Outgoing),
+) *visitor {
+	v := newVisitor(ctx, h.Peer, nil, send)
+	v.identity = clientIdentity(r)
+	v.logger = h.Logger
+
+	h.mutex.RLock()
+	options := h.visitorOpt
+	h.mutex.RUnlock()
+
+	for _, opt := range options {
 		opt.modify(v)
 	}
 
+	return v
+}
+
+// serve dispatches incoming messages to v until the connection is closed.
+func (h *Handler) serve(c websock.Connection, v *visitor) error {
 	for {
 		r, err := c.NextReader()
 		if err != nil {
@@ -75,9 +188,28 @@ func (h *Handler) Handle(c websock.Connection, r *http.Request) error {
 			return err
 		}
 
-		err = msg.Accept(v)
+		ok, err := v.admit(msg)
 		if err != nil {
 			return err
 		}
+
+		if ok {
+			err = msg.Accept(v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// priority returns the delivery priority of m. Notifications are delivered
+// after any queued messages that the client is waiting on. The priority of a
+// batch is determined by the batcher, from the messages it contains.
+func priority(m message.Outgoing) websock.Priority {
+	switch m.(type) {
+	case *message.Notification:
+		return websock.PriorityNormal
+	default:
+		return websock.PriorityHigh
 	}
 }
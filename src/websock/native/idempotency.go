@@ -0,0 +1,235 @@
+package native
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// clientIDParam is the query parameter of the upgrade request used by clients
+// to supply a stable ID that scopes their idempotency keys.
+const clientIDParam = "client_id"
+
+const (
+	// DefaultIdempotencyStoreSize is the default number of idempotency keys
+	// that are remembered.
+	DefaultIdempotencyStoreSize = 10000
+
+	// DefaultIdempotencyTTL is the default time for which the result of a call
+	// with an idempotency key is remembered.
+	DefaultIdempotencyTTL = 5 * time.Minute
+)
+
+// IdempotencyStore remembers the results of sync calls made with an
+// idempotency key, such that retries of the same call receive the original
+// result, rather than invoking the command again.
+//
+// Keys are scoped to the identity of the client. Clients that present a
+// verified TLS certificate are identified by its fingerprint. Other clients
+// may supply an ID in the "client_id" query parameter of the upgrade request,
+// which identifies them in combination with their IP address. Otherwise, each
+// connection is a separate identity, which is preserved if its sessions are
+// resumed.
+//
+// When a store is used by several Handlers, a retry is deduplicated even if
+// the client reconnects using a different encoding. The size limit applies to
+// all of the Handlers' keys combined.
+type IdempotencyStore struct {
+	size int
+	ttl  time.Duration
+
+	mutex   sync.Mutex
+	entries map[idempotencyKey]*list.Element
+	lru     list.List
+}
+
+// NewIdempotencyStore returns a store that remembers up to size keys, each
+// for the given TTL.
+func NewIdempotencyStore(size int, ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		size:    size,
+		ttl:     ttl,
+		entries: map[idempotencyKey]*list.Element{},
+	}
+}
+
+// idempotencyKey identifies a call made with an idempotency key.
+type idempotencyKey struct {
+	Identity  string
+	Namespace string
+	Command   string
+	Key       string
+}
+
+// idempotentCall is a call made with an idempotency key.
+//
+// The payload is owned by the store, and is closed once the call is complete
+// and has been removed from the store, and its waiters have cloned it.
+type idempotentCall struct {
+	key     idempotencyKey
+	done    chan struct{}
+	payload *rinq.Payload
+	err     error
+	expires time.Time // zero while the call is in progress
+
+	// the remaining fields are protected by the store's mutex
+	completed bool
+	removed   bool
+	waiters   int
+}
+
+// begin returns the call identified by k. It returns true if there is no
+// previous call with the same key, in which case the caller must invoke the
+// command and then call complete().
+func (s *IdempotencyStore) begin(k idempotencyKey) (*idempotentCall, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e, ok := s.entries[k]; ok {
+		c := e.Value.(*idempotentCall)
+
+		if c.expires.IsZero() || time.Now().Before(c.expires) {
+			s.lru.MoveToFront(e)
+			c.waiters++
+			return c, false
+		}
+
+		s.remove(e)
+	}
+
+	c := &idempotentCall{
+		key:  k,
+		done: make(chan struct{}),
+	}
+
+	s.entries[k] = s.lru.PushFront(c)
+
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+	}
+
+	return c, true
+}
+
+// complete records the result of c. Only successful responses and failures
+// are remembered. Otherwise, the key is forgotten so that the call may be
+// retried.
+//
+// The store keeps a clone of p, as p itself is closed once it is sent to the
+// client.
+func (s *IdempotencyStore) complete(c *idempotentCall, p *rinq.Payload, err error) {
+	s.mutex.Lock()
+
+	c.payload = p.Clone()
+	c.err = err
+	c.completed = true
+
+	if e, ok := s.entries[c.key]; ok && e.Value == c {
+		switch err.(type) {
+		case nil, rinq.Failure:
+			c.expires = time.Now().Add(s.ttl)
+		default:
+			s.remove(e)
+		}
+	} else {
+		c.release()
+	}
+
+	s.mutex.Unlock()
+
+	close(c.done)
+}
+
+// remove removes e from the store. s.mutex must be held.
+func (s *IdempotencyStore) remove(e *list.Element) {
+	c := e.Value.(*idempotentCall)
+
+	s.lru.Remove(e)
+	delete(s.entries, c.key)
+
+	c.removed = true
+	c.release()
+}
+
+// wait blocks until c is complete, then returns a clone of its result.
+func (s *IdempotencyStore) wait(ctx context.Context, c *idempotentCall) (*rinq.Payload, error) {
+	var (
+		p   *rinq.Payload
+		err error
+	)
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err == nil {
+		p, err = c.payload.Clone(), c.err
+	}
+
+	c.waiters--
+	c.release()
+
+	return p, err
+}
+
+// release closes the payload of c once it is no longer needed. s.mutex must
+// be held.
+func (c *idempotentCall) release() {
+	if c.completed && c.removed && c.waiters == 0 {
+		c.payload.Close()
+	}
+}
+
+// connectionCount is used to give each connection a unique identity.
+var connectionCount uint64
+
+// clientIdentity returns the identity used to scope the idempotency keys of
+// the client that made r.
+//
+// A client-supplied ID is combined with the client's IP address, so that a
+// client can not use another client's ID to obtain the results of its calls
+// unless it shares the same address.
+func clientIdentity(r *http.Request) string {
+	if fingerprint, ok := clientFingerprint(r.TLS); ok {
+		return "tls:" + fingerprint
+	}
+
+	if id := r.URL.Query().Get(clientIDParam); isClientID(id) {
+		return "client:" + resolveClient(r).IP + "/" + id
+	}
+
+	return "conn:" + strconv.FormatUint(atomic.AddUint64(&connectionCount, 1), 10)
+}
+
+// isClientID returns true if s is a valid client-supplied ID, which is 16 to
+// 128 characters from the URL-safe base64 alphabet. The minimum length
+// requires an ID that is not easily guessed.
+func isClientID(s string) bool {
+	if len(s) < 16 || len(s) > 128 {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
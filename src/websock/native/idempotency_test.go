@@ -0,0 +1,258 @@
+package native
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+var _ = Describe("IdempotencyStore", func() {
+	var subject *IdempotencyStore
+
+	key := func(k string) idempotencyKey {
+		return idempotencyKey{"identity", "ns", "cmd", k}
+	}
+
+	BeforeEach(func() {
+		subject = NewIdempotencyStore(2, time.Minute)
+	})
+
+	It("returns the previous call for a duplicate key", func() {
+		c, first := subject.begin(key("a"))
+		Expect(first).To(BeTrue())
+
+		d, first := subject.begin(key("a"))
+		Expect(first).To(BeFalse())
+		Expect(d).To(BeIdenticalTo(c))
+	})
+
+	It("remembers successful results", func() {
+		c, _ := subject.begin(key("a"))
+		subject.complete(c, rinq.NewPayload(123), nil)
+
+		d, first := subject.begin(key("a"))
+		Expect(first).To(BeFalse())
+
+		p, err := subject.wait(context.Background(), d)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(p.Value()).To(BeEquivalentTo(123))
+	})
+
+	It("is not affected by closing the payload passed to complete", func() {
+		c, _ := subject.begin(key("a"))
+		p := rinq.NewPayload(123)
+		subject.complete(c, p, nil)
+		p.Close()
+
+		d, _ := subject.begin(key("a"))
+		p, err := subject.wait(context.Background(), d)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(p.Value()).To(BeEquivalentTo(123))
+	})
+
+	It("returns the result to waiters of calls that are evicted", func() {
+		c, _ := subject.begin(key("a"))
+		d, _ := subject.begin(key("a"))
+		subject.complete(c, rinq.NewPayload(123), nil)
+
+		subject.begin(key("b"))
+		subject.begin(key("c"))
+
+		_, first := subject.begin(key("a"))
+		Expect(first).To(BeTrue())
+
+		p, err := subject.wait(context.Background(), d)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(p.Value()).To(BeEquivalentTo(123))
+	})
+
+	It("remembers failures", func() {
+		c, _ := subject.begin(key("a"))
+		subject.complete(c, nil, rinq.Failure{Type: "type"})
+
+		_, first := subject.begin(key("a"))
+		Expect(first).To(BeFalse())
+	})
+
+	It("forgets keys of calls that did not produce a response", func() {
+		c, _ := subject.begin(key("a"))
+		subject.complete(c, nil, context.DeadlineExceeded)
+
+		_, first := subject.begin(key("a"))
+		Expect(first).To(BeTrue())
+	})
+
+	It("forgets keys once the TTL elapses", func() {
+		subject = NewIdempotencyStore(2, time.Millisecond)
+
+		c, _ := subject.begin(key("a"))
+		subject.complete(c, nil, nil)
+		time.Sleep(5 * time.Millisecond)
+
+		_, first := subject.begin(key("a"))
+		Expect(first).To(BeTrue())
+	})
+
+	It("evicts the least recently used key", func() {
+		subject.begin(key("a"))
+		subject.begin(key("b"))
+		subject.begin(key("a"))
+		subject.begin(key("c"))
+
+		_, first := subject.begin(key("a"))
+		Expect(first).To(BeFalse())
+
+		_, first = subject.begin(key("b"))
+		Expect(first).To(BeTrue())
+	})
+
+	It("scopes keys by identity", func() {
+		subject.begin(key("a"))
+
+		_, first := subject.begin(idempotencyKey{"other", "ns", "cmd", "a"})
+		Expect(first).To(BeTrue())
+	})
+})
+
+var _ = Describe("visitor idempotent calls", func() {
+	var (
+		calls   int32
+		release chan struct{}
+		sess    *mock.Session
+		sent    chan message.Outgoing
+		subject *visitor
+	)
+
+	syncCall := func(seq uint, key string) *message.SyncCall {
+		m := &message.SyncCall{}
+		m.Session = 1
+		m.Seq = seq
+		m.Namespace = "ns"
+		m.Command = "cmd"
+		m.Timeout = time.Second
+		m.IdempotencyKey = key
+		return m
+	}
+
+	BeforeEach(func() {
+		calls = 0
+		release = make(chan struct{})
+
+		sess = mock.NewSession(1)
+		sess.Impl.Call = func(context.Context, string, string, *rinq.Payload) (*rinq.Payload, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return rinq.NewPayload("result"), nil
+		}
+
+		sent = make(chan message.Outgoing, 10)
+		subject = newVisitor(context.Background(), nil, nil, func(m message.Outgoing) {
+			sent <- m
+		})
+		subject.identity = "identity"
+		Idempotency(NewIdempotencyStore(10, time.Minute)).modify(subject)
+	})
+
+	It("attaches retries to the call that is still in progress", func() {
+		go subject.call(sess, syncCall(1, "key"), CallPolicy{})
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(BeEquivalentTo(1))
+
+		go subject.call(sess, syncCall(2, "key"), CallPolicy{})
+		close(release)
+
+		Eventually(sent).Should(Receive())
+		Eventually(sent).Should(Receive())
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("returns the stored result for duplicate keys", func() {
+		close(release)
+
+		subject.call(sess, syncCall(1, "key"), CallPolicy{})
+		subject.call(sess, syncCall(2, "key"), CallPolicy{})
+
+		Expect(sent).To(HaveLen(2))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("returns the stored result for retries made after reconnecting", func() {
+		close(release)
+
+		store := NewIdempotencyStore(10, time.Minute)
+		h := NewHandler(nil, message.JSONEncoding, Idempotency(store))
+		send := func(m message.Outgoing) { sent <- m }
+
+		connect := func() *visitor {
+			r := httptest.NewRequest("GET", "/?client_id=4bf92f3577b34da6a3ce", nil)
+			return h.newVisitor(context.Background(), r, send)
+		}
+
+		connect().call(sess, syncCall(1, "key"), CallPolicy{})
+		connect().call(sess, syncCall(1, "key"), CallPolicy{})
+
+		Expect(sent).To(HaveLen(2))
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(1))
+	})
+
+	It("invokes the command for each call without a key", func() {
+		close(release)
+
+		subject.call(sess, syncCall(1, ""), CallPolicy{})
+		subject.call(sess, syncCall(2, ""), CallPolicy{})
+
+		Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+	})
+})
+
+var _ = Describe("clientIdentity", func() {
+	It("uses the fingerprint of a verified client certificate", func() {
+		r := &http.Request{
+			TLS: &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{
+					{{Raw: []byte("certificate")}},
+				},
+			},
+		}
+
+		Expect(clientIdentity(r)).To(HavePrefix("tls:"))
+		Expect(clientIdentity(r)).To(Equal(clientIdentity(r)))
+	})
+
+	It("uses the client-supplied ID in combination with the client's IP address", func() {
+		r := httptest.NewRequest("GET", "/?client_id=4bf92f3577b34da6a3ce", nil)
+		other := httptest.NewRequest("GET", "/?client_id=4bf92f3577b34da6a3ce", nil)
+		other.RemoteAddr = "192.0.2.2:1234"
+
+		Expect(clientIdentity(r)).To(Equal("client:192.0.2.1/4bf92f3577b34da6a3ce"))
+		Expect(clientIdentity(r)).ToNot(Equal(clientIdentity(other)))
+	})
+
+	It("uses a unique identity for each connection otherwise", func() {
+		r := &http.Request{URL: &url.URL{}}
+		Expect(clientIdentity(r)).ToNot(Equal(clientIdentity(r)))
+	})
+
+	DescribeTable(
+		"ignores invalid client-supplied IDs",
+		func(id string) {
+			r := httptest.NewRequest("GET", "/?client_id="+url.QueryEscape(id), nil)
+			Expect(clientIdentity(r)).To(HavePrefix("conn:"))
+		},
+		Entry("too short", "4bf92f3577b34da"),
+		Entry("too long", strings.Repeat("a", 129)),
+		Entry("invalid characters", "4bf92f3577b34da6a3ce\n"),
+	)
+})
@@ -0,0 +1,107 @@
+package native
+
+import (
+	"fmt"
+
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+// OverloadPolicy determines how a Handler responds to sync calls when the
+// maximum number of in-flight calls has been reached.
+type OverloadPolicy int
+
+const (
+	// OverloadBackpressure stops reading from the connection until an
+	// in-flight call completes.
+	OverloadBackpressure OverloadPolicy = iota
+
+	// OverloadReject answers the call with a rejection message, without
+	// forwarding it to Rinq.
+	OverloadReject
+)
+
+// ParseOverloadPolicy parses the name of an overload policy, one of
+// "backpressure" or "reject".
+func ParseOverloadPolicy(s string) (OverloadPolicy, error) {
+	switch s {
+	case "backpressure":
+		return OverloadBackpressure, nil
+	case "reject":
+		return OverloadReject, nil
+	default:
+		return 0, fmt.Errorf("unknown overload policy %q, expected backpressure or reject", s)
+	}
+}
+
+// inflightLimits holds the limits on the number of concurrent sync calls on
+// a single connection.
+type inflightLimits struct {
+	policy     OverloadPolicy
+	connection chan struct{}
+	perSession int
+	sessions   map[message.SessionIndex]chan struct{}
+}
+
+// acquireCall reserves an in-flight call slot for session i. It returns false
+// if no slot is available and the call must be rejected. Otherwise, release
+// must be called when the call completes.
+func (v *visitor) acquireCall(i message.SessionIndex) (release func(), ok bool, err error) {
+	var acquired []chan struct{}
+
+	release = func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+	}
+
+	for _, sem := range v.callSemaphores(i) {
+		if v.inflight.policy == OverloadReject {
+			select {
+			case sem <- struct{}{}:
+			default:
+				release()
+				return nil, false, nil
+			}
+		} else {
+			select {
+			case sem <- struct{}{}:
+			case <-v.context.Done():
+				release()
+				return nil, false, v.context.Err()
+			}
+		}
+
+		acquired = append(acquired, sem)
+	}
+
+	return release, true, nil
+}
+
+// callSemaphores returns the semaphores that limit the in-flight calls for
+// session i.
+func (v *visitor) callSemaphores(i message.SessionIndex) []chan struct{} {
+	var sems []chan struct{}
+
+	if v.inflight.connection != nil {
+		sems = append(sems, v.inflight.connection)
+	}
+
+	if v.inflight.perSession > 0 {
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+
+		sem, ok := v.inflight.sessions[i]
+		if !ok {
+			if v.inflight.sessions == nil {
+				v.inflight.sessions = map[message.SessionIndex]chan struct{}{}
+			}
+
+			sem = make(chan struct{}, v.inflight.perSession)
+			v.inflight.sessions[i] = sem
+		}
+
+		sems = append(sems, sem)
+	}
+
+	return sems
+}
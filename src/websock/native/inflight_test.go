@@ -0,0 +1,136 @@
+package native
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+var _ = Describe("visitor in-flight limits", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		subject *visitor
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		subject = newVisitor(ctx, nil, nil, func(message.Outgoing) {})
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("acquireCall", func() {
+		It("always succeeds when there are no limits", func() {
+			for i := 0; i < 100; i++ {
+				_, ok, err := subject.acquireCall(1)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeTrue())
+			}
+		})
+
+		Context("when the policy is to reject", func() {
+			BeforeEach(func() {
+				OnOverload(OverloadReject).modify(subject)
+			})
+
+			It("rejects calls that exceed the connection limit", func() {
+				MaxInFlightCalls(2).modify(subject)
+
+				subject.acquireCall(1)
+				subject.acquireCall(2)
+
+				_, ok, err := subject.acquireCall(3)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeFalse())
+			})
+
+			It("rejects calls that exceed the session limit", func() {
+				MaxInFlightCallsPerSession(1).modify(subject)
+
+				subject.acquireCall(1)
+
+				_, ok, _ := subject.acquireCall(1)
+				Expect(ok).To(BeFalse())
+
+				_, ok, _ = subject.acquireCall(2)
+				Expect(ok).To(BeTrue())
+			})
+
+			It("does not hold a connection slot when the session limit is exceeded", func() {
+				MaxInFlightCalls(2).modify(subject)
+				MaxInFlightCallsPerSession(1).modify(subject)
+
+				subject.acquireCall(1)
+				subject.acquireCall(1)
+
+				_, ok, _ := subject.acquireCall(2)
+				Expect(ok).To(BeTrue())
+			})
+
+			It("admits further calls once a slot is released", func() {
+				MaxInFlightCalls(1).modify(subject)
+
+				release, _, _ := subject.acquireCall(1)
+				release()
+
+				_, ok, _ := subject.acquireCall(1)
+				Expect(ok).To(BeTrue())
+			})
+		})
+
+		Context("when the policy is backpressure", func() {
+			BeforeEach(func() {
+				MaxInFlightCalls(1).modify(subject)
+			})
+
+			It("blocks until a slot is released", func() {
+				release, _, _ := subject.acquireCall(1)
+
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(done)
+
+					_, ok, err := subject.acquireCall(1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(ok).To(BeTrue())
+				}()
+
+				Consistently(done).ShouldNot(BeClosed())
+				release()
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("returns an error if the context is canceled", func() {
+				subject.acquireCall(1)
+				cancel()
+
+				_, ok, err := subject.acquireCall(1)
+				Expect(err).To(Equal(context.Canceled))
+				Expect(ok).To(BeFalse())
+			})
+		})
+	})
+
+	DescribeTable(
+		"ParseOverloadPolicy",
+		func(s string, expected OverloadPolicy) {
+			p, err := ParseOverloadPolicy(s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(p).To(Equal(expected))
+		},
+		Entry("backpressure", "backpressure", OverloadBackpressure),
+		Entry("reject", "reject", OverloadReject),
+	)
+
+	It("ParseOverloadPolicy returns an error for unknown policies", func() {
+		_, err := ParseOverloadPolicy("drop")
+		Expect(err).Should(HaveOccurred())
+	})
+})
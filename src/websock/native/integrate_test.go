@@ -1,3 +1,4 @@
+//go:build !without_amqp
 // +build !without_amqp
 
 package native_test
@@ -9,6 +10,7 @@ import (
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock"
 	"github.com/rinq/httpd/src/websock/native"
 	"github.com/rinq/httpd/src/websock/native/message"
 	"github.com/rinq/rinq-go/src/rinq"
@@ -490,7 +492,7 @@ func (m *mockWebsock) serverResponse() []byte {
 	}
 }
 
-func (m *mockWebsock) NextWriter() (out io.WriteCloser, err error) {
+func (m *mockWebsock) NextWriter(websock.Priority) (out io.WriteCloser, err error) {
 	<-m.start
 
 	b := wcByteBuff{Buffer: new(bytes.Buffer)}
@@ -0,0 +1,90 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// BatchConfig is an incoming message requesting that notifications and
+// asynchronous responses be coalesced into Batch messages.
+//
+// Batching applies to the whole connection, so the message does not have a
+// session index.
+type BatchConfig struct {
+	batchConfigHeader
+}
+
+// batchConfigHeader is the header structure for BatchConfig messages.
+type batchConfigHeader struct {
+	// MaxDelay is the maximum time that a message may be held waiting for
+	// other messages to batch with. A zero value disables batching.
+	MaxDelay time.Duration
+
+	// MaxCount is the maximum number of messages in a single batch, or zero
+	// for no limit.
+	MaxCount uint
+
+	// MaxBytes is the maximum combined size of the messages in a single batch,
+	// in bytes, or zero for no limit.
+	MaxBytes uint
+}
+
+// Accept calls the appropriate visit method on v.
+func (m *BatchConfig) Accept(v Visitor) error {
+	return v.VisitBatchConfig(m)
+}
+
+func (m *BatchConfig) read(r io.Reader, e Encoding) error {
+	err := e.DecodeHeader(r, &m.batchConfigHeader)
+	m.batchConfigHeader.MaxDelay *= time.Millisecond
+
+	return err
+}
+
+// Batch is an outgoing message containing several other outgoing messages.
+//
+// The header of a batch is the number of messages as a 16-bit integer. Each
+// message follows, prefixed by its length in bytes as a 32-bit integer.
+type Batch struct {
+	frames [][]byte
+}
+
+// NewBatch returns an outgoing message containing frames, each of which is an
+// encoded outgoing message.
+func NewBatch(frames [][]byte) *Batch {
+	return &Batch{frames}
+}
+
+// Len returns the number of messages in the batch.
+func (m *Batch) Len() int {
+	return len(m.frames)
+}
+
+func (m *Batch) write(w io.Writer, _ Encoding) error {
+	if len(m.frames) > math.MaxUint16 {
+		return errors.New("batch exceeds maximum number of messages")
+	}
+
+	if err := binary.Write(w, binary.BigEndian, batchType); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint16(len(m.frames))); err != nil {
+		return err
+	}
+
+	for _, f := range m.frames {
+		if err := binary.Write(w, binary.BigEndian, uint32(len(f))); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
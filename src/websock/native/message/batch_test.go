@@ -0,0 +1,69 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BatchConfig", func() {
+	Describe("Accept", func() {
+		It("invokes the correct visit method", func() {
+			expected := errors.New("visit error")
+			v := &mockVisitor{Error: expected}
+			m := &BatchConfig{}
+
+			err := m.Accept(v)
+
+			Expect(err).To(Equal(expected))
+			Expect(v.VisitedMessage).To(Equal(m))
+		})
+	})
+
+	Describe("read", func() {
+		It("decodes the message", func() {
+			buf := []byte{
+				'B', 'C',
+				0, 13, // header length
+			}
+			buf = append(buf, `[50,100,4096]`...)
+
+			r := bytes.NewReader(buf)
+			m, err := Read(r, JSONEncoding)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(m).To(Equal(&BatchConfig{
+				batchConfigHeader: batchConfigHeader{
+					MaxDelay: 50 * time.Millisecond,
+					MaxCount: 100,
+					MaxBytes: 4096,
+				},
+			}))
+		})
+	})
+})
+
+var _ = Describe("Batch", func() {
+	Describe("write", func() {
+		It("encodes the message", func() {
+			var buf bytes.Buffer
+			m := NewBatch([][]byte{
+				[]byte("abc"),
+				[]byte("de"),
+			})
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(buf.Bytes()).To(Equal([]byte{
+				'B', 'A',
+				0, 2, // message count
+				0, 0, 0, 3, 'a', 'b', 'c',
+				0, 0, 0, 2, 'd', 'e',
+			}))
+		})
+	})
+})
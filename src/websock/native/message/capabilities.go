@@ -0,0 +1,32 @@
+package message
+
+import (
+	"io"
+)
+
+// Capabilities is an incoming message by which the client declares the
+// optional protocol features that it supports. Features that change the
+// format of existing outgoing messages are only used once the client has
+// declared support for them.
+//
+// Capabilities apply to the whole connection, so the message does not have a
+// session index.
+type Capabilities struct {
+	capabilitiesHeader
+}
+
+// capabilitiesHeader is the header structure for Capabilities messages.
+type capabilitiesHeader struct {
+	// SessionDestroyReason is true if the client accepts a header containing
+	// the reason in outgoing SessionDestroy messages.
+	SessionDestroyReason bool
+}
+
+// Accept calls the appropriate visit method on v.
+func (m *Capabilities) Accept(v Visitor) error {
+	return v.VisitCapabilities(m)
+}
+
+func (m *Capabilities) read(r io.Reader, e Encoding) error {
+	return e.DecodeHeader(r, &m.capabilitiesHeader)
+}
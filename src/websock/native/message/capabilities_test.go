@@ -0,0 +1,44 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capabilities", func() {
+	Describe("Accept", func() {
+		It("invokes the correct visit method", func() {
+			expected := errors.New("visit error")
+			v := &mockVisitor{Error: expected}
+			m := &Capabilities{}
+
+			err := m.Accept(v)
+
+			Expect(err).To(Equal(expected))
+			Expect(v.VisitedMessage).To(Equal(m))
+		})
+	})
+
+	Describe("read", func() {
+		It("decodes the message", func() {
+			buf := []byte{
+				'P', 'C',
+				0, 6, // header length
+			}
+			buf = append(buf, `[true]`...)
+
+			r := bytes.NewReader(buf)
+			m, err := Read(r, JSONEncoding)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(m).To(Equal(&Capabilities{
+				capabilitiesHeader: capabilitiesHeader{
+					SessionDestroyReason: true,
+				},
+			}))
+		})
+	})
+})
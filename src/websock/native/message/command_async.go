@@ -20,6 +20,9 @@ type asyncCallHeader struct {
 	Namespace string
 	Command   string
 	Timeout   time.Duration
+
+	// TraceID is an optional trace ID that is attached to the call.
+	TraceID string
 }
 
 // Accept calls the appropriate visit method on v.
@@ -48,6 +51,9 @@ type AsyncSuccess struct {
 	preamble
 	asyncSuccessHeader
 
+	// TraceID is the trace ID of the call, if any.
+	TraceID string
+
 	Payload *rinq.Payload
 }
 
@@ -57,11 +63,28 @@ type asyncSuccessHeader struct {
 	Command   string
 }
 
+// tracedAsyncSuccessHeader is the header structure for AsyncSuccess messages
+// that have a trace ID. The additional field is only sent when non-empty, so
+// clients that do not send trace IDs are unaffected.
+type tracedAsyncSuccessHeader struct {
+	Namespace string
+	Command   string
+	TraceID   string
+}
+
 func (m *AsyncSuccess) write(w io.Writer, e Encoding) (err error) {
 	err = m.preamble.write(w, commandAsyncSuccessType)
 
 	if err == nil {
-		err = e.EncodeHeader(w, m.asyncSuccessHeader)
+		if m.TraceID == "" {
+			err = e.EncodeHeader(w, m.asyncSuccessHeader)
+		} else {
+			err = e.EncodeHeader(w, tracedAsyncSuccessHeader{
+				Namespace: m.Namespace,
+				Command:   m.Command,
+				TraceID:   m.TraceID,
+			})
+		}
 
 		if err == nil {
 			err = e.EncodePayload(w, m.Payload)
@@ -77,6 +100,9 @@ type AsyncFailure struct {
 	preamble
 	asyncFailureHeader
 
+	// TraceID is the trace ID of the call, if any.
+	TraceID string
+
 	Payload *rinq.Payload
 }
 
@@ -88,11 +114,31 @@ type asyncFailureHeader struct {
 	FailureMessage string
 }
 
+// tracedAsyncFailureHeader is the header structure for AsyncFailure messages
+// that have a trace ID.
+type tracedAsyncFailureHeader struct {
+	Namespace      string
+	Command        string
+	FailureType    string
+	FailureMessage string
+	TraceID        string
+}
+
 func (m *AsyncFailure) write(w io.Writer, e Encoding) (err error) {
 	err = m.preamble.write(w, commandAsyncFailureType)
 
 	if err == nil {
-		err = e.EncodeHeader(w, m.asyncFailureHeader)
+		if m.TraceID == "" {
+			err = e.EncodeHeader(w, m.asyncFailureHeader)
+		} else {
+			err = e.EncodeHeader(w, tracedAsyncFailureHeader{
+				Namespace:      m.Namespace,
+				Command:        m.Command,
+				FailureType:    m.FailureType,
+				FailureMessage: m.FailureMessage,
+				TraceID:        m.TraceID,
+			})
+		}
 
 		if err == nil {
 			err = e.EncodePayload(w, m.Payload)
@@ -107,6 +153,9 @@ func (m *AsyncFailure) write(w io.Writer, e Encoding) (err error) {
 type AsyncError struct {
 	preamble
 	asyncErrorHeader
+
+	// TraceID is the trace ID of the call, if any.
+	TraceID string
 }
 
 // asyncErrorHeader is the header structure for AsyncError messages.
@@ -115,21 +164,39 @@ type asyncErrorHeader struct {
 	Command   string
 }
 
+// tracedAsyncErrorHeader is the header structure for AsyncError messages that
+// have a trace ID.
+type tracedAsyncErrorHeader struct {
+	Namespace string
+	Command   string
+	TraceID   string
+}
+
 func (m *AsyncError) write(w io.Writer, e Encoding) (err error) {
 	err = m.preamble.write(w, commandAsyncErrorType)
 
 	if err == nil {
-		err = e.EncodeHeader(w, m.asyncErrorHeader)
+		if m.TraceID == "" {
+			err = e.EncodeHeader(w, m.asyncErrorHeader)
+		} else {
+			err = e.EncodeHeader(w, tracedAsyncErrorHeader{
+				Namespace: m.Namespace,
+				Command:   m.Command,
+				TraceID:   m.TraceID,
+			})
+		}
 	}
 
 	return
 }
 
 // NewAsyncResponse returns an outgoing message to send an asynchronous command
-// response to the client.
+// response to the client. The trace ID is included in the response if it is
+// non-empty.
 func NewAsyncResponse(
 	session SessionIndex,
 	ns, cmd string,
+	traceID string,
 	p *rinq.Payload, err error,
 ) (Outgoing, bool) {
 	switch e := err.(type) {
@@ -140,6 +207,7 @@ func NewAsyncResponse(
 				Namespace: ns,
 				Command:   cmd,
 			},
+			TraceID: traceID,
 			Payload: p,
 		}, true
 
@@ -152,6 +220,7 @@ func NewAsyncResponse(
 				FailureType:    e.Type,
 				FailureMessage: e.Message,
 			},
+			TraceID: traceID,
 			Payload: p,
 		}, true
 
@@ -162,6 +231,7 @@ func NewAsyncResponse(
 				Namespace: ns,
 				Command:   cmd,
 			},
+			TraceID: traceID,
 		}, true
 	}
 
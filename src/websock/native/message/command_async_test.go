@@ -50,6 +50,22 @@ var _ = Describe("AsyncCall", func() {
 			}
 			Expect(m).To(Equal(expected))
 		})
+
+		It("decodes the trace ID", func() {
+			buf := []byte{
+				'A', 'C',
+				0xab, 0xcd, // session index
+				0, 26, // header length
+			}
+			buf = append(buf, `["ns","cmd",456,"trace-1"]`...)
+			buf = append(buf, `"payload"`...)
+
+			r := bytes.NewReader(buf)
+			m, err := Read(r, JSONEncoding)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(m.(*AsyncCall).TraceID).To(Equal("trace-1"))
+		})
 	})
 })
 
@@ -80,6 +96,32 @@ var _ = Describe("AsyncSuccess", func() {
 			expected = append(expected, `"payload"`...)
 			Expect(buf.Bytes()).To(Equal(expected))
 		})
+
+		It("encodes the trace ID if it is non-empty", func() {
+			var buf bytes.Buffer
+			m := &AsyncSuccess{
+				preamble: preamble{0xabcd},
+				asyncSuccessHeader: asyncSuccessHeader{
+					Namespace: "ns",
+					Command:   "cmd",
+				},
+				TraceID: "trace-1",
+				Payload: rinq.NewPayload("payload"),
+			}
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'A', 'S',
+				0xab, 0xcd, // session index
+				0, 22, // header size
+			}
+			expected = append(expected, `["ns","cmd","trace-1"]`...)
+			expected = append(expected, `"payload"`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
 	})
 })
 
@@ -145,7 +187,7 @@ var _ = Describe("AsyncError", func() {
 var _ = Describe("NewAsyncResponse", func() {
 	It("returns a success response if err is nil", func() {
 		p := rinq.NewPayload(456)
-		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", p, nil)
+		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", "", p, nil)
 
 		Expect(m).To(Equal(&AsyncSuccess{
 			preamble: preamble{0xabcd},
@@ -167,7 +209,7 @@ var _ = Describe("NewAsyncResponse", func() {
 			Payload: p,
 		}
 
-		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", p, err)
+		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", "", p, err)
 
 		Expect(m).To(Equal(&AsyncFailure{
 			preamble: preamble{0xabcd},
@@ -185,7 +227,7 @@ var _ = Describe("NewAsyncResponse", func() {
 
 	It("returns an error response if err is a command error", func() {
 		err := rinq.CommandError("error")
-		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", nil, err)
+		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", "", nil, err)
 
 		Expect(m).To(Equal(&AsyncError{
 			preamble: preamble{0xabcd},
@@ -200,7 +242,7 @@ var _ = Describe("NewAsyncResponse", func() {
 
 	It("returns false for other errors", func() {
 		err := errors.New("error")
-		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", nil, err)
+		m, ok := NewAsyncResponse(0xabcd, "ns", "cmd", "", nil, err)
 
 		Expect(m).To(BeNil())
 		Expect(ok).To(BeFalse())
@@ -18,6 +18,9 @@ type Execute struct {
 type executeHeader struct {
 	Namespace string
 	Command   string
+
+	// TraceID is an optional trace ID that is attached to the execution.
+	TraceID string
 }
 
 // Accept calls the appropriate visit method on v.
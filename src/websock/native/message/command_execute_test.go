@@ -48,5 +48,21 @@ var _ = Describe("Execute", func() {
 			}
 			Expect(m).To(Equal(expected))
 		})
+
+		It("decodes the trace ID", func() {
+			buf := []byte{
+				'C', 'X',
+				0xab, 0xcd, // session index
+				0, 22, // header length
+			}
+			buf = append(buf, `["ns","cmd","trace-1"]`...)
+			buf = append(buf, `"payload"`...)
+
+			r := bytes.NewReader(buf)
+			m, err := Read(r, JSONEncoding)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(m.(*Execute).TraceID).To(Equal("trace-1"))
+		})
 	})
 })
@@ -0,0 +1,95 @@
+package message
+
+import "io"
+
+// RejectReason describes why a command request was not forwarded to Rinq.
+type RejectReason string
+
+const (
+	// RejectRateLimited indicates that the client has exceeded a rate limit.
+	RejectRateLimited RejectReason = "rate-limited"
+
+	// RejectOverloaded indicates that the client has too many calls in
+	// progress.
+	RejectOverloaded RejectReason = "overloaded"
+
+	// RejectUnavailable indicates that the namespace is temporarily
+	// unavailable, because calls to it are failing.
+	RejectUnavailable RejectReason = "unavailable"
+
+	// RejectPayloadTooLarge indicates that the request payload is larger than
+	// the call policy permits.
+	RejectPayloadTooLarge RejectReason = "payload-too-large"
+)
+
+// SyncRejection is an outgoing message indicating that a synchronous call was
+// rejected by the server without being forwarded to Rinq.
+type SyncRejection struct {
+	preamble
+	syncRejectionHeader
+}
+
+// syncRejectionHeader is the header structure for SyncRejection messages.
+type syncRejectionHeader struct {
+	Seq    uint
+	Reason RejectReason
+}
+
+// NewSyncRejection returns an outgoing message to inform the client that
+// a synchronous call has been rejected.
+func NewSyncRejection(session SessionIndex, seq uint, r RejectReason) *SyncRejection {
+	return &SyncRejection{
+		preamble: preamble{session},
+		syncRejectionHeader: syncRejectionHeader{
+			Seq:    seq,
+			Reason: r,
+		},
+	}
+}
+
+func (m *SyncRejection) write(w io.Writer, e Encoding) (err error) {
+	err = m.preamble.write(w, commandSyncRejectType)
+
+	if err == nil {
+		err = e.EncodeHeader(w, m.syncRejectionHeader)
+	}
+
+	return
+}
+
+// AsyncRejection is an outgoing message indicating that an asynchronous call
+// was rejected by the server without being forwarded to Rinq.
+type AsyncRejection struct {
+	preamble
+	asyncRejectionHeader
+}
+
+// asyncRejectionHeader is the header structure for AsyncRejection messages.
+type asyncRejectionHeader struct {
+	Namespace string
+	Command   string
+	Reason    RejectReason
+}
+
+// NewAsyncRejection returns an outgoing message to inform the client that an
+// asynchronous call has been rejected.
+func NewAsyncRejection(session SessionIndex, ns, cmd string, r RejectReason) *AsyncRejection {
+	return &AsyncRejection{
+		preamble: preamble{session},
+		asyncRejectionHeader: asyncRejectionHeader{
+			Namespace: ns,
+			Command:   cmd,
+			Reason:    r,
+		},
+	}
+}
+
+func (m *AsyncRejection) write(w io.Writer, e Encoding) (err error) {
+	err = m.preamble.write(w, commandAsyncRejectType)
+
+	if err == nil {
+		err = e.EncodeHeader(w, m.asyncRejectionHeader)
+	}
+
+	return
+}
@@ -0,0 +1,50 @@
+package message
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SyncRejection", func() {
+	Describe("write", func() {
+		It("encodes the message", func() {
+			var buf bytes.Buffer
+			m := NewSyncRejection(0xabcd, 123, RejectRateLimited)
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'C', 'R',
+				0xab, 0xcd, // session index
+				0, 20, // header size
+			}
+			expected = append(expected, `[123,"rate-limited"]`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
+	})
+})
+
+var _ = Describe("AsyncRejection", func() {
+	Describe("write", func() {
+		It("encodes the message", func() {
+			var buf bytes.Buffer
+			m := NewAsyncRejection(0xabcd, "ns", "cmd", RejectRateLimited)
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'A', 'R',
+				0xab, 0xcd, // session index
+				0, 27, // header size
+			}
+			expected = append(expected, `["ns","cmd","rate-limited"]`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
+	})
+})
@@ -21,6 +21,14 @@ type syncCallHeader struct {
 	Namespace string
 	Command   string
 	Timeout   time.Duration
+
+	// IdempotencyKey is an optional client-chosen key that identifies retries
+	// of the same call, such that the command is only invoked once.
+	IdempotencyKey string
+
+	// TraceID is an optional trace ID that is attached to the call, and echoed
+	// in the response.
+	TraceID string
 }
 
 // Accept calls the appropriate visit method on v.
@@ -49,6 +57,9 @@ type SyncSuccess struct {
 	preamble
 	syncSuccessHeader
 
+	// TraceID is the trace ID of the call, if any.
+	TraceID string
+
 	Payload *rinq.Payload
 }
 
@@ -57,11 +68,26 @@ type syncSuccessHeader struct {
 	Seq uint
 }
 
+// tracedSyncSuccessHeader is the header structure for SyncSuccess messages
+// that have a trace ID. The additional field is only sent when non-empty, so
+// clients that do not send trace IDs are unaffected.
+type tracedSyncSuccessHeader struct {
+	Seq     uint
+	TraceID string
+}
+
 func (m *SyncSuccess) write(w io.Writer, e Encoding) (err error) {
 	err = m.preamble.write(w, commandSyncSuccessType)
 
 	if err == nil {
-		err = e.EncodeHeader(w, m.syncSuccessHeader)
+		if m.TraceID == "" {
+			err = e.EncodeHeader(w, m.syncSuccessHeader)
+		} else {
+			err = e.EncodeHeader(w, tracedSyncSuccessHeader{
+				Seq:     m.Seq,
+				TraceID: m.TraceID,
+			})
+		}
 
 		if err == nil {
 			err = e.EncodePayload(w, m.Payload)
@@ -77,6 +103,9 @@ type SyncFailure struct {
 	preamble
 	syncFailureHeader
 
+	// TraceID is the trace ID of the call, if any.
+	TraceID string
+
 	Payload *rinq.Payload
 }
 
@@ -87,11 +116,29 @@ type syncFailureHeader struct {
 	FailureMessage string
 }
 
+// tracedSyncFailureHeader is the header structure for SyncFailure messages
+// that have a trace ID.
+type tracedSyncFailureHeader struct {
+	Seq            uint
+	FailureType    string
+	FailureMessage string
+	TraceID        string
+}
+
 func (m *SyncFailure) write(w io.Writer, e Encoding) (err error) {
 	err = m.preamble.write(w, commandSyncFailureType)
 
 	if err == nil {
-		err = e.EncodeHeader(w, m.syncFailureHeader)
+		if m.TraceID == "" {
+			err = e.EncodeHeader(w, m.syncFailureHeader)
+		} else {
+			err = e.EncodeHeader(w, tracedSyncFailureHeader{
+				Seq:            m.Seq,
+				FailureType:    m.FailureType,
+				FailureMessage: m.FailureMessage,
+				TraceID:        m.TraceID,
+			})
+		}
 
 		if err == nil {
 			err = e.EncodePayload(w, m.Payload)
@@ -106,6 +153,9 @@ func (m *SyncFailure) write(w io.Writer, e Encoding) (err error) {
 type SyncError struct {
 	preamble
 	syncErrorHeader
+
+	// TraceID is the trace ID of the call, if any.
+	TraceID string
 }
 
 // syncErrorHeader is the header structure for SyncError messages.
@@ -113,11 +163,25 @@ type syncErrorHeader struct {
 	Seq uint
 }
 
+// tracedSyncErrorHeader is the header structure for SyncError messages that
+// have a trace ID.
+type tracedSyncErrorHeader struct {
+	Seq     uint
+	TraceID string
+}
+
 func (m *SyncError) write(w io.Writer, e Encoding) (err error) {
 	err = m.preamble.write(w, commandSyncErrorType)
 
 	if err == nil {
-		err = e.EncodeHeader(w, m.syncErrorHeader)
+		if m.TraceID == "" {
+			err = e.EncodeHeader(w, m.syncErrorHeader)
+		} else {
+			err = e.EncodeHeader(w, tracedSyncErrorHeader{
+				Seq:     m.Seq,
+				TraceID: m.TraceID,
+			})
+		}
 	}
 
 	return
@@ -130,9 +194,12 @@ func (m *SyncError) write(w io.Writer, e Encoding) (err error) {
 // not a Rinq error. This ensures that sensitive error messages can not leak to
 // the client, and forces the client side to deal with this in the form of
 // a client-side timeout.
+//
+// The trace ID is included in the response if it is non-empty.
 func NewSyncResponse(
 	session SessionIndex,
 	seq uint,
+	traceID string,
 	p *rinq.Payload, err error,
 ) (Outgoing, bool) {
 	switch e := err.(type) {
@@ -140,6 +207,7 @@ func NewSyncResponse(
 		return &SyncSuccess{
 			preamble:          preamble{session},
 			syncSuccessHeader: syncSuccessHeader{Seq: seq},
+			TraceID:           traceID,
 			Payload:           p,
 		}, true
 
@@ -151,6 +219,7 @@ func NewSyncResponse(
 				FailureType:    e.Type,
 				FailureMessage: e.Message,
 			},
+			TraceID: traceID,
 			Payload: p,
 		}, true
 
@@ -158,6 +227,7 @@ func NewSyncResponse(
 		return &SyncError{
 			preamble:        preamble{session},
 			syncErrorHeader: syncErrorHeader{Seq: seq},
+			TraceID:         traceID,
 		}, true
 	}
 
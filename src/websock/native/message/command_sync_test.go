@@ -52,6 +52,38 @@ var _ = Describe("SyncCall", func() {
 			}
 			Expect(m).To(Equal(expected))
 		})
+
+		It("decodes the idempotency key", func() {
+			buf := []byte{
+				'C', 'C',
+				0xab, 0xcd, // session index
+				0, 26, // header length
+			}
+			buf = append(buf, `[123,"ns","cmd",456,"key"]`...)
+			buf = append(buf, `"payload"`...)
+
+			r := bytes.NewReader(buf)
+			m, err := Read(r, JSONEncoding)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(m.(*SyncCall).IdempotencyKey).To(Equal("key"))
+		})
+
+		It("decodes the trace ID", func() {
+			buf := []byte{
+				'C', 'C',
+				0xab, 0xcd, // session index
+				0, 34, // header length
+			}
+			buf = append(buf, `[123,"ns","cmd",456,"","trace-1"]`...)
+			buf = append(buf, `"payload"`...)
+
+			r := bytes.NewReader(buf)
+			m, err := Read(r, JSONEncoding)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(m.(*SyncCall).TraceID).To(Equal("trace-1"))
+		})
 	})
 })
 
@@ -81,6 +113,31 @@ var _ = Describe("SyncSuccess", func() {
 			expected = append(expected, `"payload"`...)
 			Expect(buf.Bytes()).To(Equal(expected))
 		})
+
+		It("encodes the trace ID if it is non-empty", func() {
+			var buf bytes.Buffer
+			m := &SyncSuccess{
+				preamble: preamble{0xabcd},
+				syncSuccessHeader: syncSuccessHeader{
+					Seq: 123,
+				},
+				TraceID: "trace-1",
+				Payload: rinq.NewPayload("payload"),
+			}
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'C', 'S',
+				0xab, 0xcd, // session index
+				0, 15, // header size
+			}
+			expected = append(expected, `[123,"trace-1"]`...)
+			expected = append(expected, `"payload"`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
 	})
 })
 
@@ -112,6 +169,33 @@ var _ = Describe("SyncFailure", func() {
 			expected = append(expected, `"payload"`...)
 			Expect(buf.Bytes()).To(Equal(expected))
 		})
+
+		It("encodes the trace ID if it is non-empty", func() {
+			var buf bytes.Buffer
+			m := &SyncFailure{
+				preamble: preamble{0xabcd},
+				syncFailureHeader: syncFailureHeader{
+					Seq:            123,
+					FailureType:    "fail-type",
+					FailureMessage: "message",
+				},
+				TraceID: "trace-1",
+				Payload: rinq.NewPayload("payload"),
+			}
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'C', 'F',
+				0xab, 0xcd, // session index
+				0, 37, // header size
+			}
+			expected = append(expected, `[123,"fail-type","message","trace-1"]`...)
+			expected = append(expected, `"payload"`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
 	})
 })
 
@@ -144,7 +228,7 @@ var _ = Describe("SyncError", func() {
 var _ = Describe("NewSyncResponse", func() {
 	It("returns a success response if err is nil", func() {
 		p := rinq.NewPayload(456)
-		m, ok := NewSyncResponse(0xabcd, 123, p, nil)
+		m, ok := NewSyncResponse(0xabcd, 123, "", p, nil)
 
 		Expect(m).To(Equal(&SyncSuccess{
 			preamble:          preamble{0xabcd},
@@ -163,7 +247,7 @@ var _ = Describe("NewSyncResponse", func() {
 			Payload: p,
 		}
 
-		m, ok := NewSyncResponse(0xabcd, 123, p, err)
+		m, ok := NewSyncResponse(0xabcd, 123, "", p, err)
 
 		Expect(m).To(Equal(&SyncFailure{
 			preamble: preamble{0xabcd},
@@ -178,9 +262,21 @@ var _ = Describe("NewSyncResponse", func() {
 		Expect(ok).To(BeTrue())
 	})
 
+	It("includes the trace ID in the response", func() {
+		m, ok := NewSyncResponse(0xabcd, 123, "trace-1", nil, rinq.CommandError("error"))
+
+		Expect(m).To(Equal(&SyncError{
+			preamble:        preamble{0xabcd},
+			syncErrorHeader: syncErrorHeader{Seq: 123},
+			TraceID:         "trace-1",
+		}))
+
+		Expect(ok).To(BeTrue())
+	})
+
 	It("returns an error response if err is a command error", func() {
 		err := rinq.CommandError("error")
-		m, ok := NewSyncResponse(0xabcd, 123, nil, err)
+		m, ok := NewSyncResponse(0xabcd, 123, "", nil, err)
 
 		Expect(m).To(Equal(&SyncError{
 			preamble:        preamble{0xabcd},
@@ -192,7 +288,7 @@ var _ = Describe("NewSyncResponse", func() {
 
 	It("returns false for other errors", func() {
 		err := errors.New("error")
-		m, ok := NewSyncResponse(0xabcd, 123, nil, err)
+		m, ok := NewSyncResponse(0xabcd, 123, "", nil, err)
 
 		Expect(m).To(BeNil())
 		Expect(ok).To(BeFalse())
@@ -204,7 +300,7 @@ var _ = Describe("NewSyncResponse", func() {
 		// doesn't currently receive timeout errors from the websocket
 		// server
 		err := context.DeadlineExceeded
-		m, ok := NewSyncResponse(0xabcd, 123, nil, err)
+		m, ok := NewSyncResponse(0xabcd, 123, "", nil, err)
 
 		Expect(m).To(BeNil())
 		Expect(ok).To(BeFalse())
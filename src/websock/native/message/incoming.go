@@ -39,6 +39,10 @@ func Read(r io.Reader, e Encoding) (msg Incoming, err error) {
 			msg = &AsyncCall{}
 		case commandExecuteType:
 			msg = &Execute{}
+		case batchConfigType:
+			msg = &BatchConfig{}
+		case capabilitiesType:
+			msg = &Capabilities{}
 		default:
 			err = fmt.Errorf("unrecognized incoming message type: 0x%04x", mt)
 			return
@@ -67,4 +71,6 @@ type Visitor interface {
 	VisitSyncCall(*SyncCall) error
 	VisitAsyncCall(*AsyncCall) error
 	VisitExecute(*Execute) error
+	VisitBatchConfig(*BatchConfig) error
+	VisitCapabilities(*Capabilities) error
 }
@@ -13,3 +13,13 @@ type Outgoing interface {
 func Write(w io.Writer, e Encoding, m Outgoing) error {
 	return m.write(w, e)
 }
+
+// Encoded is an outgoing message that has already been encoded, such as a
+// message that was queued for a connection that closed before it could be
+// written. It is written verbatim, regardless of the encoding.
+type Encoded []byte
+
+func (m Encoded) write(w io.Writer, _ Encoding) error {
+	_, err := w.Write(m)
+	return err
+}
@@ -0,0 +1,22 @@
+package message
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encoded", func() {
+	Describe("write", func() {
+		It("writes the message verbatim", func() {
+			var buf bytes.Buffer
+			m := Encoded("<frame>")
+
+			err := Write(&buf, CBOREncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(buf.String()).To(Equal("<frame>"))
+		})
+	})
+})
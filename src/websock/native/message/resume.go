@@ -0,0 +1,44 @@
+package message
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// ResumeToken is an outgoing message containing the token that the client
+// may present to resume its sessions on a new connection, after the current
+// connection is lost.
+//
+// It is sent at the start of each connection when session resumption is
+// enabled. The token applies to the whole connection, so the message does not
+// have a session index.
+type ResumeToken struct {
+	resumeTokenHeader
+}
+
+// resumeTokenHeader is the header structure for ResumeToken messages.
+type resumeTokenHeader struct {
+	Token string
+
+	// Resumed is true if the sessions of a previous connection were resumed.
+	Resumed bool
+}
+
+// NewResumeToken returns an outgoing message to inform the client of its
+// resume token.
+func NewResumeToken(token string, resumed bool) *ResumeToken {
+	return &ResumeToken{
+		resumeTokenHeader{
+			Token:   token,
+			Resumed: resumed,
+		},
+	}
+}
+
+func (m *ResumeToken) write(w io.Writer, e Encoding) error {
+	if err := binary.Write(w, binary.BigEndian, resumeTokenType); err != nil {
+		return err
+	}
+
+	return e.EncodeHeader(w, m.resumeTokenHeader)
+}
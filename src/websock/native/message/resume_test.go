@@ -0,0 +1,28 @@
+package message
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResumeToken", func() {
+	Describe("write", func() {
+		It("encodes the message", func() {
+			var buf bytes.Buffer
+			m := NewResumeToken("token", true)
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'R', 'T',
+				0, 14, // header size
+			}
+			expected = append(expected, `["token",true]`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
+	})
+})
@@ -27,6 +27,18 @@ func (m *SessionCreate) read(r io.Reader, _ Encoding) error {
 // destroyed without being requested by the client.
 type SessionDestroy struct {
 	preamble
+
+	// Reason is an optional explanation of why the session was destroyed, such
+	// as when it is destroyed by an administrator. It is only sent to the
+	// client, in a header, when non-empty. It must only be set if the client
+	// has declared the SessionDestroyReason capability.
+	Reason string
+}
+
+// sessionDestroyHeader is the header structure for outgoing SessionDestroy
+// messages that have a reason.
+type sessionDestroyHeader struct {
+	Reason string
 }
 
 // NewSessionDestroy returns an outgoing message to send a notification to the client.
@@ -45,6 +57,12 @@ func (m *SessionDestroy) read(r io.Reader, _ Encoding) error {
 	return m.preamble.read(r)
 }
 
-func (m *SessionDestroy) write(w io.Writer, _ Encoding) error {
-	return m.preamble.write(w, sessionDestroyType)
+func (m *SessionDestroy) write(w io.Writer, e Encoding) error {
+	err := m.preamble.write(w, sessionDestroyType)
+
+	if err == nil && m.Reason != "" {
+		err = e.EncodeHeader(w, sessionDestroyHeader{m.Reason})
+	}
+
+	return err
 }
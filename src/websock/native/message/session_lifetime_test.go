@@ -88,6 +88,26 @@ var _ = Describe("SessionDestroy", func() {
 			}
 			Expect(buf.Bytes()).To(Equal(expected))
 		})
+
+		It("includes the reason in a header, if present", func() {
+			var buf bytes.Buffer
+			m := &SessionDestroy{
+				preamble: preamble{0xabcd},
+				Reason:   "kicked",
+			}
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'S', 'D',
+				0xab, 0xcd, // session index
+				0, 10, // header size
+			}
+			expected = append(expected, `["kicked"]`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
 	})
 })
 
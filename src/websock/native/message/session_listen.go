@@ -11,8 +11,29 @@ type Listen struct {
 
 type listenHeader struct {
 	Namespaces []string
+
+	// Conflate is the conflation mode to use for notifications received in
+	// the namespaces. It is optional, and ignored by Unlisten messages.
+	Conflate ConflateMode
 }
 
+// ConflateMode controls whether notifications that are waiting to be sent to a
+// slow client are replaced by newer notifications of the same type.
+type ConflateMode string
+
+const (
+	// ConflateNone delivers every notification.
+	ConflateNone ConflateMode = ""
+
+	// ConflateType replaces queued notifications with the same namespace and
+	// type, regardless of which session they were received by.
+	ConflateType ConflateMode = "type"
+
+	// ConflateSession replaces queued notifications with the same namespace
+	// and type that were received by the same session.
+	ConflateSession ConflateMode = "session"
+)
+
 // Accept calls the appropriate visit method on v.
 func (m *Listen) Accept(v Visitor) error {
 	return v.VisitListen(m)
@@ -42,6 +42,27 @@ var _ = Describe("Listen", func() {
 				},
 			}))
 		})
+
+		It("decodes the conflation mode", func() {
+			buf := []byte{
+				'N', 'L',
+				0xab, 0xcd, // session index
+				0, 15, // header length
+			}
+			buf = append(buf, `[["ns"],"type"]`...)
+
+			r := bytes.NewReader(buf)
+			m, err := Read(r, JSONEncoding)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(m).To(Equal(&Listen{
+				preamble: preamble{0xabcd},
+				listenHeader: listenHeader{
+					Namespaces: []string{"ns"},
+					Conflate:   ConflateType,
+				},
+			}))
+		})
 	})
 })
 
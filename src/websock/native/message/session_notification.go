@@ -12,6 +12,11 @@ type Notification struct {
 	preamble
 	notificationHeader
 
+	// Dropped is the number of earlier notifications with the same namespace
+	// and type that were discarded in favour of this one, because the session
+	// is listening with conflation enabled.
+	Dropped uint64
+
 	Payload *rinq.Payload
 }
 
@@ -33,11 +38,28 @@ type notificationHeader struct {
 	Type      string
 }
 
+// conflatedNotificationHeader is the header structure for Notification
+// messages that replace dropped notifications. The additional field is only
+// sent when non-zero, so clients that do not use conflation are unaffected.
+type conflatedNotificationHeader struct {
+	Namespace string
+	Type      string
+	Dropped   uint64
+}
+
 func (m *Notification) write(w io.Writer, e Encoding) (err error) {
 	err = m.preamble.write(w, sessionNotificationType)
 
 	if err == nil {
-		err = e.EncodeHeader(w, m.notificationHeader)
+		if m.Dropped == 0 {
+			err = e.EncodeHeader(w, m.notificationHeader)
+		} else {
+			err = e.EncodeHeader(w, conflatedNotificationHeader{
+				Namespace: m.Namespace,
+				Type:      m.Type,
+				Dropped:   m.Dropped,
+			})
+		}
 
 		if err == nil {
 			err = e.EncodePayload(w, m.Payload)
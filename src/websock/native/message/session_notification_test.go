@@ -35,6 +35,33 @@ var _ = Describe("Notification", func() {
 			expected = append(expected, `"payload"`...)
 			Expect(buf.Bytes()).To(Equal(expected))
 		})
+
+		It("includes the number of dropped notifications, if any", func() {
+			var buf bytes.Buffer
+			p := rinq.NewPayload("payload")
+			m := &Notification{
+				preamble: preamble{0xabcd},
+				notificationHeader: notificationHeader{
+					Namespace: "ns",
+					Type:      "type",
+				},
+				Dropped: 3,
+				Payload: p,
+			}
+
+			err := Write(&buf, JSONEncoding, m)
+
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expected := []byte{
+				'N', 'O',
+				0xab, 0xcd, // session index
+				0, 15, // header size
+			}
+			expected = append(expected, `["ns","type",3]`...)
+			expected = append(expected, `"payload"`...)
+			Expect(buf.Bytes()).To(Equal(expected))
+		})
 	})
 })
 
@@ -41,3 +41,13 @@ func (v *mockVisitor) VisitExecute(m *Execute) error {
 	v.VisitedMessage = m
 	return v.Error
 }
+
+func (v *mockVisitor) VisitBatchConfig(m *BatchConfig) error {
+	v.VisitedMessage = m
+	return v.Error
+}
+
+func (v *mockVisitor) VisitCapabilities(m *Capabilities) error {
+	v.VisitedMessage = m
+	return v.Error
+}
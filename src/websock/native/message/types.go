@@ -26,11 +26,20 @@ const (
 	commandSyncSuccessType messageType = 'C'<<8 | 'S'
 	commandSyncFailureType messageType = 'C'<<8 | 'F'
 	commandSyncErrorType   messageType = 'C'<<8 | 'E'
+	commandSyncRejectType  messageType = 'C'<<8 | 'R'
 
 	commandAsyncCallType    messageType = 'A'<<8 | 'C'
 	commandAsyncSuccessType messageType = 'A'<<8 | 'S'
 	commandAsyncFailureType messageType = 'A'<<8 | 'F'
 	commandAsyncErrorType   messageType = 'A'<<8 | 'E'
+	commandAsyncRejectType  messageType = 'A'<<8 | 'R'
 
 	commandExecuteType messageType = 'C'<<8 | 'X'
+
+	batchConfigType messageType = 'B'<<8 | 'C'
+	batchType       messageType = 'B'<<8 | 'A'
+
+	resumeTokenType messageType = 'R'<<8 | 'T'
+
+	capabilitiesType messageType = 'P'<<8 | 'C'
 )
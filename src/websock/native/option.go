@@ -1,6 +1,10 @@
 package native
 
-import "time"
+import (
+	"time"
+
+	"github.com/rinq/httpd/src/internal/ratelimit"
+)
 
 // Option modifies how a given Handler handles messages from Rinq connections that the Handler manages.
 // Options are typically applied to the Handler by passing them to NewHandler().
@@ -8,9 +12,15 @@ type Option interface {
 	modify(*visitor)
 }
 
+// handlerOption is an Option that also modifies the Handler itself.
+type handlerOption interface {
+	Option
+	modifyHandler(*Handler)
+}
+
 // MaxCallTimeout sets the maximum time a call can be. The given time will
-// override any timeout set by the client where the clients' timeout is
-// longer.
+// override any timeout set by the client or a call policy where that timeout is
+// longer. It applies to both sync and async calls.
 func MaxCallTimeout(max time.Duration) Option {
 	return &maxCallTimeout{max}
 }
@@ -20,5 +30,292 @@ type maxCallTimeout struct {
 }
 
 func (m *maxCallTimeout) modify(v *visitor) {
-	v.syncCallTimeout = m.max
+	v.maxCallTimeout = m.max
+}
+
+// Policies applies the call policies in p to command requests.
+func Policies(p *CallPolicies) Option {
+	return &policies{p}
+}
+
+type policies struct {
+	policies *CallPolicies
+}
+
+func (o *policies) modify(v *visitor) {
+	v.policies = o.policies
+}
+
+// MaxSessions sets the maximum number of sessions that may be open at the same
+// time on a single connection. Requests to create a session beyond the limit
+// are answered by destroying the new session immediately. A limit of zero
+// means there is no limit.
+func MaxSessions(max int) Option {
+	return &maxSessions{max}
+}
+
+type maxSessions struct {
+	max int
+}
+
+func (m *maxSessions) modify(v *visitor) {
+	v.maxSessions = m.max
+}
+
+// FrameRateLimit limits the rate at which incoming messages of any kind are
+// processed on a single connection, to rate messages per second with bursts
+// of up to burst messages.
+func FrameRateLimit(rate float64, burst int) Option {
+	return &frameRateLimit{rate, burst}
+}
+
+type frameRateLimit struct {
+	rate  float64
+	burst int
+}
+
+func (o *frameRateLimit) modify(v *visitor) {
+	v.limits.frames = ratelimit.NewBucket(o.rate, o.burst)
+}
+
+// CallRateLimit limits the rate at which command requests (calls and
+// executes) are forwarded to Rinq from a single connection, to rate requests
+// per second with bursts of up to burst requests.
+func CallRateLimit(rate float64, burst int) Option {
+	return &callRateLimit{rate, burst}
+}
+
+type callRateLimit struct {
+	rate  float64
+	burst int
+}
+
+func (o *callRateLimit) modify(v *visitor) {
+	v.limits.calls = ratelimit.NewBucket(o.rate, o.burst)
+}
+
+// NamespaceCallRateLimit limits the rate at which command requests for the
+// namespace ns are forwarded to Rinq from a single connection. It is applied
+// in addition to any limit set by CallRateLimit.
+func NamespaceCallRateLimit(ns string, rate float64, burst int) Option {
+	return &namespaceCallRateLimit{ns, rate, burst}
+}
+
+type namespaceCallRateLimit struct {
+	ns    string
+	rate  float64
+	burst int
+}
+
+func (o *namespaceCallRateLimit) modify(v *visitor) {
+	if v.limits.namespaces == nil {
+		v.limits.namespaces = map[string]*ratelimit.Bucket{}
+	}
+
+	v.limits.namespaces[o.ns] = ratelimit.NewBucket(o.rate, o.burst)
+}
+
+// OnRateLimit sets the policy used when a client exceeds its rate limits. The
+// default policy is RateLimitReject.
+func OnRateLimit(p RateLimitPolicy) Option {
+	return &onRateLimit{p}
+}
+
+type onRateLimit struct {
+	policy RateLimitPolicy
+}
+
+func (o *onRateLimit) modify(v *visitor) {
+	v.limits.policy = o.policy
+
+	if o.policy == RateLimitClose {
+		v.limits.violations = ratelimit.NewBucket(violationRate, violationBurst)
+	}
+}
+
+// MaxInFlightCalls sets the maximum number of sync calls that may be in
+// progress at the same time on a single connection. A limit of zero means
+// there is no limit.
+func MaxInFlightCalls(max int) Option {
+	return &maxInFlightCalls{max}
+}
+
+type maxInFlightCalls struct {
+	max int
+}
+
+func (o *maxInFlightCalls) modify(v *visitor) {
+	if o.max > 0 {
+		v.inflight.connection = make(chan struct{}, o.max)
+	} else {
+		v.inflight.connection = nil
+	}
+}
+
+// MaxInFlightCallsPerSession sets the maximum number of sync calls that may
+// be in progress at the same time for a single session. A limit of zero means
+// there is no limit.
+func MaxInFlightCallsPerSession(max int) Option {
+	return &maxInFlightCallsPerSession{max}
+}
+
+type maxInFlightCallsPerSession struct {
+	max int
+}
+
+func (o *maxInFlightCallsPerSession) modify(v *visitor) {
+	v.inflight.perSession = o.max
+}
+
+// OnOverload sets the policy used when a sync call would exceed the maximum
+// number of in-flight calls. The default policy is OverloadBackpressure.
+func OnOverload(p OverloadPolicy) Option {
+	return &onOverload{p}
+}
+
+type onOverload struct {
+	policy OverloadPolicy
+}
+
+func (o *onOverload) modify(v *visitor) {
+	v.inflight.policy = o.policy
+}
+
+// MaxBatchWindow sets the upper bounds of the batching window that clients may
+// request. Requests for longer delays or larger batches are reduced to these
+// limits. A zero delay prevents clients from enabling batching.
+func MaxBatchWindow(delay time.Duration, count, bytes int) Option {
+	return &maxBatchWindow{batchWindow{delay, count, bytes}}
+}
+
+type maxBatchWindow struct {
+	window batchWindow
+}
+
+func (o *maxBatchWindow) modify(v *visitor) {
+	v.batchLimits = o.window
+}
+
+// SharedFanOut causes multicast notifications in the namespaces handled by f
+// to be received via f, rather than by each session individually.
+func SharedFanOut(f *FanOut) Option {
+	return &sharedFanOut{f}
+}
+
+type sharedFanOut struct {
+	fanOut *FanOut
+}
+
+func (o *sharedFanOut) modify(v *visitor) {
+	v.fanOut = o.fanOut
+}
+
+// ResumeGracePeriod enables session resumption. The sessions of a client that
+// disconnects are kept alive for the grace period, during which a new
+// connection may resume them by presenting the resume token sent at the start
+// of the original connection.
+func ResumeGracePeriod(d time.Duration) Option {
+	return &resumeGracePeriod{d}
+}
+
+type resumeGracePeriod struct {
+	grace time.Duration
+}
+
+func (o *resumeGracePeriod) modify(*visitor) {}
+
+func (o *resumeGracePeriod) modifyHandler(h *Handler) {
+	h.resumeGrace = o.grace
+}
+
+// ResumeBufferSize sets the number of outgoing messages that are buffered for
+// a disconnected client. A client whose buffer overflows can not be resumed.
+// The default is DefaultResumeBufferSize.
+func ResumeBufferSize(n int) Option {
+	return &resumeBufferSize{n}
+}
+
+type resumeBufferSize struct {
+	size int
+}
+
+func (o *resumeBufferSize) modify(*visitor) {}
+
+func (o *resumeBufferSize) modifyHandler(h *Handler) {
+	if o.size > 0 {
+		h.resumeBufferSize = o.size
+	}
+}
+
+// ResumeBindClientIP causes resume tokens to be bound to the IP address of the
+// client, unless it presented a verified TLS client certificate, in which case
+// the token is bound to the certificate. A client whose IP address changes,
+// such as a mobile client that switches networks, can not resume its sessions.
+//
+// By default, a token is only bound to the client's certificate, if any, and
+// may otherwise be presented by any client.
+func ResumeBindClientIP() Option {
+	return resumeBindClientIP{}
+}
+
+type resumeBindClientIP struct{}
+
+func (o resumeBindClientIP) modify(*visitor) {}
+
+func (o resumeBindClientIP) modifyHandler(h *Handler) {
+	h.resumeBindIP = true
+}
+
+// Idempotency enables idempotency keys for sync calls, using s to remember
+// the results of calls.
+func Idempotency(s *IdempotencyStore) Option {
+	return &idempotency{s}
+}
+
+type idempotency struct {
+	store *IdempotencyStore
+}
+
+func (o *idempotency) modify(v *visitor) {
+	v.idempotency = o.store
+}
+
+// Cache enables caching of responses to sync calls, using c.
+func Cache(c *ResponseCache) Option {
+	return &cache{c}
+}
+
+type cache struct {
+	cache *ResponseCache
+}
+
+func (o *cache) modify(v *visitor) {
+	v.cache = o.cache
+}
+
+// Breakers enables per-namespace circuit breakers for sync calls, using b.
+func Breakers(b *CircuitBreakers) Option {
+	return &breakers{b}
+}
+
+type breakers struct {
+	breakers *CircuitBreakers
+}
+
+func (o *breakers) modify(v *visitor) {
+	v.breakers = o.breakers
+}
+
+// Audit writes a record of each command request to a, if its namespace is
+// audited.
+func Audit(a *Auditor) Option {
+	return &audit{a}
+}
+
+type audit struct {
+	auditor *Auditor
+}
+
+func (o *audit) modify(v *visitor) {
+	v.auditor = o.auditor
 }
@@ -0,0 +1,255 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCallTimeout is the timeout used for calls made without a timeout,
+// when no call policy provides a default.
+const DefaultCallTimeout = 30 * time.Second
+
+// CallPolicy controls how command requests are forwarded to Rinq.
+type CallPolicy struct {
+	// Namespace is the namespace of the command, or "*" to match all
+	// namespaces. A wildcard namespace may only be used with a wildcard
+	// command.
+	Namespace string
+
+	// Command is the name of the command, or "*" to match all commands in the
+	// namespace.
+	Command string
+
+	// DefaultTimeout is the timeout used for calls made without a timeout.
+	DefaultTimeout time.Duration
+
+	// MinTimeout and MaxTimeout bound the timeout of sync and async calls. A
+	// value of zero means there is no bound.
+	MinTimeout time.Duration
+	MaxTimeout time.Duration
+
+	// MaxPayloadSize is the maximum size of the request payload, in bytes. A
+	// value of zero means there is no limit.
+	MaxPayloadSize int
+
+	// DenyExecute prevents clients from executing the command.
+	DenyExecute bool
+
+	// Retry enables automatic retries of sync calls, if non-nil.
+	Retry *RetryPolicy
+}
+
+// timeout returns the timeout to use for a call made with timeout t.
+func (p CallPolicy) timeout(t time.Duration) time.Duration {
+	if t <= 0 {
+		t = p.DefaultTimeout
+	}
+
+	if t <= 0 {
+		t = DefaultCallTimeout
+	}
+
+	if t < p.MinTimeout {
+		t = p.MinTimeout
+	}
+
+	if p.MaxTimeout > 0 && t > p.MaxTimeout {
+		t = p.MaxTimeout
+	}
+
+	return t
+}
+
+// CallPolicies is a table of call policies. The policy for a command is the
+// most specific policy that matches it. Policies are not merged.
+//
+// Handlers that use the same CallPolicies all see the policies installed by
+// Replace(), so the policies of every connection can be reloaded at once.
+type CallPolicies struct {
+	mutex    sync.RWMutex
+	policies map[string]CallPolicy
+}
+
+// NewCallPolicies returns a table containing the given policies.
+func NewCallPolicies(policies ...CallPolicy) *CallPolicies {
+	c := &CallPolicies{
+		policies: map[string]CallPolicy{},
+	}
+
+	for _, p := range policies {
+		c.policies[p.Namespace+"::"+p.Command] = p
+	}
+
+	return c
+}
+
+// Replace replaces the policies in c with those in p. The new policies apply
+// to all subsequent command requests, including those made on existing
+// connections.
+func (c *CallPolicies) Replace(p *CallPolicies) {
+	policies := map[string]CallPolicy{}
+
+	if p != nil {
+		p.mutex.RLock()
+		for k, v := range p.policies {
+			policies[k] = v
+		}
+		p.mutex.RUnlock()
+	}
+
+	c.mutex.Lock()
+	c.policies = policies
+	c.mutex.Unlock()
+}
+
+// lookup returns the policy for the given command. It prefers a policy for the
+// specific command, then for all commands in the namespace, then for all
+// commands. If no policy matches, the zero-value policy is returned.
+func (c *CallPolicies) lookup(ns, cmd string) CallPolicy {
+	if c == nil {
+		return CallPolicy{}
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, k := range [...]string{ns + "::" + cmd, ns + "::*", "*::*"} {
+		if p, ok := c.policies[k]; ok {
+			return p
+		}
+	}
+
+	return CallPolicy{}
+}
+
+// LoadCallPolicies reads a table of call policies from a JSON document, which
+// is an array of objects of the form:
+//
+//	{
+//	    "pattern": "<namespace>::<command>",
+//	    "default_timeout": "5s",
+//	    "min_timeout": "1s",
+//	    "max_timeout": "30s",
+//	    "max_payload_size": 65536,
+//	    "deny_execute": true,
+//	    "retry": {
+//	        "max_attempts": 3,
+//	        "attempt_timeout": "2s",
+//	        "backoff": "100ms",
+//	        "max_backoff": "1s",
+//	        "transient_failures": ["<failure type>"]
+//	    }
+//	}
+//
+// All fields other than "pattern" are optional. Timeouts are in the format
+// accepted by time.ParseDuration().
+func LoadCallPolicies(r io.Reader) (*CallPolicies, error) {
+	var doc []struct {
+		Pattern        string `json:"pattern"`
+		DefaultTimeout string `json:"default_timeout"`
+		MinTimeout     string `json:"min_timeout"`
+		MaxTimeout     string `json:"max_timeout"`
+		MaxPayloadSize int    `json:"max_payload_size"`
+		DenyExecute    bool   `json:"deny_execute"`
+		Retry          *struct {
+			MaxAttempts       int      `json:"max_attempts"`
+			AttemptTimeout    string   `json:"attempt_timeout"`
+			Backoff           string   `json:"backoff"`
+			MaxBackoff        string   `json:"max_backoff"`
+			TransientFailures []string `json:"transient_failures"`
+		} `json:"retry"`
+	}
+
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var policies []CallPolicy
+
+	for _, d := range doc {
+		ns, cmd, err := parsePattern(d.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		p := CallPolicy{
+			Namespace:      ns,
+			Command:        cmd,
+			MaxPayloadSize: d.MaxPayloadSize,
+			DenyExecute:    d.DenyExecute,
+		}
+
+		durations := []durationField{
+			{d.DefaultTimeout, &p.DefaultTimeout},
+			{d.MinTimeout, &p.MinTimeout},
+			{d.MaxTimeout, &p.MaxTimeout},
+		}
+
+		if d.Retry != nil {
+			if d.Retry.MaxAttempts < 1 {
+				return nil, fmt.Errorf("policy %q: retry.max_attempts must be at least 1", d.Pattern)
+			}
+
+			p.Retry = &RetryPolicy{
+				MaxAttempts:       d.Retry.MaxAttempts,
+				TransientFailures: d.Retry.TransientFailures,
+			}
+
+			durations = append(
+				durations,
+				durationField{d.Retry.AttemptTimeout, &p.Retry.AttemptTimeout},
+				durationField{d.Retry.Backoff, &p.Retry.Backoff},
+				durationField{d.Retry.MaxBackoff, &p.Retry.MaxBackoff},
+			)
+		}
+
+		for _, t := range durations {
+			if t.s == "" {
+				continue
+			}
+
+			if *t.d, err = time.ParseDuration(t.s); err != nil {
+				return nil, fmt.Errorf("policy %q: %s", d.Pattern, err)
+			}
+		}
+
+		if p.MaxTimeout > 0 && p.MinTimeout > p.MaxTimeout {
+			return nil, fmt.Errorf("policy %q: min_timeout is greater than max_timeout", d.Pattern)
+		}
+
+		policies = append(policies, p)
+	}
+
+	return NewCallPolicies(policies...), nil
+}
+
+// durationField is a duration in a call policy document, and the field of the
+// CallPolicy that it is parsed into.
+type durationField struct {
+	s string
+	d *time.Duration
+}
+
+// parsePattern parses a "<namespace>::<command>" pattern.
+func parsePattern(s string) (ns, cmd string, err error) {
+	i := strings.Index(s, "::")
+	if i == -1 {
+		return "", "", fmt.Errorf("pattern %q must be in the form <namespace>::<command>", s)
+	}
+
+	ns, cmd = s[:i], s[i+2:]
+
+	if ns == "" || cmd == "" {
+		return "", "", fmt.Errorf("pattern %q must be in the form <namespace>::<command>", s)
+	}
+
+	if ns == "*" && cmd != "*" {
+		return "", "", fmt.Errorf("pattern %q may only use a wildcard namespace with a wildcard command", s)
+	}
+
+	return ns, cmd, nil
+}
@@ -0,0 +1,274 @@
+package native
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+)
+
+var _ = Describe("CallPolicy", func() {
+	Describe("timeout", func() {
+		policy := CallPolicy{
+			DefaultTimeout: 5 * time.Second,
+			MinTimeout:     time.Second,
+			MaxTimeout:     10 * time.Second,
+		}
+
+		It("uses the default timeout if the client does not send one", func() {
+			Expect(policy.timeout(0)).To(Equal(5 * time.Second))
+		})
+
+		It("uses DefaultCallTimeout if there is no default timeout", func() {
+			Expect(CallPolicy{}.timeout(0)).To(Equal(DefaultCallTimeout))
+		})
+
+		It("raises timeouts to the minimum", func() {
+			Expect(policy.timeout(time.Millisecond)).To(Equal(time.Second))
+		})
+
+		It("reduces timeouts to the maximum", func() {
+			Expect(policy.timeout(time.Minute)).To(Equal(10 * time.Second))
+		})
+
+		It("does not change timeouts within the bounds", func() {
+			Expect(policy.timeout(2 * time.Second)).To(Equal(2 * time.Second))
+		})
+	})
+})
+
+var _ = Describe("CallPolicies", func() {
+	Describe("lookup", func() {
+		subject := NewCallPolicies(
+			CallPolicy{Namespace: "ns", Command: "cmd", MaxPayloadSize: 1},
+			CallPolicy{Namespace: "ns", Command: "*", MaxPayloadSize: 2},
+			CallPolicy{Namespace: "*", Command: "*", MaxPayloadSize: 3},
+		)
+
+		It("prefers the policy for the specific command", func() {
+			Expect(subject.lookup("ns", "cmd").MaxPayloadSize).To(Equal(1))
+		})
+
+		It("falls back to the policy for the namespace", func() {
+			Expect(subject.lookup("ns", "other").MaxPayloadSize).To(Equal(2))
+		})
+
+		It("falls back to the policy for all commands", func() {
+			Expect(subject.lookup("other", "cmd").MaxPayloadSize).To(Equal(3))
+		})
+
+		It("returns the zero-value policy if no policy matches", func() {
+			Expect(NewCallPolicies().lookup("ns", "cmd")).To(Equal(CallPolicy{}))
+		})
+
+		It("returns the zero-value policy for a nil table", func() {
+			var subject *CallPolicies
+			Expect(subject.lookup("ns", "cmd")).To(Equal(CallPolicy{}))
+		})
+	})
+
+	Describe("Replace", func() {
+		It("replaces the policies", func() {
+			subject := NewCallPolicies(
+				CallPolicy{Namespace: "ns", Command: "cmd", MaxPayloadSize: 1},
+			)
+
+			subject.Replace(NewCallPolicies(
+				CallPolicy{Namespace: "ns", Command: "*", MaxPayloadSize: 2},
+			))
+
+			Expect(subject.lookup("ns", "cmd").MaxPayloadSize).To(Equal(2))
+		})
+
+		It("removes all policies when given a nil table", func() {
+			subject := NewCallPolicies(
+				CallPolicy{Namespace: "ns", Command: "cmd", MaxPayloadSize: 1},
+			)
+
+			subject.Replace(nil)
+
+			Expect(subject.lookup("ns", "cmd")).To(Equal(CallPolicy{}))
+		})
+	})
+})
+
+var _ = Describe("LoadCallPolicies", func() {
+	It("parses the policies", func() {
+		subject, err := LoadCallPolicies(strings.NewReader(`[
+			{
+				"pattern": "ns::cmd",
+				"default_timeout": "5s",
+				"min_timeout": "1s",
+				"max_timeout": "30s",
+				"max_payload_size": 1024,
+				"deny_execute": true,
+				"retry": {
+					"max_attempts": 3,
+					"attempt_timeout": "2s",
+					"backoff": "100ms",
+					"max_backoff": "1s",
+					"transient_failures": ["busy"]
+				}
+			}
+		]`))
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(subject.lookup("ns", "cmd")).To(Equal(CallPolicy{
+			Namespace:      "ns",
+			Command:        "cmd",
+			DefaultTimeout: 5 * time.Second,
+			MinTimeout:     time.Second,
+			MaxTimeout:     30 * time.Second,
+			MaxPayloadSize: 1024,
+			DenyExecute:    true,
+			Retry: &RetryPolicy{
+				MaxAttempts:       3,
+				AttemptTimeout:    2 * time.Second,
+				Backoff:           100 * time.Millisecond,
+				MaxBackoff:        time.Second,
+				TransientFailures: []string{"busy"},
+			},
+		}))
+	})
+
+	DescribeTable(
+		"returns an error if the document is invalid",
+		func(doc string) {
+			_, err := LoadCallPolicies(strings.NewReader(doc))
+			Expect(err).Should(HaveOccurred())
+		},
+		Entry("malformed JSON", `[`),
+		Entry("missing separator", `[{"pattern": "ns"}]`),
+		Entry("empty command", `[{"pattern": "ns::"}]`),
+		Entry("wildcard namespace", `[{"pattern": "*::cmd"}]`),
+		Entry("invalid duration", `[{"pattern": "ns::cmd", "max_timeout": "soon"}]`),
+		Entry("min greater than max", `[{"pattern": "ns::cmd", "min_timeout": "2s", "max_timeout": "1s"}]`),
+		Entry("no retry attempts", `[{"pattern": "ns::cmd", "retry": {}}]`),
+		Entry("invalid backoff", `[{"pattern": "ns::cmd", "retry": {"max_attempts": 2, "backoff": "soon"}}]`),
+	)
+})
+
+var _ = Describe("visitor call policies", func() {
+	var (
+		sess    *mock.Session
+		sent    []message.Outgoing
+		subject *visitor
+	)
+
+	large := rinq.NewPayloadFromBytes([]byte("0123456789"))
+
+	BeforeEach(func() {
+		sess = mock.NewSession(1)
+
+		sent = nil
+		subject = newVisitor(context.Background(), nil, nil, func(m message.Outgoing) {
+			sent = append(sent, m)
+		})
+		subject.forward = map[message.SessionIndex]rinq.Session{1: sess}
+
+		Policies(NewCallPolicies(CallPolicy{
+			Namespace:      "ns",
+			Command:        "*",
+			MaxTimeout:     time.Second,
+			MaxPayloadSize: 5,
+			DenyExecute:    true,
+		})).modify(subject)
+	})
+
+	It("rejects sync calls with payloads larger than the limit", func() {
+		m := &message.SyncCall{}
+		m.Session = 1
+		m.Seq = 123
+		m.Namespace = "ns"
+		m.Command = "cmd"
+		m.Payload = large
+
+		err := subject.VisitSyncCall(m)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sent).To(ConsistOf(
+			message.NewSyncRejection(1, 123, message.RejectPayloadTooLarge),
+		))
+	})
+
+	It("rejects async calls with payloads larger than the limit", func() {
+		m := &message.AsyncCall{}
+		m.Session = 1
+		m.Namespace = "ns"
+		m.Command = "cmd"
+		m.Payload = large
+
+		err := subject.VisitAsyncCall(m)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sent).To(ConsistOf(
+			message.NewAsyncRejection(1, "ns", "cmd", message.RejectPayloadTooLarge),
+		))
+	})
+
+	It("applies the policy's timeout to async calls", func() {
+		var deadline time.Time
+		sess.Impl.CallAsync = func(ctx context.Context, _, _ string, _ *rinq.Payload) (ident.MessageID, error) {
+			deadline, _ = ctx.Deadline()
+			return ident.MessageID{}, nil
+		}
+
+		m := &message.AsyncCall{}
+		m.Session = 1
+		m.Namespace = "ns"
+		m.Command = "cmd"
+		m.Timeout = time.Minute
+
+		err := subject.VisitAsyncCall(m)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(deadline).To(BeTemporally("~", time.Now().Add(time.Second), 100*time.Millisecond))
+	})
+
+	It("discards executes that the policy denies", func() {
+		sess.Impl.Execute = func(context.Context, string, string, *rinq.Payload) error {
+			Fail("unexpected execute")
+			return nil
+		}
+
+		m := &message.Execute{}
+		m.Session = 1
+		m.Namespace = "ns"
+		m.Command = "cmd"
+
+		err := subject.VisitExecute(m)
+
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("forwards executes in other namespaces", func() {
+		executed := false
+		sess.Impl.Execute = func(context.Context, string, string, *rinq.Payload) error {
+			executed = true
+			return nil
+		}
+
+		m := &message.Execute{}
+		m.Session = 1
+		m.Namespace = "other"
+		m.Command = "cmd"
+
+		err := subject.VisitExecute(m)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(executed).To(BeTrue())
+	})
+
+	It("applies the maximum call timeout after the policy", func() {
+		MaxCallTimeout(time.Millisecond).modify(subject)
+
+		Expect(subject.callTimeout(CallPolicy{DefaultTimeout: time.Second}, 0)).To(Equal(time.Millisecond))
+	})
+})
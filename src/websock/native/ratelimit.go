@@ -0,0 +1,155 @@
+package native
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rinq/httpd/src/internal/ratelimit"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+// RateLimitPolicy determines how a Handler responds to a client that exceeds
+// its rate limits.
+type RateLimitPolicy int
+
+const (
+	// RateLimitReject answers calls that exceed a rate limit with a rejection
+	// message, without forwarding them to Rinq. Executes that exceed the limit
+	// are discarded. Other messages are delayed until the limit allows them to
+	// be processed.
+	RateLimitReject RateLimitPolicy = iota
+
+	// RateLimitDelay stops reading from the connection until the rate limit
+	// allows the message to be processed.
+	RateLimitDelay
+
+	// RateLimitClose behaves like RateLimitReject, but closes the connection
+	// with a policy violation if the client continues to exceed the limits.
+	RateLimitClose
+)
+
+// ParseRateLimitPolicy parses the name of a rate limit policy, one of
+// "reject", "delay" or "close".
+func ParseRateLimitPolicy(s string) (RateLimitPolicy, error) {
+	switch s {
+	case "reject":
+		return RateLimitReject, nil
+	case "delay":
+		return RateLimitDelay, nil
+	case "close":
+		return RateLimitClose, nil
+	default:
+		return 0, fmt.Errorf("unknown rate limit policy %q, expected reject, delay or close", s)
+	}
+}
+
+const (
+	// violationRate and violationBurst control how many rate limit violations
+	// are tolerated before the connection is closed under RateLimitClose.
+	violationRate  = 1
+	violationBurst = 10
+)
+
+// rateLimits holds the rate limits applied to the incoming messages on
+// a single connection.
+type rateLimits struct {
+	policy     RateLimitPolicy
+	frames     *ratelimit.Bucket
+	calls      *ratelimit.Bucket
+	namespaces map[string]*ratelimit.Bucket
+	violations *ratelimit.Bucket
+}
+
+// buckets returns the rate limit buckets that apply to m.
+func (l *rateLimits) buckets(m message.Incoming) []*ratelimit.Bucket {
+	var buckets []*ratelimit.Bucket
+
+	if l.frames != nil {
+		buckets = append(buckets, l.frames)
+	}
+
+	if ns, ok := callNamespace(m); ok {
+		if l.calls != nil {
+			buckets = append(buckets, l.calls)
+		}
+
+		if b, ok := l.namespaces[ns]; ok {
+			buckets = append(buckets, b)
+		}
+	}
+
+	return buckets
+}
+
+// admit applies the connection's rate limits to m. It returns false if m has
+// been rejected and must not be processed.
+func (v *visitor) admit(m message.Incoming) (bool, error) {
+	buckets := v.limits.buckets(m)
+	if len(buckets) == 0 {
+		return true, nil
+	}
+
+	if _, ok := callNamespace(m); !ok || v.limits.policy == RateLimitDelay {
+		var delay time.Duration
+		for _, b := range buckets {
+			if d := b.Reserve(); d > delay {
+				delay = d
+			}
+		}
+
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-v.context.Done():
+				return false, v.context.Err()
+			}
+		}
+
+		return true, nil
+	}
+
+	for _, b := range buckets {
+		if !b.Take() {
+			return false, v.rejectRateLimited(m)
+		}
+	}
+
+	return true, nil
+}
+
+// rejectRateLimited informs the client that m has been rejected because it
+// exceeded a rate limit.
+func (v *visitor) rejectRateLimited(m message.Incoming) error {
+	v.auditRejection(m, message.RejectRateLimited)
+
+	if v.limits.policy == RateLimitClose && !v.limits.violations.Take() {
+		return &websock.CloseError{
+			Code:   websock.ClosePolicyViolation,
+			Reason: "rate limit exceeded",
+		}
+	}
+
+	switch m := m.(type) {
+	case *message.SyncCall:
+		v.send(message.NewSyncRejection(m.Session, m.Seq, message.RejectRateLimited))
+	case *message.AsyncCall:
+		v.send(message.NewAsyncRejection(m.Session, m.Namespace, m.Command, message.RejectRateLimited))
+	}
+
+	return nil
+}
+
+// callNamespace returns the namespace of m, if it is a command request.
+func callNamespace(m message.Incoming) (string, bool) {
+	switch m := m.(type) {
+	case *message.SyncCall:
+		return m.Namespace, true
+	case *message.AsyncCall:
+		return m.Namespace, true
+	case *message.Execute:
+		return m.Namespace, true
+	}
+
+	return "", false
+}
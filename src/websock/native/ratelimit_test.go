@@ -0,0 +1,199 @@
+package native
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock"
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+var _ = Describe("visitor rate limits", func() {
+	var (
+		sent    []message.Outgoing
+		subject *visitor
+	)
+
+	syncCall := func(ns string) *message.SyncCall {
+		m := &message.SyncCall{}
+		m.Session = 0xabcd
+		m.Seq = 123
+		m.Namespace = ns
+		m.Command = "cmd"
+		return m
+	}
+
+	asyncCall := func(ns string) *message.AsyncCall {
+		m := &message.AsyncCall{}
+		m.Session = 0xabcd
+		m.Namespace = ns
+		m.Command = "cmd"
+		return m
+	}
+
+	BeforeEach(func() {
+		sent = nil
+		subject = newVisitor(context.Background(), nil, nil, func(m message.Outgoing) {
+			sent = append(sent, m)
+		})
+	})
+
+	Describe("admit", func() {
+		It("admits all messages when there are no limits", func() {
+			for i := 0; i < 100; i++ {
+				ok, err := subject.admit(syncCall("ns"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeTrue())
+			}
+		})
+
+		Context("when the policy is to reject", func() {
+			BeforeEach(func() {
+				CallRateLimit(0, 1).modify(subject)
+			})
+
+			It("rejects sync calls that exceed the limit", func() {
+				ok, _ := subject.admit(syncCall("ns"))
+				Expect(ok).To(BeTrue())
+
+				ok, err := subject.admit(syncCall("ns"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeFalse())
+				Expect(sent).To(ConsistOf(
+					message.NewSyncRejection(0xabcd, 123, message.RejectRateLimited),
+				))
+			})
+
+			It("rejects async calls that exceed the limit", func() {
+				subject.admit(asyncCall("ns"))
+
+				ok, err := subject.admit(asyncCall("ns"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeFalse())
+				Expect(sent).To(ConsistOf(
+					message.NewAsyncRejection(0xabcd, "ns", "cmd", message.RejectRateLimited),
+				))
+			})
+
+			It("discards executes that exceed the limit", func() {
+				m := &message.Execute{}
+				m.Namespace = "ns"
+
+				subject.admit(m)
+
+				ok, err := subject.admit(m)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeFalse())
+				Expect(sent).To(BeEmpty())
+			})
+
+			It("does not apply call limits to other messages", func() {
+				subject.admit(syncCall("ns"))
+
+				ok, err := subject.admit(&message.Listen{})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeTrue())
+			})
+		})
+
+		It("applies namespace limits only to the namespace", func() {
+			NamespaceCallRateLimit("limited", 0, 1).modify(subject)
+
+			subject.admit(syncCall("limited"))
+
+			ok, _ := subject.admit(syncCall("limited"))
+			Expect(ok).To(BeFalse())
+
+			ok, _ = subject.admit(syncCall("other"))
+			Expect(ok).To(BeTrue())
+		})
+
+		It("delays non-call messages that exceed the frame limit", func() {
+			FrameRateLimit(20, 1).modify(subject)
+
+			subject.admit(&message.Listen{})
+
+			start := time.Now()
+			ok, err := subject.admit(&message.Listen{})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically(">=", 40*time.Millisecond))
+		})
+
+		Context("when the policy is to delay", func() {
+			BeforeEach(func() {
+				CallRateLimit(20, 1).modify(subject)
+				OnRateLimit(RateLimitDelay).modify(subject)
+			})
+
+			It("delays calls that exceed the limit", func() {
+				subject.admit(syncCall("ns"))
+
+				start := time.Now()
+				ok, err := subject.admit(syncCall("ns"))
+
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeTrue())
+				Expect(sent).To(BeEmpty())
+				Expect(time.Since(start)).To(BeNumerically(">=", 40*time.Millisecond))
+			})
+
+			It("stops waiting when the connection is closed", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				subject.context = ctx
+				cancel()
+
+				subject.admit(syncCall("ns"))
+
+				_, err := subject.admit(syncCall("ns"))
+				Expect(err).To(Equal(context.Canceled))
+			})
+		})
+
+		Context("when the policy is to close", func() {
+			BeforeEach(func() {
+				CallRateLimit(0, 1).modify(subject)
+				OnRateLimit(RateLimitClose).modify(subject)
+			})
+
+			It("closes the connection after sustained violations", func() {
+				subject.admit(syncCall("ns"))
+
+				for i := 0; i < violationBurst; i++ {
+					ok, err := subject.admit(syncCall("ns"))
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(ok).To(BeFalse())
+				}
+
+				_, err := subject.admit(syncCall("ns"))
+				Expect(err).To(Equal(&websock.CloseError{
+					Code:   websock.ClosePolicyViolation,
+					Reason: "rate limit exceeded",
+				}))
+			})
+		})
+	})
+})
+
+var _ = Describe("ParseRateLimitPolicy", func() {
+	DescribeTable(
+		"parses policy names",
+		func(s string, expected RateLimitPolicy) {
+			p, err := ParseRateLimitPolicy(s)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(p).To(Equal(expected))
+		},
+		Entry("reject", "reject", RateLimitReject),
+		Entry("delay", "delay", RateLimitDelay),
+		Entry("close", "close", RateLimitClose),
+	)
+
+	It("returns an error for unknown policies", func() {
+		_, err := ParseRateLimitPolicy("explode")
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,254 @@
+package native
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rinq/httpd/src/websock/native/message"
+)
+
+// resumeParam is the query parameter of the upgrade request used by clients to
+// present a resume token.
+const resumeParam = "resume"
+
+// DefaultResumeBufferSize is the default number of outgoing messages that are
+// buffered for a disconnected client.
+const DefaultResumeBufferSize = 256
+
+// resumer keeps the sessions of disconnected clients alive for a grace period,
+// so that they may be resumed by a new connection.
+type resumer struct {
+	grace      time.Duration
+	bufferSize int
+
+	mutex     sync.Mutex
+	suspended map[string]*resumable
+}
+
+// resumable is the state of a connection that may be resumed.
+type resumable struct {
+	token   string
+	binding string
+	visitor *visitor
+	sender  *resumableSender
+	cancel  context.CancelFunc
+	timer   *time.Timer
+}
+
+// resumeBinding returns the value that binds a resume token to the client of
+// r. A connection may only be resumed by a client with the same binding, which
+// is the fingerprint of its verified TLS client certificate, if it presented
+// one.
+//
+// Otherwise, the binding is the client's IP address if bindIP is true, or
+// empty, such that the connection may be resumed by any client that presents
+// the token. Binding to the IP address prevents clients whose address changes,
+// such as mobile clients that switch networks, from resuming their sessions.
+func resumeBinding(r *http.Request, bindIP bool) string {
+	if fingerprint, ok := clientFingerprint(r.TLS); ok {
+		return "tls:" + fingerprint
+	}
+
+	if bindIP {
+		return "ip:" + resolveClient(r).IP
+	}
+
+	return ""
+}
+
+// start returns the state of a new connection, with a new resume token that
+// is bound to the given client binding.
+func (r *resumer) start(binding string, cancel context.CancelFunc) (*resumable, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	s := &resumable{
+		token:   base64.RawURLEncoding.EncodeToString(buf),
+		binding: binding,
+		cancel:  cancel,
+	}
+
+	s.sender = &resumableSender{
+		limit:      r.bufferSize,
+		onOverflow: func() { r.expire(s) },
+	}
+
+	return s, nil
+}
+
+// resume returns the state of the suspended connection with the given token.
+// It returns false if there is no such connection, the token is bound to a
+// different client, or the connection's outgoing messages could not all be
+// buffered.
+//
+// A token presented by a different client does not affect the suspended
+// connection, which may still be resumed by the client it belongs to.
+func (r *resumer) resume(token, binding string) (*resumable, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.suspended[token]
+	if !ok || s.binding != binding {
+		return nil, false
+	}
+
+	delete(r.suspended, token)
+	s.timer.Stop()
+
+	if s.sender.overflowed() {
+		go s.terminate()
+		return nil, false
+	}
+
+	return s, true
+}
+
+// suspend buffers outgoing messages for s until it is resumed, or the grace
+// period elapses.
+//
+// drain is called once messages are being buffered, and returns the messages
+// that were sent to the closed connection but not written to the client.
+// They are buffered ahead of any later messages.
+func (r *resumer) suspend(s *resumable, drain func() []message.Outgoing) {
+	s.sender.detach()
+	s.sender.requeue(drain()...)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.suspended == nil {
+		r.suspended = map[string]*resumable{}
+	}
+
+	r.suspended[s.token] = s
+	s.timer = time.AfterFunc(r.grace, func() { r.expire(s) })
+}
+
+// expire terminates s, unless it has already been resumed.
+func (r *resumer) expire(s *resumable) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.suspended[s.token] != s {
+		return
+	}
+
+	delete(r.suspended, s.token)
+	s.timer.Stop()
+
+	go s.terminate()
+}
+
+// terminate destroys the sessions of a connection that can no longer be
+// resumed.
+func (s *resumable) terminate() {
+	s.cancel()
+	s.visitor.destroySessions()
+}
+
+// resumableSender forwards outgoing messages to the current connection, or
+// buffers them while there is no connection.
+type resumableSender struct {
+	limit      int
+	onOverflow func()
+
+	mutex    sync.Mutex
+	send     func(message.Outgoing)
+	buffer   []message.Outgoing
+	overflow bool
+}
+
+// Send sends m to the current connection, if any. Otherwise it is buffered.
+func (s *resumableSender) Send(m message.Outgoing) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.send != nil {
+		s.send(m)
+		return
+	}
+
+	s.bufferLocked(m)
+}
+
+// bufferLocked adds m to the buffer, discarding the buffer if it is full.
+// s.mutex must be held.
+func (s *resumableSender) bufferLocked(m message.Outgoing) {
+	if s.overflow {
+		return
+	}
+
+	if len(s.buffer) >= s.limit {
+		s.discardLocked()
+		return
+	}
+
+	s.buffer = append(s.buffer, m)
+}
+
+// discardLocked discards the buffer once it has overflowed. s.mutex must be
+// held.
+func (s *resumableSender) discardLocked() {
+	s.overflow = true
+	s.buffer = nil
+	go s.onOverflow()
+}
+
+// attach sends any buffered messages using send, then sends future messages
+// using send.
+func (s *resumableSender) attach(send func(message.Outgoing)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, m := range s.buffer {
+		send(m)
+	}
+
+	s.buffer = nil
+	s.send = send
+}
+
+// detach causes future messages to be buffered.
+func (s *resumableSender) detach() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.send = nil
+}
+
+// requeue adds messages that were sent but not written to the client to the
+// front of the buffer.
+func (s *resumableSender) requeue(unsent ...message.Outgoing) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.overflow || len(unsent) == 0 {
+		return
+	}
+
+	buffer := make([]message.Outgoing, 0, len(unsent)+len(s.buffer))
+	buffer = append(buffer, unsent...)
+	buffer = append(buffer, s.buffer...)
+
+	if len(buffer) > s.limit {
+		s.discardLocked()
+		return
+	}
+
+	s.buffer = buffer
+}
+
+// overflowed returns true if messages were discarded because the buffer was
+// full.
+func (s *resumableSender) overflowed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.overflow
+}
@@ -0,0 +1,220 @@
+package native
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/internal/clientaddr"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+)
+
+var _ = Describe("resumer", func() {
+	nothing := func() []message.Outgoing { return nil }
+
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		sess    *mock.Session
+		state   *resumable
+		subject *resumer
+	)
+
+	BeforeEach(func() {
+		subject = &resumer{
+			grace:      50 * time.Millisecond,
+			bufferSize: 2,
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+
+		var err error
+		state, err = subject.start("ip:192.0.2.1", cancel)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		sess = mock.NewSession(1)
+		state.visitor = newVisitor(ctx, nil, nil, state.sender.Send)
+		state.visitor.forward = map[message.SessionIndex]rinq.Session{1: sess}
+		state.visitor.reverse = map[ident.SessionID]message.SessionIndex{sess.ID(): 1}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("issues a unique token for each connection", func() {
+		other, err := subject.start("ip:192.0.2.1", cancel)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(state.token).ToNot(BeEmpty())
+		Expect(other.token).ToNot(Equal(state.token))
+	})
+
+	It("does not resume connections that have not been suspended", func() {
+		_, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("does not resume unknown tokens", func() {
+		_, ok := subject.resume("unknown", state.binding)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("resumes suspended connections within the grace period", func() {
+		subject.suspend(state, nothing)
+
+		s, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeTrue())
+		Expect(s).To(BeIdenticalTo(state))
+
+		Consistently(sess.Done(), 100*time.Millisecond).ShouldNot(BeClosed())
+		Expect(ctx.Err()).ShouldNot(HaveOccurred())
+	})
+
+	It("only resumes a connection once", func() {
+		subject.suspend(state, nothing)
+		subject.resume(state.token, state.binding)
+
+		_, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("does not resume connections for a different client", func() {
+		subject.suspend(state, nothing)
+
+		_, ok := subject.resume(state.token, "ip:192.0.2.2")
+		Expect(ok).To(BeFalse())
+
+		s, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeTrue())
+		Expect(s).To(BeIdenticalTo(state))
+	})
+
+	It("destroys the sessions once the grace period elapses", func() {
+		subject.suspend(state, nothing)
+
+		Eventually(sess.Done()).Should(BeClosed())
+		Expect(ctx.Err()).Should(HaveOccurred())
+
+		_, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("replays buffered messages in order when resumed", func() {
+		subject.suspend(state, nothing)
+
+		a, b := message.NewSessionDestroy(1), message.NewSessionDestroy(2)
+		state.sender.Send(a)
+		state.sender.Send(b)
+
+		s, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeTrue())
+
+		var sent []message.Outgoing
+		s.sender.attach(func(m message.Outgoing) {
+			sent = append(sent, m)
+		})
+
+		Expect(sent).To(Equal([]message.Outgoing{a, b}))
+	})
+
+	It("does not resume connections whose unsent messages overflowed the buffer", func() {
+		a, b, c := message.NewSessionDestroy(1), message.NewSessionDestroy(2), message.NewSessionDestroy(3)
+
+		subject.suspend(state, func() []message.Outgoing {
+			state.sender.Send(c)
+			return []message.Outgoing{a, b}
+		})
+
+		s, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeFalse())
+		Expect(s).To(BeNil())
+
+		Eventually(sess.Done()).Should(BeClosed())
+	})
+
+	It("replays unsent messages in order when resumed", func() {
+		subject.bufferSize = 3
+		state, _ = subject.start(state.binding, cancel)
+		state.visitor = newVisitor(ctx, nil, nil, state.sender.Send)
+
+		a, b, c := message.NewSessionDestroy(1), message.NewSessionDestroy(2), message.NewSessionDestroy(3)
+
+		subject.suspend(state, func() []message.Outgoing {
+			state.sender.Send(c)
+			return []message.Outgoing{a, b}
+		})
+
+		s, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeTrue())
+
+		var sent []message.Outgoing
+		s.sender.attach(func(m message.Outgoing) {
+			sent = append(sent, m)
+		})
+
+		Expect(sent).To(Equal([]message.Outgoing{a, b, c}))
+	})
+
+	It("does not resume connections whose buffer overflowed", func() {
+		subject.grace = time.Minute
+		subject.suspend(state, nothing)
+
+		for i := 0; i < 3; i++ {
+			state.sender.Send(message.NewSessionDestroy(1))
+		}
+
+		Eventually(sess.Done()).Should(BeClosed())
+
+		_, ok := subject.resume(state.token, state.binding)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("resumeBinding", func() {
+	It("does not bind to the client IP by default", func() {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(clientaddr.NewContext(r.Context(), clientaddr.Info{IP: "10.0.0.1"}))
+
+		Expect(resumeBinding(r, false)).To(BeEmpty())
+	})
+
+	It("binds to the resolved client IP if enabled", func() {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(clientaddr.NewContext(r.Context(), clientaddr.Info{IP: "10.0.0.1"}))
+
+		Expect(resumeBinding(r, true)).To(Equal("ip:10.0.0.1"))
+	})
+
+	It("binds to the remote address if the client IP has not been resolved", func() {
+		r := httptest.NewRequest("GET", "/", nil)
+
+		Expect(resumeBinding(r, true)).To(Equal("ip:192.0.2.1"))
+	})
+})
+
+var _ = Describe("NewHandler", func() {
+	It("enables session resumption if a grace period is given", func() {
+		h := NewHandler(nil, message.JSONEncoding, ResumeBufferSize(10), ResumeGracePeriod(time.Second))
+
+		Expect(h.resumer).To(Equal(&resumer{
+			grace:      time.Second,
+			bufferSize: 10,
+		}))
+	})
+
+	It("binds resume tokens to the client IP if requested", func() {
+		h := NewHandler(nil, message.JSONEncoding, ResumeGracePeriod(time.Second), ResumeBindClientIP())
+		Expect(h.resumeBindIP).To(BeTrue())
+	})
+
+	It("does not enable session resumption by default", func() {
+		h := NewHandler(nil, message.JSONEncoding)
+		Expect(h.resumer).To(BeNil())
+	})
+})
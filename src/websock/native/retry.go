@@ -0,0 +1,133 @@
+package native
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+// retryMetrics contains the number of retried sync calls, by namespace.
+var retryMetrics = expvar.NewMap("rinq_httpd_retries")
+
+// RetryPolicy controls the automatic retrying of sync calls that fail with
+// a transient error. Retries happen within the timeout of the original call,
+// so they must only be enabled for idempotent commands.
+//
+// Errors other than failures, command errors and timeouts, such as those
+// caused by a broker disconnection, are always considered transient.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the command is invoked,
+	// including the first attempt.
+	MaxAttempts int
+
+	// AttemptTimeout is the timeout of each attempt. Attempts that time out
+	// are retried. A value of zero means each attempt may use the remainder of
+	// the call's timeout, in which case timeouts are not retried.
+	AttemptTimeout time.Duration
+
+	// Backoff is the delay before the first retry. It is doubled for each
+	// subsequent retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// TransientFailures is the list of failure types that are retried.
+	TransientFailures []string
+}
+
+// isTransient returns true if a call that failed with err may be retried.
+// ctx is the context of the call as a whole, not of the attempt.
+func (p *RetryPolicy) isTransient(ctx context.Context, err error) bool {
+	switch e := err.(type) {
+	case nil, rinq.CommandError:
+		return false
+	case rinq.Failure:
+		for _, t := range p.TransientFailures {
+			if t == e.Type {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch err {
+	case errUnavailable, context.Canceled:
+		return false
+	case context.DeadlineExceeded:
+		// only the attempt has timed out if the call as a whole has not
+		return p.AttemptTimeout > 0 && ctx.Err() == nil
+	}
+
+	return true
+}
+
+// delay returns the delay before the given retry, where 1 is the first retry.
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+
+	for i := 1; i < retry; i++ {
+		d *= 2
+
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return d
+}
+
+// invokeWithRetry invokes the command, retrying transient errors according to
+// p, if it is non-nil.
+func (v *visitor) invokeWithRetry(
+	ctx context.Context,
+	sess rinq.Session,
+	m *message.SyncCall,
+	p *RetryPolicy,
+) (*rinq.Payload, error) {
+	if p == nil {
+		return v.invoke(ctx, ctx, sess, m)
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := v.attempt(ctx, sess, m, p)
+
+		if attempt >= p.MaxAttempts || !p.isTransient(ctx, err) {
+			return res, err
+		}
+
+		d := p.delay(attempt)
+
+		// don't wait for a retry that can not complete before the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+			return res, err
+		}
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return res, err
+		}
+
+		retryMetrics.Add(m.Namespace, 1)
+	}
+}
+
+// attempt invokes the command once, within the attempt timeout of p.
+func (v *visitor) attempt(
+	ctx context.Context,
+	sess rinq.Session,
+	m *message.SyncCall,
+	p *RetryPolicy,
+) (*rinq.Payload, error) {
+	call := ctx
+
+	if p.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.AttemptTimeout)
+		defer cancel()
+	}
+
+	return v.invoke(call, ctx, sess, m)
+}
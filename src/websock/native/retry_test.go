@@ -0,0 +1,170 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	Describe("delay", func() {
+		It("doubles the backoff for each retry, up to the maximum", func() {
+			subject := &RetryPolicy{
+				Backoff:    100 * time.Millisecond,
+				MaxBackoff: 300 * time.Millisecond,
+			}
+
+			Expect(subject.delay(1)).To(Equal(100 * time.Millisecond))
+			Expect(subject.delay(2)).To(Equal(200 * time.Millisecond))
+			Expect(subject.delay(3)).To(Equal(300 * time.Millisecond))
+			Expect(subject.delay(4)).To(Equal(300 * time.Millisecond))
+		})
+	})
+})
+
+var _ = Describe("visitor retries", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		errs    []error
+		calls   int
+		sess    *mock.Session
+		subject *visitor
+		policy  *RetryPolicy
+	)
+
+	m := &message.SyncCall{}
+	m.Namespace = "ns"
+	m.Command = "cmd"
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+
+		errs = nil
+		calls = 0
+		sess = mock.NewSession(1)
+		sess.Impl.Call = func(ctx context.Context, _, _ string, _ *rinq.Payload) (*rinq.Payload, error) {
+			calls++
+
+			if len(errs) == 0 {
+				return nil, nil
+			}
+
+			err := errs[0]
+			errs = errs[1:]
+
+			if err == context.DeadlineExceeded {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+
+			return nil, err
+		}
+
+		subject = newVisitor(context.Background(), nil, nil, nil)
+
+		policy = &RetryPolicy{
+			MaxAttempts:       3,
+			Backoff:           time.Millisecond,
+			TransientFailures: []string{"busy"},
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("invokes the command once if there is no retry policy", func() {
+		errs = []error{errors.New("transport error")}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, nil)
+
+		Expect(err).To(MatchError("transport error"))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("retries transport errors", func() {
+		errs = []error{errors.New("transport error")}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("retries transient failure types", func() {
+		errs = []error{rinq.Failure{Type: "busy"}}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("does not retry other failure types", func() {
+		errs = []error{rinq.Failure{Type: "not-found"}}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).To(Equal(rinq.Failure{Type: "not-found"}))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("does not retry command errors", func() {
+		errs = []error{rinq.CommandError("panic")}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).Should(HaveOccurred())
+		Expect(calls).To(Equal(1))
+	})
+
+	It("stops after the maximum number of attempts", func() {
+		errs = []error{
+			errors.New("transport error"),
+			errors.New("transport error"),
+			errors.New("transport error"),
+		}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).To(MatchError("transport error"))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("retries attempts that time out", func() {
+		policy.AttemptTimeout = 10 * time.Millisecond
+		errs = []error{context.DeadlineExceeded}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(calls).To(Equal(2))
+	})
+
+	It("does not retry timeouts if there is no attempt timeout", func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		errs = []error{context.DeadlineExceeded}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("does not retry if the backoff would exceed the deadline", func() {
+		policy.Backoff = time.Minute
+		errs = []error{errors.New("transport error")}
+
+		_, err := subject.invokeWithRetry(ctx, sess, m, policy)
+
+		Expect(err).To(MatchError("transport error"))
+		Expect(calls).To(Equal(1))
+	})
+})
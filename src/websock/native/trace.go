@@ -0,0 +1,123 @@
+package native
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/rinq/rinq-go/src/rinq/trace"
+)
+
+// traceParentHeader is the W3C Trace Context header used by clients to
+// provide a trace ID for all of the calls made on a connection.
+const traceParentHeader = "traceparent"
+
+// parseTraceParent returns the trace ID from a W3C traceparent header, which
+// is in the form "<version>-<trace-id>-<parent-id>-<flags>". It returns false
+// if the header is missing or malformed.
+func parseTraceParent(r *http.Request) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(r.Header.Get(traceParentHeader)), "-")
+	if len(parts) < 4 {
+		return "", false
+	}
+
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	// version "ff" is forbidden, and version "00" has exactly four parts
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", false
+	}
+
+	if !isHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+
+	if !isHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return "", false
+	}
+
+	if !isHex(flags, 2) {
+		return "", false
+	}
+
+	return traceID, true
+}
+
+// isHex returns true if s is n lowercase hexadecimal digits.
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// maxTraceIDLength is the maximum length of a trace ID supplied by a client in
+// a message header.
+const maxTraceIDLength = 64
+
+// isTraceID returns true if s is a valid trace ID for a message header, which
+// is 1 to maxTraceIDLength ASCII letters, digits, or any of ".", "_", ":" and
+// "-". Trace IDs are written to logs and sent to other services, so IDs that
+// contain other characters, such as newlines, are not accepted.
+func isTraceID(s string) bool {
+	if len(s) == 0 || len(s) > maxTraceIDLength {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+		case c == '.' || c == '_' || c == ':' || c == '-':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// withTrace attaches a trace ID to ctx. The given ID is used if it is valid,
+// otherwise the connection's trace ID is used, if any. It returns the trace ID
+// that was attached.
+func (v *visitor) withTrace(ctx context.Context, id string) (context.Context, string) {
+	id = v.traceOf(id)
+
+	if id == "" {
+		return ctx, ""
+	}
+
+	return trace.With(ctx, id), id
+}
+
+// traceOf returns the trace ID of a message with the given trace ID, which is
+// the connection's trace ID if id is empty or invalid.
+func (v *visitor) traceOf(id string) string {
+	if !isTraceID(id) {
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+
+		return v.traceID
+	}
+
+	return id
+}
+
+// logf writes a message to the visitor's logger, if it has one. The trace ID
+// is appended if it is non-empty.
+func (v *visitor) logf(traceID string, format string, args ...interface{}) {
+	if v.logger == nil {
+		return
+	}
+
+	if traceID != "" {
+		format += " [trace: %s]"
+		args = append(args, traceID)
+	}
+
+	v.logger.Printf(format, args...)
+}
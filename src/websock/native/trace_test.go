@@ -0,0 +1,166 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/websock/internal/mock"
+	"github.com/rinq/httpd/src/websock/native/message"
+	"github.com/rinq/rinq-go/src/rinq"
+	"github.com/rinq/rinq-go/src/rinq/ident"
+	"github.com/rinq/rinq-go/src/rinq/trace"
+)
+
+var _ = Describe("parseTraceParent", func() {
+	DescribeTable(
+		"returns the trace ID of a valid header",
+		func(header, expected string) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("traceparent", header)
+
+			id, ok := parseTraceParent(r)
+
+			Expect(ok).To(BeTrue())
+			Expect(id).To(Equal(expected))
+		},
+		Entry(
+			"version 00",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"4bf92f3577b34da6a3ce929d0e0e4736",
+		),
+		Entry(
+			"future version with additional fields",
+			"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"4bf92f3577b34da6a3ce929d0e0e4736",
+		),
+	)
+
+	DescribeTable(
+		"returns false for an invalid header",
+		func(header string) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("traceparent", header)
+
+			_, ok := parseTraceParent(r)
+
+			Expect(ok).To(BeFalse())
+		},
+		Entry("empty", ""),
+		Entry("too few fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"),
+		Entry("forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		Entry("version 00 with additional fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"),
+		Entry("short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01"),
+		Entry("uppercase trace ID", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"),
+		Entry("zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"),
+		Entry("zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"),
+		Entry("invalid flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x"),
+	)
+})
+
+var _ = Describe("visitor tracing", func() {
+	var (
+		sess    *mock.Session
+		sent    []message.Outgoing
+		logs    bytes.Buffer
+		traced  string
+		subject *visitor
+	)
+
+	syncCall := func(traceID string) *message.SyncCall {
+		m := &message.SyncCall{}
+		m.Session = 1
+		m.Seq = 123
+		m.Namespace = "ns"
+		m.Command = "cmd"
+		m.Timeout = time.Second
+		m.TraceID = traceID
+		return m
+	}
+
+	BeforeEach(func() {
+		traced = ""
+		sess = mock.NewSession(1)
+		sess.Impl.Call = func(ctx context.Context, _, _ string, _ *rinq.Payload) (*rinq.Payload, error) {
+			traced = trace.Get(ctx)
+			return nil, nil
+		}
+
+		sent = nil
+		logs.Reset()
+		subject = newVisitor(context.Background(), nil, nil, func(m message.Outgoing) {
+			sent = append(sent, m)
+		})
+		subject.forward = map[message.SessionIndex]rinq.Session{1: sess}
+		subject.reverse = map[ident.SessionID]message.SessionIndex{sess.ID(): 1}
+		subject.logger = log.New(&logs, "", 0)
+	})
+
+	It("attaches the call's trace ID to the call and echoes it in the response", func() {
+		subject.call(sess, syncCall("trace-1"), CallPolicy{})
+
+		Expect(traced).To(Equal("trace-1"))
+		Expect(sent).To(HaveLen(1))
+		Expect(sent[0].(*message.SyncSuccess).TraceID).To(Equal("trace-1"))
+	})
+
+	It("uses the connection's trace ID if the call has none", func() {
+		subject.traceID = "trace-2"
+
+		subject.call(sess, syncCall(""), CallPolicy{})
+
+		Expect(traced).To(Equal("trace-2"))
+		Expect(sent[0].(*message.SyncSuccess).TraceID).To(Equal("trace-2"))
+	})
+
+	DescribeTable(
+		"uses the connection's trace ID if the call's trace ID is invalid",
+		func(traceID string) {
+			subject.traceID = "trace-2"
+
+			subject.call(sess, syncCall(traceID), CallPolicy{})
+
+			Expect(traced).To(Equal("trace-2"))
+			Expect(sent[0].(*message.SyncSuccess).TraceID).To(Equal("trace-2"))
+		},
+		Entry("newline", "trace-1\nforged log entry"),
+		Entry("space", "trace 1"),
+		Entry("too long", strings.Repeat("a", 65)),
+	)
+
+	It("does not attach a trace ID if there is none", func() {
+		subject.call(sess, syncCall(""), CallPolicy{})
+
+		Expect(traced).To(BeEmpty())
+		Expect(sent[0].(*message.SyncSuccess).TraceID).To(BeEmpty())
+	})
+
+	It("logs errors that are not sent to the client with the trace ID", func() {
+		sess.Impl.Call = func(context.Context, string, string, *rinq.Payload) (*rinq.Payload, error) {
+			return nil, errors.New("transport error")
+		}
+
+		subject.call(sess, syncCall("trace-1"), CallPolicy{})
+
+		Expect(sent).To(BeEmpty())
+		Expect(logs.String()).To(Equal(
+			"call to ns::cmd from session 1 failed: transport error [trace: trace-1]\n",
+		))
+	})
+
+	It("echoes the trace ID of async responses", func() {
+		ctx := trace.With(context.Background(), "trace-1")
+
+		subject.respond(ctx, sess, ident.MessageID{}, "ns", "cmd", nil, nil)
+
+		Expect(sent).To(HaveLen(1))
+		Expect(sent[0].(*message.AsyncSuccess).TraceID).To(Equal("trace-1"))
+	})
+})
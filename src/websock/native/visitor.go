@@ -3,25 +3,60 @@ package native
 import (
 	"context"
 	"fmt"
+	"log"
+	"net/http"
 	"sync"
+	"time"
 
+	"github.com/rinq/httpd/src/internal/clientaddr"
+	"github.com/rinq/httpd/src/websock"
 	"github.com/rinq/httpd/src/websock/native/message"
 	"github.com/rinq/rinq-go/src/rinq"
 	"github.com/rinq/rinq-go/src/rinq/ident"
-	"time"
+	"github.com/rinq/rinq-go/src/rinq/trace"
 )
 
 type visitor struct {
 	context context.Context
 	peer    rinq.Peer
-	attrs   []rinq.Attr
 	send    func(message.Outgoing)
-
-	mutex   sync.RWMutex
-	forward map[message.SessionIndex]rinq.Session
-	reverse map[ident.SessionID]message.SessionIndex
-
-	syncCallTimeout time.Duration
+	logger  *log.Logger
+
+	mutex sync.RWMutex
+
+	// The following fields describe the client's current connection, and are
+	// replaced by attach() when a suspended connection is resumed. They are
+	// guarded by mutex.
+	attrs        []rinq.Attr
+	traceID      string
+	connectionID uint64
+	clientIP     string
+	connection   context.Context // the context of the upgrade request
+	batcher      *batcher
+	idle         <-chan struct{}
+
+	// capabilities are the optional protocol features supported by the
+	// client. They are guarded by mutex.
+	capabilities message.Capabilities
+
+	forward   map[message.SessionIndex]rinq.Session
+	reverse   map[ident.SessionID]message.SessionIndex
+	listening map[listenKey]struct{}
+
+	maxCallTimeout time.Duration
+	policies       *CallPolicies
+	maxSessions    int
+	limits         rateLimits
+	inflight       inflightLimits
+	conflation     map[listenKey]message.ConflateMode
+	conflator      *conflator
+	batchLimits    batchWindow
+	fanOut         *FanOut
+	idempotency    *IdempotencyStore
+	identity       string
+	cache          *ResponseCache
+	breakers       *CircuitBreakers
+	auditor        *Auditor
 }
 
 func newVisitor(
@@ -35,9 +70,54 @@ func newVisitor(
 		peer:    peer,
 		attrs:   attrs,
 		send:    send,
+		idle:    alwaysIdle,
+		batchLimits: batchWindow{
+			maxDelay: DefaultMaxBatchDelay,
+			maxCount: DefaultMaxBatchCount,
+			maxBytes: DefaultMaxBatchBytes,
+		},
 	}
 }
 
+// attach associates v with the client connection that was upgraded by r,
+// whose outgoing messages are batched by b.
+//
+// The client's attributes, trace ID and address are taken from r. They apply
+// to sessions created after the connection is attached, while existing
+// sessions retain the attributes they were created with.
+func (v *visitor) attach(r *http.Request, b *batcher, idle <-chan struct{}) {
+	attrs := sessionAttributes(r)
+	traceID, _ := parseTraceParent(r)
+	connectionID, _ := websock.ConnectionID(r.Context())
+
+	var clientIP string
+	if client, ok := clientaddr.FromContext(r.Context()); ok {
+		clientIP = client.IP
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.attrs = attrs
+	v.traceID = traceID
+	v.connectionID = connectionID
+	v.clientIP = clientIP
+	v.connection = r.Context()
+	v.batcher = b
+	v.setIdleLocked(idle)
+}
+
+// detach disassociates v from the client's connection once it has closed.
+// Conflated notifications are held until another connection is attached.
+func (v *visitor) detach() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.connection = nil
+	v.batcher = nil
+	v.setIdleLocked(nil)
+}
+
 func (v *visitor) VisitSessionCreate(m *message.SessionCreate) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
@@ -46,6 +126,11 @@ func (v *visitor) VisitSessionCreate(m *message.SessionCreate) error {
 		return fmt.Errorf("session %d already exists", m.Session)
 	}
 
+	if v.maxSessions > 0 && len(v.forward) >= v.maxSessions {
+		v.send(message.NewSessionDestroy(m.Session))
+		return nil
+	}
+
 	sess, err := v.newSession()
 	if err != nil {
 		return err
@@ -59,6 +144,10 @@ func (v *visitor) VisitSessionCreate(m *message.SessionCreate) error {
 	v.forward[m.Session] = sess
 	v.reverse[sess.ID()] = m.Session
 
+	if v.connection != nil {
+		websock.SessionCreated(v.connection)
+	}
+
 	go v.monitor(sess)
 
 	return nil
@@ -73,8 +162,8 @@ func (v *visitor) VisitSessionDestroy(m *message.SessionDestroy) error {
 		return fmt.Errorf("session %d does not exist", m.Session)
 	}
 
-	delete(v.forward, m.Session)
-	delete(v.reverse, sess.ID())
+	v.forget(m.Session, sess)
+	v.stopFanOut(sess)
 	go sess.Destroy()
 
 	return nil
@@ -86,14 +175,22 @@ func (v *visitor) VisitListen(m *message.Listen) error {
 		return fmt.Errorf("session %d does not exist", m.Session)
 	}
 
+	switch m.Conflate {
+	case message.ConflateNone, message.ConflateType, message.ConflateSession:
+	default:
+		return fmt.Errorf("unknown conflation mode %q", m.Conflate)
+	}
+
 	for _, ns := range m.Namespaces {
-		if err := sess.Listen(ns, v.notify); err != nil {
+		if err := v.listen(sess, ns); err != nil {
 			return err
 		}
+
+		v.setListening(m.Session, ns, true)
+		v.setConflation(m.Session, ns, m.Conflate)
 	}
 
 	return nil
-
 }
 
 func (v *visitor) VisitUnlisten(m *message.Unlisten) error {
@@ -103,21 +200,47 @@ func (v *visitor) VisitUnlisten(m *message.Unlisten) error {
 	}
 
 	for _, ns := range m.Namespaces {
-		if err := sess.Unlisten(ns); err != nil {
+		if err := v.unlisten(sess, ns); err != nil {
 			return err
 		}
+
+		v.setListening(m.Session, ns, false)
+		v.setConflation(m.Session, ns, message.ConflateNone)
 	}
 
 	return nil
 }
 
 func (v *visitor) VisitSyncCall(m *message.SyncCall) error {
-	if sess, ok := v.find(m.Session); ok {
-		go v.call(sess, m)
+	sess, ok := v.find(m.Session)
+	if !ok {
+		return fmt.Errorf("session %d does not exist", m.Session)
+	}
+
+	policy := v.policies.lookup(m.Namespace, m.Command)
+	if exceedsPayloadSize(policy, m.Payload) {
+		v.send(message.NewSyncRejection(m.Session, m.Seq, message.RejectPayloadTooLarge))
+		v.auditRejection(m, message.RejectPayloadTooLarge)
+		return nil
+	}
+
+	release, ok, err := v.acquireCall(m.Session)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		v.send(message.NewSyncRejection(m.Session, m.Seq, message.RejectOverloaded))
+		v.auditRejection(m, message.RejectOverloaded)
 		return nil
 	}
 
-	return fmt.Errorf("session %d does not exist", m.Session)
+	go func() {
+		defer release()
+		v.call(sess, m, policy)
+	}()
+
+	return nil
 }
 
 func (v *visitor) VisitAsyncCall(m *message.AsyncCall) error {
@@ -126,19 +249,75 @@ func (v *visitor) VisitAsyncCall(m *message.AsyncCall) error {
 		return fmt.Errorf("session %d does not exist", m.Session)
 	}
 
-	ctx, cancel := context.WithTimeout(v.context, m.Timeout)
+	policy := v.policies.lookup(m.Namespace, m.Command)
+	if exceedsPayloadSize(policy, m.Payload) {
+		v.send(message.NewAsyncRejection(m.Session, m.Namespace, m.Command, message.RejectPayloadTooLarge))
+		v.auditRejection(m, message.RejectPayloadTooLarge)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(v.context, v.callTimeout(policy, m.Timeout))
 	defer cancel()
 
+	ctx, traceID := v.withTrace(ctx, m.TraceID)
+
+	start := time.Now()
 	_, err := sess.CallAsync(ctx, m.Namespace, m.Command, m.Payload)
+	v.audit(auditAsyncCall, m.Session, m.Namespace, m.Command, m.Payload, traceID, start, err)
+
 	return err
 }
 
 func (v *visitor) VisitExecute(m *message.Execute) error {
-	if sess, ok := v.find(m.Session); ok {
-		return sess.Execute(v.context, m.Namespace, m.Command, m.Payload)
+	sess, ok := v.find(m.Session)
+	if !ok {
+		return fmt.Errorf("session %d does not exist", m.Session)
+	}
+
+	// executes have no response, so those not permitted by the policy are
+	// discarded
+	policy := v.policies.lookup(m.Namespace, m.Command)
+	if policy.DenyExecute {
+		v.auditRejection(m, rejectExecuteDenied)
+		return nil
+	}
+
+	if exceedsPayloadSize(policy, m.Payload) {
+		v.auditRejection(m, message.RejectPayloadTooLarge)
+		return nil
 	}
 
-	return fmt.Errorf("session %d does not exist", m.Session)
+	ctx, traceID := v.withTrace(v.context, m.TraceID)
+
+	start := time.Now()
+	err := sess.Execute(ctx, m.Namespace, m.Command, m.Payload)
+	v.audit(auditExecute, m.Session, m.Namespace, m.Command, m.Payload, traceID, start, err)
+
+	return err
+}
+
+func (v *visitor) VisitCapabilities(m *message.Capabilities) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.capabilities = *m
+
+	return nil
+}
+
+// destroySessions destroys all of the sessions.
+func (v *visitor) destroySessions() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for i, sess := range v.forward {
+		delete(v.forward, i)
+		delete(v.reverse, sess.ID())
+		delete(v.inflight.sessions, i)
+		v.clearConflation(i)
+		v.stopFanOut(sess)
+		go sess.Destroy()
+	}
 }
 
 func (v *visitor) newSession() (sess rinq.Session, err error) {
@@ -175,16 +354,125 @@ func (v *visitor) indexOf(sess rinq.Session) (message.SessionIndex, bool) {
 	return i, ok
 }
 
-func (v *visitor) call(sess rinq.Session, m *message.SyncCall) {
+func (v *visitor) call(sess rinq.Session, m *message.SyncCall, policy CallPolicy) {
+	start := time.Now()
 
-	timeout := v.capSyncCallTimeout(m.Timeout)
-	ctx, cancel := context.WithTimeout(v.context, timeout)
+	ctx, cancel := context.WithTimeout(v.context, v.callTimeout(policy, m.Timeout))
 	defer cancel()
 
-	p, err := sess.Call(ctx, m.Namespace, m.Command, m.Payload)
+	ctx, traceID := v.withTrace(ctx, m.TraceID)
+
+	var (
+		p   *rinq.Payload
+		err error
+	)
+
+	if m.IdempotencyKey == "" || v.idempotency == nil {
+		p, err = v.callCached(ctx, sess, m, policy)
+	} else {
+		p, err = v.callIdempotent(ctx, sess, m, policy)
+	}
+
+	v.audit(auditCall, m.Session, m.Namespace, m.Command, m.Payload, traceID, start, err)
+
+	if err == errUnavailable {
+		v.send(message.NewSyncRejection(m.Session, m.Seq, message.RejectUnavailable))
+		return
+	}
+
+	if r, ok := message.NewSyncResponse(m.Session, m.Seq, traceID, p, err); ok {
+		v.send(r)
+	} else if v.context.Err() == nil {
+		v.logf(traceID, "call to %s::%s from session %d failed: %s", m.Namespace, m.Command, m.Session, err)
+	}
+}
+
+// callIdempotent invokes the command unless a call with the same idempotency
+// key has already been made, in which case the result of that call is
+// returned instead.
+func (v *visitor) callIdempotent(
+	ctx context.Context,
+	sess rinq.Session,
+	m *message.SyncCall,
+	policy CallPolicy,
+) (*rinq.Payload, error) {
+	c, first := v.idempotency.begin(idempotencyKey{
+		Identity:  v.identity,
+		Namespace: m.Namespace,
+		Command:   m.Command,
+		Key:       m.IdempotencyKey,
+	})
+
+	if !first {
+		return v.idempotency.wait(ctx, c)
+	}
+
+	p, err := v.callCached(ctx, sess, m, policy)
+	v.idempotency.complete(c, p, err)
 
-	if m, ok := message.NewSyncResponse(m.Session, m.Seq, p, err); ok {
-		v.send(m)
+	return p, err
+}
+
+// callCached invokes the command, or returns a cached response if caching is
+// enabled for the command.
+func (v *visitor) callCached(
+	ctx context.Context,
+	sess rinq.Session,
+	m *message.SyncCall,
+	policy CallPolicy,
+) (*rinq.Payload, error) {
+	if v.cache != nil {
+		if ttl, ok := v.cache.ttl(m.Namespace, m.Command); ok {
+			return v.cache.call(
+				ctx,
+				m.Namespace,
+				m.Command,
+				m.Payload,
+				ttl,
+				func() (*rinq.Payload, error) {
+					return v.invokeWithRetry(ctx, sess, m, policy.Retry)
+				},
+			)
+		}
+	}
+
+	return v.invokeWithRetry(ctx, sess, m, policy.Retry)
+}
+
+// listen starts listening for notifications in ns on behalf of sess. If ns is
+// handled by the FanOut, sess receives multicast notifications via the FanOut,
+// and only targeted notifications via its own listener.
+func (v *visitor) listen(sess rinq.Session, ns string) error {
+	if v.fanOut == nil || !v.fanOut.handles(ns) {
+		return sess.Listen(ns, v.notify)
+	}
+
+	if err := sess.Listen(ns, v.notifyTargeted); err != nil {
+		return err
+	}
+
+	if err := v.fanOut.listen(ns, sess, v.notify); err != nil {
+		_ = sess.Unlisten(ns)
+		return err
+	}
+
+	return nil
+}
+
+// unlisten stops listening for notifications in ns on behalf of sess.
+func (v *visitor) unlisten(sess rinq.Session, ns string) error {
+	if v.fanOut != nil && v.fanOut.handles(ns) {
+		v.fanOut.unlisten(ns, sess)
+	}
+
+	return sess.Unlisten(ns)
+}
+
+// stopFanOut stops dispatching notifications from the FanOut to sess, if a
+// FanOut is in use.
+func (v *visitor) stopFanOut(sess rinq.Session) {
+	if v.fanOut != nil {
+		v.fanOut.unlistenAll(sess)
 	}
 }
 
@@ -195,12 +483,28 @@ func (v *visitor) notify(
 ) {
 	if i, ok := v.indexOf(sess); ok {
 		m := message.NewNotification(i, n)
-		v.send(m)
+		v.sendNotification(m)
 	}
 }
 
+// notifyTargeted is the notification handler for sessions that receive
+// multicast notifications via the FanOut. Multicast notifications are ignored,
+// as they have already been delivered by the FanOut.
+func (v *visitor) notifyTargeted(
+	ctx context.Context,
+	sess rinq.Session,
+	n rinq.Notification,
+) {
+	if n.IsMulticast {
+		n.Payload.Close()
+		return
+	}
+
+	v.notify(ctx, sess, n)
+}
+
 func (v *visitor) respond(
-	_ context.Context,
+	ctx context.Context,
 	sess rinq.Session,
 	_ ident.MessageID,
 	ns string,
@@ -209,8 +513,12 @@ func (v *visitor) respond(
 	err error,
 ) {
 	if i, ok := v.indexOf(sess); ok {
-		if m, ok := message.NewAsyncResponse(i, ns, cmd, p, err); ok {
+		traceID := trace.Get(ctx)
+
+		if m, ok := message.NewAsyncResponse(i, ns, cmd, traceID, p, err); ok {
 			v.send(m)
+		} else {
+			v.logf(traceID, "async call to %s::%s from session %d failed: %s", ns, cmd, i, err)
 		}
 	}
 }
@@ -221,23 +529,34 @@ func (v *visitor) monitor(sess rinq.Session) {
 	select {
 	case <-sess.Done():
 	case <-v.context.Done():
+		v.stopFanOut(sess)
 		return
 	}
 
+	v.stopFanOut(sess)
+
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
 	if i, ok := v.reverse[sess.ID()]; ok {
-		delete(v.forward, i)
-		delete(v.reverse, sess.ID())
+		v.forget(i, sess)
 		v.send(message.NewSessionDestroy(i))
 	}
 }
 
-func (v *visitor) capSyncCallTimeout(t time.Duration) time.Duration {
-	if v.syncCallTimeout == 0 || v.syncCallTimeout > t {
-		return t
+// callTimeout returns the timeout to use for a call made with timeout t, after
+// applying the call's policy and the connection's maximum timeout.
+func (v *visitor) callTimeout(policy CallPolicy, t time.Duration) time.Duration {
+	t = policy.timeout(t)
+
+	if v.maxCallTimeout > 0 && t > v.maxCallTimeout {
+		return v.maxCallTimeout
 	}
 
-	return v.syncCallTimeout
+	return t
+}
+
+// exceedsPayloadSize returns true if p is larger than the policy permits.
+func exceedsPayloadSize(policy CallPolicy, p *rinq.Payload) bool {
+	return policy.MaxPayloadSize > 0 && p.Len() > policy.MaxPayloadSize
 }
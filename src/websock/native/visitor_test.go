@@ -2,10 +2,13 @@ package native
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/rinq/httpd/src/internal/clientaddr"
 	"github.com/rinq/httpd/src/websock/native/message"
 	"github.com/rinq/rinq-go/src/rinq"
 )
@@ -27,12 +30,54 @@ var _ = Describe("visitor", func() {
 		subject = newVisitor(context.Background(), nil, nil, send)
 	})
 
+	Describe("attach", func() {
+		request := func(ip, traceID string) *http.Request {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = ip + ":1234"
+			r.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+			return r.WithContext(clientaddr.NewContext(r.Context(), clientaddr.Info{IP: ip}))
+		}
+
+		It("replaces the state of the previous connection", func() {
+			b := newBatcher(message.JSONEncoding, nil)
+
+			subject.attach(request("192.0.2.1", "4bf92f3577b34da6a3ce929d0e0e4736"), nil, nil)
+			subject.attach(request("192.0.2.2", "0af7651916cd43dd8448eb211c80319c"), b, alwaysIdle)
+
+			Expect(subject.clientIP).To(Equal("192.0.2.2"))
+			Expect(subject.traceID).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+			Expect(subject.attrs).To(ContainElement(rinq.Freeze(HttpdAttrClientIP, "192.0.2.2")))
+			Expect(subject.batcher).To(BeIdenticalTo(b))
+		})
+	})
+
+	Describe("detach", func() {
+		It("removes the connection's batcher", func() {
+			subject.attach(httptest.NewRequest("GET", "/", nil), newBatcher(message.JSONEncoding, nil), alwaysIdle)
+			subject.detach()
+
+			Expect(subject.batcher).To(BeNil())
+			Expect(subject.connection).To(BeNil())
+		})
+	})
+
 	Describe("VisitSessionCreate", func() {
 		msg := &message.SessionCreate{}
 		msg.Session = 0xabcd
 
 		XIt("returns an error if the session index is already in use", func() {
 		})
+
+		It("destroys the session if the session limit has been reached", func() {
+			MaxSessions(1).modify(subject)
+			subject.forward = map[message.SessionIndex]rinq.Session{1: nil}
+
+			err := subject.VisitSessionCreate(msg)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(sent).To(ConsistOf(message.NewSessionDestroy(0xabcd)))
+		})
 	})
 
 	Describe("VisitSessionDestroy", func() {
@@ -0,0 +1,160 @@
+package websock
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/rinq/httpd/src/internal/clientaddr"
+)
+
+// Option modifies how the HTTP handler returned by NewHTTPHandlerWithOptions
+// treats incoming requests.
+type Option interface {
+	modify(*httpHandler)
+}
+
+// TrustedProxies sets the networks containing proxy servers that are trusted
+// to report the client's address using the X-Forwarded-* headers, or the
+// Forwarded header if the ForwardedHeader option is used.
+//
+// By default no proxies are trusted and the client's address is always the
+// remote address of the underlying connection.
+func TrustedProxies(networks ...*net.IPNet) Option {
+	return &trustedProxies{networks}
+}
+
+type trustedProxies struct {
+	networks []*net.IPNet
+}
+
+func (o *trustedProxies) modify(h *httpHandler) {
+	h.trustedProxies = o.networks
+}
+
+// ForwardedHeader causes trusted proxies to report the client's address using
+// the RFC 7239 Forwarded header, instead of X-Forwarded-For.
+//
+// Only one of the headers is honoured, and trusted proxies must overwrite or
+// append to it. Otherwise a client could supply its own value for the header
+// that the proxy does not set.
+func ForwardedHeader() Option {
+	return forwardedHeader{}
+}
+
+type forwardedHeader struct{}
+
+func (forwardedHeader) modify(h *httpHandler) {
+	h.forwardedHeader = clientaddr.Forwarded
+}
+
+// DenyNullOrigin causes upgrade requests with an opaque "null" origin, as sent
+// by sandboxed documents and local files, to be rejected regardless of the
+// allowed origin patterns.
+func DenyNullOrigin() Option {
+	return denyNullOrigin{}
+}
+
+type denyNullOrigin struct{}
+
+func (denyNullOrigin) modify(h *httpHandler) {
+	h.denyNullOrigin = true
+}
+
+// MaxConnections sets the maximum number of concurrent WebSocket connections.
+// Upgrade requests that exceed the limit are rejected with a "503 Service
+// Unavailable" response. A limit of zero means there is no limit.
+func MaxConnections(n int) Option {
+	return maxConnections(n)
+}
+
+type maxConnections int
+
+func (o maxConnections) modify(h *httpHandler) {
+	h.limiter.max = int(o)
+}
+
+// MaxConnectionsPerIP sets the maximum number of concurrent WebSocket
+// connections from a single client IP address. Upgrade requests that exceed
+// the limit are rejected with a "429 Too Many Requests" response. A limit of
+// zero means there is no limit.
+func MaxConnectionsPerIP(n int) Option {
+	return maxConnectionsPerIP(n)
+}
+
+type maxConnectionsPerIP int
+
+func (o maxConnectionsPerIP) modify(h *httpHandler) {
+	h.limiter.maxPerIP = int(o)
+}
+
+// WriteTimeout sets the maximum time allowed to write a single message to a
+// client. Clients that do not accept a message within this time are
+// disconnected. The default is DefaultWriteTimeout.
+func WriteTimeout(d time.Duration) Option {
+	return writeTimeout(d)
+}
+
+type writeTimeout time.Duration
+
+func (o writeTimeout) modify(h *httpHandler) {
+	if o > 0 {
+		h.conn.writeTimeout = time.Duration(o)
+	}
+}
+
+// OutboundQueueSize sets the number of outgoing messages of each priority that
+// may be queued for a single client. The default is DefaultOutboundQueueSize.
+func OutboundQueueSize(n int) Option {
+	return outboundQueueSize(n)
+}
+
+type outboundQueueSize int
+
+func (o outboundQueueSize) modify(h *httpHandler) {
+	if o > 0 {
+		h.conn.queueSize = int(o)
+	}
+}
+
+// SlowClientTimeout sets the maximum time that a client's outbound queue may
+// remain full before the client is disconnected. The default is
+// DefaultSlowClientTimeout.
+func SlowClientTimeout(d time.Duration) Option {
+	return slowClientTimeout(d)
+}
+
+type slowClientTimeout time.Duration
+
+func (o slowClientTimeout) modify(h *httpHandler) {
+	if o > 0 {
+		h.conn.slowClientTimeout = time.Duration(o)
+	}
+}
+
+// AccessLog enables an access log, which records each upgrade request in the
+// given format. Accepted upgrades are recorded when the connection is closed,
+// along with the connection's duration, close code and traffic statistics.
+// Rejected upgrades are recorded immediately.
+func AccessLog(w io.Writer, f AccessLogFormat) Option {
+	return &accessLog{format: f, w: w}
+}
+
+func (o *accessLog) modify(h *httpHandler) {
+	h.access = o
+}
+
+// Connections sets the registry used to track live connections, such that
+// they can be inspected and closed via the admin API.
+func Connections(r *Registry) Option {
+	return registryOption{r}
+}
+
+type registryOption struct {
+	registry *Registry
+}
+
+func (o registryOption) modify(h *httpHandler) {
+	h.registry = o.registry
+	h.limiter.counts = &o.registry.counts
+}
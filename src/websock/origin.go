@@ -1,62 +1,226 @@
 package websock
 
 import (
+	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 )
 
-// newOriginChecker returns a function that returns true if r's Origin header
-// matches the pattern p.
-func newOriginChecker(p string) func(r *http.Request) bool {
+// OriginPattern is a pattern that matches the Origin header of WebSocket
+// upgrade requests.
+//
+// Patterns take one of the following forms:
+//
+//	"*"                           matches any origin
+//	"host.domain.tld"             matches a host exactly
+//	"*.domain.tld"                matches any host with the given suffix
+//	"host.*"                      matches any host with the given prefix
+//	"https://*.domain.tld:8443"   restricts the scheme and/or port
+//	"/^https://[a-z]+\.tld$/"     matches a regular expression
+//
+// Host patterns without a port only match origins that do not specify an
+// explicit port. A port of "*" matches any port, or none at all.
+//
+// Regular expressions are matched against the lowercase origin in the form
+// "scheme://host[:port]", or "null" for opaque origins.
+type OriginPattern struct {
+	source string
+	scheme string
+	host   string
+	port   string
+	regexp *regexp.Regexp
+}
+
+// ParseOriginPattern parses an origin pattern.
+func ParseOriginPattern(p string) (OriginPattern, error) {
+	pattern := OriginPattern{source: p}
+	p = strings.TrimSpace(p)
+
+	if len(p) > 2 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+		re, err := regexp.Compile(p[1 : len(p)-1])
+		if err != nil {
+			return OriginPattern{}, fmt.Errorf("invalid origin pattern %q: %s", pattern.source, err)
+		}
+
+		pattern.regexp = re
+		return pattern, nil
+	}
+
 	p = strings.ToLower(p)
 
-	// fallback to Gorilla's default, which matches against the Host header
-	if p == "" {
-		return nil
+	if i := strings.Index(p, "://"); i != -1 {
+		pattern.scheme, p = p[:i], p[i+3:]
 	}
 
-	// match "*"
-	if p == originWildcard {
-		return func(*http.Request) bool {
-			return true
-		}
+	if host, port, err := net.SplitHostPort(p); err == nil {
+		pattern.host, pattern.port = host, port
+	} else {
+		pattern.host = strings.TrimSuffix(strings.TrimPrefix(p, "["), "]")
 	}
 
-	// match "*.domain.tld"
-	if strings.HasPrefix(p, originWildcard) {
-		suffix := strings.TrimPrefix(p, originWildcard)
-		return func(r *http.Request) bool {
-			return strings.HasSuffix(getOrigin(r), suffix)
-		}
+	if pattern.host == "" || strings.ContainsAny(pattern.host, "/?#") {
+		return OriginPattern{}, fmt.Errorf("invalid origin pattern %q", pattern.source)
 	}
 
-	// match "host.*"
-	if strings.HasSuffix(p, originWildcard) {
-		prefix := strings.TrimSuffix(p, originWildcard)
-		return func(r *http.Request) bool {
-			return strings.HasPrefix(getOrigin(r), prefix)
+	return pattern, nil
+}
+
+// ParseOriginPatterns parses a list of origin patterns.
+func ParseOriginPatterns(patterns ...string) ([]OriginPattern, error) {
+	var result []OriginPattern
+
+	for _, p := range patterns {
+		if strings.TrimSpace(p) == "" {
+			continue
 		}
+
+		op, err := ParseOriginPattern(p)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, op)
+	}
+
+	return result, nil
+}
+
+// String returns the pattern as it was originally specified.
+func (p OriginPattern) String() string {
+	return p.source
+}
+
+// matches returns true if o matches the pattern.
+func (p OriginPattern) matches(o origin) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(o.String())
+	}
+
+	if p.host == originWildcard && p.scheme == "" && p.port == "" {
+		return true
+	}
+
+	if o.Host == "" {
+		return false
+	}
+
+	if p.scheme != "" && p.scheme != o.Scheme {
+		return false
+	}
+
+	if p.port != originWildcard && p.port != o.Port {
+		return false
 	}
 
+	switch {
+	case p.host == originWildcard:
+		return true
+
+	// match "*.domain.tld"
+	case strings.HasPrefix(p.host, originWildcard):
+		return strings.HasSuffix(o.Host, strings.TrimPrefix(p.host, originWildcard))
+
+	// match "host.*"
+	case strings.HasSuffix(p.host, originWildcard):
+		return strings.HasPrefix(o.Host, strings.TrimSuffix(p.host, originWildcard))
+
 	// match "host.domain.tld" exactly
+	default:
+		return o.Host == p.host
+	}
+}
+
+// newOriginChecker returns a function that returns true if r's Origin header
+// matches any of the patterns.
+//
+// If denyNull is true, the opaque "null" origin sent by sandboxed documents
+// and local files is always rejected. Rejected origins are logged to logger,
+// if it is non-nil.
+func newOriginChecker(
+	patterns []OriginPattern,
+	denyNull bool,
+	logger *log.Logger,
+) func(r *http.Request) bool {
+	// fallback to Gorilla's default, which matches against the Host header
+	if len(patterns) == 0 {
+		return nil
+	}
+
 	return func(r *http.Request) bool {
-		return getOrigin(r) == p
+		o := getOrigin(r)
+
+		if !denyNull || !o.IsNull {
+			for _, p := range patterns {
+				if p.matches(o) {
+					return true
+				}
+			}
+		}
+
+		if logger != nil {
+			logger.Printf(
+				"rejected WebSocket upgrade from %s: origin %q is not allowed",
+				r.RemoteAddr,
+				r.Header.Get("Origin"),
+			)
+		}
+
+		return false
 	}
 }
 
-const originWildcard = "*"
+const (
+	originWildcard = "*"
+	originNull     = "null"
+)
+
+// origin is the parsed value of an Origin header.
+type origin struct {
+	Scheme string
+	Host   string
+	Port   string
+	IsNull bool
+}
+
+// String returns the origin in the form "scheme://host[:port]".
+func (o origin) String() string {
+	if o.IsNull {
+		return originNull
+	}
 
-func getOrigin(r *http.Request) string {
-	origin := r.Header["Origin"]
-	if len(origin) == 0 {
+	if o.Host == "" {
 		return ""
 	}
 
-	u, err := url.Parse(origin[0])
+	if o.Port == "" {
+		return o.Scheme + "://" + o.Host
+	}
+
+	return o.Scheme + "://" + net.JoinHostPort(o.Host, o.Port)
+}
+
+func getOrigin(r *http.Request) origin {
+	header := r.Header["Origin"]
+	if len(header) == 0 {
+		return origin{}
+	}
+
+	if strings.ToLower(header[0]) == originNull {
+		return origin{IsNull: true}
+	}
+
+	u, err := url.Parse(header[0])
 	if err != nil {
-		return ""
+		return origin{}
 	}
 
-	return strings.ToLower(u.Host)
+	return origin{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   strings.ToLower(u.Hostname()),
+		Port:   u.Port(),
+	}
 }
@@ -1,6 +1,8 @@
 package websock
 
 import (
+	"bytes"
+	"log"
 	"net/http"
 
 	. "github.com/onsi/ginkgo"
@@ -11,49 +13,80 @@ import (
 var _ = Describe("newOriginChecker", func() {
 	var (
 		withOrigin    http.Request
+		withPort      http.Request
 		withoutOrigin http.Request
 		invalidOrigin http.Request
+		nullOrigin    http.Request
 	)
 
-	BeforeEach(func() {
-		withOrigin.Header = http.Header{}
-		withOrigin.Header.Add("Origin", "https://host.domain.tld")
+	newRequest := func(r *http.Request, origin string) {
+		r.Header = http.Header{}
+		r.Header.Add("Origin", origin)
+	}
 
-		invalidOrigin.Header = http.Header{}
-		invalidOrigin.Header.Add("Origin", ":") // invalid scheme
+	BeforeEach(func() {
+		newRequest(&withOrigin, "https://host.domain.tld")
+		newRequest(&withPort, "http://host.domain.tld:8080")
+		newRequest(&invalidOrigin, ":") // invalid scheme
+		newRequest(&nullOrigin, "null")
 	})
 
-	It("returns nil if the pattern is empty", func() {
-		fn := newOriginChecker("")
+	newChecker := func(p ...string) func(*http.Request) bool {
+		patterns, err := ParseOriginPatterns(p...)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return newOriginChecker(patterns, false, nil)
+	}
+
+	It("returns nil if there are no patterns", func() {
+		fn := newChecker()
 		Expect(fn).To(BeNil())
 	})
 
 	DescribeTable(
 		"returns a checker that returns true for matches",
 		func(p string, r *http.Request) {
-			fn := newOriginChecker(p)
+			fn := newChecker(p)
 			Expect(fn(r)).To(BeTrue())
 		},
 		Entry("any", "*", &withOrigin),
 		Entry("suffix", "*.tld", &withOrigin),
 		Entry("prefix", "host.*", &withOrigin),
 		Entry("exact", "host.domain.tld", &withOrigin),
+		Entry("case-insensitive", "HOST.domain.tld", &withOrigin),
 
-		Entry("any (no origin)", "*", &withoutOrigin),
+		Entry("scheme", "https://host.domain.tld", &withOrigin),
+		Entry("scheme with wildcard host", "https://*", &withOrigin),
+		Entry("scheme with suffix", "https://*.tld", &withOrigin),
+		Entry("port", "host.domain.tld:8080", &withPort),
+		Entry("any port", "host.domain.tld:*", &withPort),
+		Entry("any port (no port)", "host.domain.tld:*", &withOrigin),
+		Entry("scheme and port", "http://*.domain.tld:8080", &withPort),
+
+		Entry("regex", `/^https://[a-z]+\.domain\.tld$/`, &withOrigin),
+		Entry("regex with port", `/:8080$/`, &withPort),
 
+		Entry("any (no origin)", "*", &withoutOrigin),
 		Entry("any (invalid origin)", "*", &invalidOrigin),
+		Entry("any (null origin)", "*", &nullOrigin),
+		Entry("regex (null origin)", "/^null$/", &nullOrigin),
 	)
 
 	DescribeTable(
 		"returns a checker that returns false for non-matches",
 		func(p string, r *http.Request) {
-			fn := newOriginChecker(p)
+			fn := newChecker(p)
 			Expect(fn(r)).To(BeFalse())
 		},
 		Entry("suffix", "*.other", &withOrigin),
 		Entry("prefix", "other.*", &withOrigin),
 		Entry("exact", "host.other.tld", &withOrigin),
 
+		Entry("scheme", "http://host.domain.tld", &withOrigin),
+		Entry("port", "host.domain.tld:8443", &withPort),
+		Entry("unexpected port", "host.domain.tld", &withPort),
+		Entry("regex", `/^http://`, &withOrigin),
+
 		Entry("suffix (no origin)", "*.tld", &withoutOrigin),
 		Entry("prefix (no origin)", "host.*", &withoutOrigin),
 		Entry("exact (no origin)", "host.domain.tld", &withoutOrigin),
@@ -61,5 +94,53 @@ var _ = Describe("newOriginChecker", func() {
 		Entry("suffix (invalid origin)", "*.tld", &invalidOrigin),
 		Entry("prefix (invalid origin)", "host.*", &invalidOrigin),
 		Entry("exact (invalid origin)", "host.domain.tld", &invalidOrigin),
+
+		Entry("suffix (null origin)", "*.tld", &nullOrigin),
+		Entry("scheme (null origin)", "https://*", &nullOrigin),
+	)
+
+	It("returns true if any of the patterns match", func() {
+		fn := newChecker("app.example.com", "*.domain.tld")
+		Expect(fn(&withOrigin)).To(BeTrue())
+	})
+
+	It("rejects the null origin when denyNull is true", func() {
+		patterns, err := ParseOriginPatterns("*")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		fn := newOriginChecker(patterns, true, nil)
+		Expect(fn(&nullOrigin)).To(BeFalse())
+		Expect(fn(&withOrigin)).To(BeTrue())
+	})
+
+	It("logs rejected origins", func() {
+		var buf bytes.Buffer
+
+		patterns, err := ParseOriginPatterns("app.example.com")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		fn := newOriginChecker(patterns, false, log.New(&buf, "", 0))
+		fn(&withOrigin)
+
+		Expect(buf.String()).To(ContainSubstring(`origin "https://host.domain.tld" is not allowed`))
+	})
+})
+
+var _ = Describe("ParseOriginPatterns", func() {
+	It("ignores empty patterns", func() {
+		patterns, err := ParseOriginPatterns("", " ", "*")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(patterns).To(HaveLen(1))
+	})
+
+	DescribeTable(
+		"returns an error for invalid patterns",
+		func(p string) {
+			_, err := ParseOriginPatterns(p)
+			Expect(err).Should(HaveOccurred())
+		},
+		Entry("invalid regex", "/[/"),
+		Entry("empty host", "https://"),
+		Entry("path", "https://host.domain.tld/path"),
 	)
 })
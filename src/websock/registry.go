@@ -0,0 +1,176 @@
+package websock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// CloseKicked is the WebSocket close code used when a connection is closed
+// by an administrator.
+const CloseKicked = ClosePolicyViolation
+
+// Registry tracks the live connections of one or more HTTP handlers, such that
+// they can be inspected and closed via the admin API.
+//
+// Handlers that share a registry also share their connection counts, such that
+// the MaxConnections and MaxConnectionsPerIP limits apply across all of them.
+// This allows a handler to be replaced by one with a new configuration without
+// losing track of the connections that are still served by the old handler.
+type Registry struct {
+	mutex  sync.RWMutex
+	conns  map[uint64]*connectionInfo
+	counts connCounts
+}
+
+// NewRegistry returns a new, empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		conns: map[uint64]*connectionInfo{},
+	}
+}
+
+// ConnectionSummary describes a live connection.
+type ConnectionSummary struct {
+	ID          uint64    `json:"id"`
+	ClientIP    string    `json:"client_ip"`
+	Origin      string    `json:"origin,omitempty"`
+	Protocol    string    `json:"protocol"`
+	Established time.Time `json:"established"`
+	AgeSeconds  float64   `json:"age_seconds"`
+	Sessions    int       `json:"sessions"`
+	QueueDepth  int       `json:"queue_depth"`
+}
+
+// Connections returns a summary of each live connection, ordered by ID.
+func (r *Registry) Connections() []ConnectionSummary {
+	infos := r.snapshot()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].id < infos[j].id
+	})
+
+	result := make([]ConnectionSummary, len(infos))
+	for i, info := range infos {
+		result[i] = ConnectionSummary{
+			ID:          info.id,
+			ClientIP:    info.clientIP,
+			Origin:      info.origin,
+			Protocol:    info.protocol,
+			Established: info.established,
+			AgeSeconds:  time.Since(info.established).Seconds(),
+			QueueDepth:  info.conn.queueDepth(),
+		}
+
+		if inspector, ok := info.sessionInspector(); ok {
+			result[i].Sessions = len(inspector.Sessions())
+		}
+	}
+
+	return result
+}
+
+// Sessions returns information about each of the sessions on the connection
+// with the given ID. It returns false if there is no such connection.
+func (r *Registry) Sessions(id uint64) ([]SessionInfo, bool) {
+	info, ok := r.find(id)
+	if !ok {
+		return nil, false
+	}
+
+	if inspector, ok := info.sessionInspector(); ok {
+		return inspector.Sessions(), true
+	}
+
+	return []SessionInfo{}, true
+}
+
+// CloseConnection closes the connection with the given ID, sending the reason
+// to the client with the CloseKicked close code. It returns false if there is
+// no such connection.
+func (r *Registry) CloseConnection(id uint64, reason string) bool {
+	info, ok := r.find(id)
+	if !ok {
+		return false
+	}
+
+	info.conn.kick(&CloseError{Code: CloseKicked, Reason: reason})
+
+	return true
+}
+
+// DestroySession destroys the session with the given ID on the connection with
+// the given ID. It returns false if there is no such connection or session.
+func (r *Registry) DestroySession(id uint64, session, reason string) bool {
+	info, ok := r.find(id)
+	if !ok {
+		return false
+	}
+
+	if inspector, ok := info.sessionInspector(); ok {
+		return inspector.DestroySession(session, reason)
+	}
+
+	return false
+}
+
+// Len returns the number of live connections.
+func (r *Registry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.conns)
+}
+
+// CloseAll closes every live connection, sending the reason to the client with
+// the CloseGoingAway close code. It is used to close the connections that
+// remain when the server has finished draining.
+func (r *Registry) CloseAll(reason string) {
+	for _, info := range r.snapshot() {
+		info.conn.kick(&CloseError{Code: CloseGoingAway, Reason: reason})
+	}
+}
+
+// snapshot returns the live connections, in no particular order.
+func (r *Registry) snapshot() []*connectionInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	infos := make([]*connectionInfo, 0, len(r.conns))
+	for _, info := range r.conns {
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// find returns the connection with the given ID.
+func (r *Registry) find(id uint64) (*connectionInfo, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	info, ok := r.conns[id]
+	return info, ok
+}
+
+// add adds a connection to the registry. It is a no-op if r is nil.
+func (r *Registry) add(info *connectionInfo) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	r.conns[info.id] = info
+	r.mutex.Unlock()
+}
+
+// remove removes a connection from the registry. It is a no-op if r is nil.
+func (r *Registry) remove(info *connectionInfo) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	delete(r.conns, info.id)
+	r.mutex.Unlock()
+}